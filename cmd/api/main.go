@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -10,7 +11,6 @@ import (
 	"os/signal"
 	"syscall"
 
-	"github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/gin-gonic/gin"
 	_ "github.com/lib/pq"
 	"github.com/nats-io/nats.go"
@@ -18,6 +18,11 @@ import (
 	"github.com/voikin/hezzl-test/config"
 	"github.com/voikin/hezzl-test/internal/controller"
 	"github.com/voikin/hezzl-test/internal/repository"
+	clickhouseRepo "github.com/voikin/hezzl-test/internal/repository/clickhouse"
+	"github.com/voikin/hezzl-test/internal/repository/local"
+	"github.com/voikin/hezzl-test/internal/repository/memory"
+	natsRepo "github.com/voikin/hezzl-test/internal/repository/nats"
+	redisRepo "github.com/voikin/hezzl-test/internal/repository/redis"
 	"github.com/voikin/hezzl-test/internal/service"
 )
 
@@ -39,25 +44,7 @@ func main() {
 		log.Fatalf("failed to ping PostgreSQL: %v", err)
 	}
 
-	nc, err := nats.Connect(cfg.Nats.URL)
-	if err != nil {
-		log.Fatalf("failed to connect to NATS: %v", err)
-	}
-	defer func() { _ = nc.Drain() }()
-
-	js, err := nc.JetStream()
-	if err != nil {
-		log.Fatalf("failed to create JetStream context: %v", err)
-	}
-
-	conn, err := clickhouse.Open(&clickhouse.Options{
-		Addr: []string{fmt.Sprintf("%s:%d", cfg.Clickhouse.Addr, cfg.Clickhouse.NativePort)},
-		Auth: clickhouse.Auth{
-			Database: cfg.Clickhouse.DB,
-			Username: cfg.Clickhouse.Username,
-			Password: cfg.Clickhouse.Password,
-		},
-	})
+	conn, err := clickhouseRepo.Open(cfg.Clickhouse)
 	if err != nil {
 		log.Fatalf("failed to create ClickHouse connection: %v", err)
 	}
@@ -70,13 +57,80 @@ func main() {
 	})
 	defer redisClient.Close()
 
-	repos := repository.NewRepositories(pg, conn, js, redisClient)
-	services := service.NewServices(repos, js)
+	localCache := local.NewCache(cfg.Cache.LocalTTL, cfg.Cache.LocalMaxEntries)
+
+	var (
+		publisher   repository.EventPublisher
+		subscriber  repository.EventSubscriber
+		deadLetters repository.DeadLetterRepo
+		invalidator redisRepo.Invalidator
+	)
+
+	switch cfg.Bus {
+	case "memory":
+		memoryBus := memory.NewEventBus(cfg.Nats.Stream)
+		publisher, subscriber, invalidator = memoryBus, memoryBus, memory.Invalidator{}
+		deadLetters = memory.NewDeadLetterRepo()
+	case "redis":
+		// без NATS локальные кэши реплик не инвалидируются и живут до cache.local_ttl
+		streamBus, err := redisRepo.NewStreamBus(redisClient, cfg.Redis.Stream)
+		if err != nil {
+			log.Fatalf("failed to create Redis stream bus: %v", err)
+		}
+		defer streamBus.Close()
+		publisher, subscriber, invalidator = streamBus, streamBus, memory.Invalidator{}
+		deadLetters = redisRepo.NewDeadLetterRepo(redisClient, cfg.Redis.Stream.DeadLetterKey)
+	case "nats":
+		nc, err := nats.Connect(cfg.Nats.URL)
+		if err != nil {
+			log.Fatalf("failed to connect to NATS: %v", err)
+		}
+		defer nc.Close()
+
+		js, err := nc.JetStream()
+		if err != nil {
+			log.Fatalf("failed to create JetStream context: %v", err)
+		}
+
+		natsPublisher, err := natsRepo.NewPublisher(nc, cfg.Nats.Publish)
+		if err != nil {
+			log.Fatalf("failed to create NATS publisher: %v", err)
+		}
+		defer natsPublisher.Close()
+		publisher = natsPublisher
+
+		natsSubscriber, err := natsRepo.NewSubscriber(js, cfg.Nats.Stream, cfg.Nats.FeedStream, cfg.Nats.Consumer, cfg.Webhook.Consumer)
+		if errors.Is(err, natsRepo.ErrMigrationPending) {
+			// публикации пока уходят в старый стрим, консьюмеры подключатся сами после cmd/migrate
+			log.Printf("event consumers are waiting for the stream migration: %v", err)
+		} else if err != nil {
+			log.Fatalf("failed to create NATS subscriber: %v", err)
+		}
+		subscriber = natsSubscriber
+
+		deadLetters, err = natsRepo.NewDeadLetterRepo(js, cfg.Nats.DeadLetter, cfg.Nats.Stream.Replicas)
+		if err != nil {
+			log.Fatalf("failed to create dead letter stream: %v", err)
+		}
+
+		invalidator, err = natsRepo.NewInvalidationBus(nc, cfg.Cache.InvalidationSubject, localCache)
+		if err != nil {
+			log.Fatalf("failed to create cache invalidation bus: %v", err)
+		}
+	default:
+		log.Fatalf("unknown event bus %q", cfg.Bus)
+	}
+
+	repos := repository.NewRepositories(cfg, pg, conn, redisClient, localCache, invalidator, publisher, subscriber, deadLetters)
+	services := service.NewServices(repos, cfg)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	go services.EventSaver.Start(ctx)
+	if cfg.EventSaver.Embedded {
+		services.EventSaver.Start(ctx)
+	}
+	services.WebhookDispatcher.Start(ctx)
 
 	ginEngine := gin.Default()
 	controller.RegisterRoutes(ginEngine, services)
@@ -85,10 +139,12 @@ func main() {
 		Addr:    fmt.Sprintf(":%s", cfg.Port),
 		Handler: ginEngine,
 	}
+	// долгие SSE/WebSocket-потоки Shutdown сам не закрывает
+	server.RegisterOnShutdown(services.Feed.Close)
 
 	go func() {
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("ailed to start HTTP server: %v", err)
+			log.Fatalf("failed to start HTTP server: %v", err)
 		}
 	}()
 
@@ -98,12 +154,44 @@ func main() {
 
 	log.Println("Shutting down server...")
 
-	cancel()
-	<-ctx.Done()
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancelShutdown()
 
-	if err := server.Shutdown(ctx); err != nil {
-		log.Fatalf("server shutdown failed: %v", err)
+	// 1. перестаем принимать запросы и ждем текущие, чтобы они успели опубликовать свои события
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("server shutdown: %v", err)
 	}
 
+	// 2. дожидаемся отправки опубликованных событий
+	if err := wait(shutdownCtx, publisher.Flush); err != nil {
+		log.Printf("publisher flush: %v", err)
+	}
+
+	// 3. останавливаем чтение стрима и даем воркерам записать текущие пачки
+	cancel()
+	if err := services.EventSaver.Wait(shutdownCtx); err != nil {
+		log.Printf("event saver: %v", err)
+	}
+	if err := services.WebhookDispatcher.Wait(shutdownCtx); err != nil {
+		log.Printf("webhook dispatcher: %v", err)
+	}
+
+	// 4. соединения закрываются отложенными вызовами в обратном порядке: NATS, Redis, ClickHouse, PostgreSQL
 	log.Println("Server stopped gracefully")
 }
+
+// wait выполняет fn, но ждет ее не дольше ctx.
+func wait(ctx context.Context, fn func()) error {
+	done := make(chan struct{})
+	go func() {
+		fn()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
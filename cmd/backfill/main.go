@@ -0,0 +1,215 @@
+// backfill записывает в ClickHouse снимки текущего состояния всех товаров из Postgres,
+// чтобы у товаров, созданных до появления конвейера событий, была хотя бы одна строка истории.
+//
+//	go run ./cmd/backfill
+//	go run ./cmd/backfill -after-id 15000 -batch 2000 -dry-run
+//
+// Снимок пишется только для товаров без единой строки в logs.goods, поэтому в историю товаров
+// с настоящими событиями (и в повторный запуск) лишние события не попадают. Снимок получает
+// id snapshot:<projectId>:<id> и время на секунду раньше чтения страницы из Postgres: любое
+// изменение после чтения новее снимка и выигрывает argMax и FINAL.
+// После каждой записанной пачки последний id сохраняется в -state, и следующий запуск продолжает с него.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/voikin/hezzl-test/config"
+	"github.com/voikin/hezzl-test/internal/domain/event"
+	"github.com/voikin/hezzl-test/internal/domain/good"
+	clickhouseRepo "github.com/voikin/hezzl-test/internal/repository/clickhouse"
+	"github.com/voikin/hezzl-test/internal/repository/postgres"
+)
+
+const configPath = "./config/config.yaml"
+
+type goodRepo interface {
+	GetGoodsAfter(ctx context.Context, afterId, limit int) ([]good.Good, error)
+}
+
+type eventRepo interface {
+	CreateEvent(ctx context.Context, event []event.ClickhouseEvent) error
+	GoodsWithEvents(ctx context.Context, ids []int) (map[int]struct{}, error)
+}
+
+func main() {
+	var (
+		afterId   int
+		batchSize int
+		statePath string
+		dryRun    bool
+	)
+	flag.IntVar(&afterId, "after-id", -1, "start after this good id (default - resume from -state)")
+	flag.IntVar(&batchSize, "batch", 1000, "goods per batch")
+	flag.StringVar(&statePath, "state", "./backfill.state", "file with the last processed good id")
+	flag.BoolVar(&dryRun, "dry-run", false, "only report what would be inserted")
+	flag.Parse()
+
+	cfg, err := config.New(configPath)
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+
+	if afterId < 0 {
+		afterId, err = readState(statePath)
+		if err != nil {
+			log.Fatalf("failed to read state: %v", err)
+		}
+	}
+
+	pg, err := sql.Open("postgres", cfg.Postgres.URL)
+	if err != nil {
+		log.Fatalf("failed to connect to PostgreSQL: %v", err)
+	}
+	defer pg.Close()
+
+	conn, err := clickhouseRepo.Open(cfg.Clickhouse)
+	if err != nil {
+		log.Fatalf("failed to create ClickHouse connection: %v", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	b := &backfiller{
+		goods:     postgres.NewGoodRepo(pg),
+		events:    clickhouseRepo.NewEventRepo(conn, cfg.Clickhouse),
+		batchSize: batchSize,
+		statePath: statePath,
+		dryRun:    dryRun,
+	}
+
+	log.Printf("backfill starting after id %d", afterId)
+	lastId, err := b.run(ctx, afterId)
+
+	log.Printf("read %d, inserted %d, skipped with existing history %d, last id %d", b.read, b.inserted, b.skipped, lastId)
+	if err != nil {
+		log.Fatalf("backfill failed: %v", err)
+	}
+}
+
+type backfiller struct {
+	goods     goodRepo
+	events    eventRepo
+	batchSize int
+	statePath string
+	dryRun    bool
+
+	read     int
+	inserted int
+	skipped  int
+}
+
+// run обходит товары по возрастанию id и возвращает последний обработанный id.
+func (b *backfiller) run(ctx context.Context, afterId int) (int, error) {
+	for ctx.Err() == nil {
+		// DateTime хранит секунды: снимок на секунду старше чтения строго раньше
+		// событий любого изменения, сделанного после него
+		snapshotTime := time.Now().UTC().Truncate(time.Second).Add(-time.Second)
+
+		goods, err := b.goods.GetGoodsAfter(ctx, afterId, b.batchSize)
+		if err != nil {
+			return afterId, err
+		}
+		if len(goods) == 0 {
+			return afterId, nil
+		}
+
+		if err := b.writeBatch(ctx, goods, snapshotTime); err != nil {
+			return afterId, err
+		}
+
+		afterId = goods[len(goods)-1].ID
+		if !b.dryRun {
+			if err := writeState(b.statePath, afterId); err != nil {
+				return afterId, err
+			}
+		}
+	}
+
+	return afterId, ctx.Err()
+}
+
+func (b *backfiller) writeBatch(ctx context.Context, goods []good.Good, snapshotTime time.Time) error {
+	b.read += len(goods)
+
+	ids := make([]int, 0, len(goods))
+	for _, g := range goods {
+		ids = append(ids, g.ID)
+	}
+
+	existing, err := b.events.GoodsWithEvents(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	toInsert := make([]event.ClickhouseEvent, 0, len(goods))
+	for _, g := range goods {
+		if _, ok := existing[g.ID]; ok {
+			b.skipped++
+			continue
+		}
+		toInsert = append(toInsert, event.ClickhouseEvent{
+			EventId:     event.SnapshotEventId(g.ProjectId, g.ID),
+			Type:        event.TypeGoodSnapshot,
+			Id:          g.ID,
+			ProjectId:   g.ProjectId,
+			Name:        g.Name,
+			Description: g.Description,
+			Priority:    g.Priority,
+			Removed:     g.Removed,
+			EventTime:   snapshotTime,
+		})
+	}
+
+	if len(toInsert) == 0 {
+		return nil
+	}
+
+	if b.dryRun {
+		log.Printf("dry-run: would insert %d snapshots (ids %d..%d)", len(toInsert), goods[0].ID, goods[len(goods)-1].ID)
+	} else if err := b.events.CreateEvent(ctx, toInsert); err != nil {
+		return err
+	}
+
+	b.inserted += len(toInsert)
+	return nil
+}
+
+func readState(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+	return id, nil
+}
+
+// writeState пишет состояние через временный файл, чтобы обрыв не оставил его пустым.
+func writeState(path string, id int) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.Itoa(id)+"\n"), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/voikin/hezzl-test/internal/domain/event"
+	"github.com/voikin/hezzl-test/internal/domain/good"
+)
+
+type fakeGoodRepo struct {
+	goods  []good.Good
+	readAt []time.Time
+}
+
+func (r *fakeGoodRepo) GetGoodsAfter(_ context.Context, afterId, limit int) ([]good.Good, error) {
+	r.readAt = append(r.readAt, time.Now().UTC())
+
+	var page []good.Good
+	for _, g := range r.goods {
+		if g.ID > afterId && len(page) < limit {
+			page = append(page, g)
+		}
+	}
+	return page, nil
+}
+
+// fakeEventRepo - logs.goods в памяти: все строки по id товара.
+type fakeEventRepo struct {
+	rows map[int][]event.ClickhouseEvent
+}
+
+func (r *fakeEventRepo) CreateEvent(_ context.Context, events []event.ClickhouseEvent) error {
+	for _, ev := range events {
+		r.rows[ev.Id] = append(r.rows[ev.Id], ev)
+	}
+	return nil
+}
+
+func (r *fakeEventRepo) GoodsWithEvents(_ context.Context, ids []int) (map[int]struct{}, error) {
+	existing := make(map[int]struct{})
+	for _, id := range ids {
+		if len(r.rows[id]) > 0 {
+			existing[id] = struct{}{}
+		}
+	}
+	return existing, nil
+}
+
+func newBackfiller(t *testing.T, goods []good.Good, events *fakeEventRepo) (*backfiller, *fakeGoodRepo) {
+	t.Helper()
+
+	goodRepo := &fakeGoodRepo{goods: goods}
+	return &backfiller{
+		goods:     goodRepo,
+		events:    events,
+		batchSize: 2,
+		statePath: filepath.Join(t.TempDir(), "backfill.state"),
+	}, goodRepo
+}
+
+// TestBackfillSkipsGoodsWithHistory: снимок получают только товары без строк в logs.goods.
+func TestBackfillSkipsGoodsWithHistory(t *testing.T) {
+	goods := []good.Good{
+		{ID: 1, ProjectId: 1, Name: "one"},
+		{ID: 2, ProjectId: 1, Name: "two"},
+		{ID: 3, ProjectId: 2, Name: "three"},
+	}
+	events := &fakeEventRepo{rows: map[int][]event.ClickhouseEvent{
+		2: {{EventId: "real", Type: event.TypeGoodUpdated, Id: 2, ProjectId: 1, Name: "two"}},
+	}}
+
+	b, _ := newBackfiller(t, goods, events)
+	lastId, err := b.run(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if lastId != 3 || b.read != 3 || b.inserted != 2 || b.skipped != 1 {
+		t.Fatalf("lastId %d read %d inserted %d skipped %d, want 3 3 2 1", lastId, b.read, b.inserted, b.skipped)
+	}
+
+	if rows := events.rows[2]; len(rows) != 1 || rows[0].EventId != "real" {
+		t.Fatalf("good with history got extra rows: %+v", rows)
+	}
+	for _, id := range []int{1, 3} {
+		rows := events.rows[id]
+		if len(rows) != 1 || rows[0].Type != event.TypeGoodSnapshot || rows[0].EventId != event.SnapshotEventId(rows[0].ProjectId, id) {
+			t.Fatalf("good %d: want one snapshot, got %+v", id, rows)
+		}
+	}
+
+	// повторный запуск с начала ничего не пишет
+	again, _ := newBackfiller(t, goods, events)
+	if _, err := again.run(context.Background(), 0); err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+	if again.inserted != 0 || again.skipped != 3 {
+		t.Fatalf("second run inserted %d skipped %d, want 0 3", again.inserted, again.skipped)
+	}
+
+	state, err := readState(b.statePath)
+	if err != nil || state != 3 {
+		t.Fatalf("state = %d, %v; want 3", state, err)
+	}
+}
+
+// TestBackfillSnapshotPrecedesRead: снимок старше момента чтения страницы, поэтому событие
+// изменения, опубликованное после чтения, новее снимка даже в пределах той же секунды.
+func TestBackfillSnapshotPrecedesRead(t *testing.T) {
+	goods := []good.Good{{ID: 1, ProjectId: 1}, {ID: 2, ProjectId: 1}, {ID: 3, ProjectId: 1}}
+	events := &fakeEventRepo{rows: make(map[int][]event.ClickhouseEvent)}
+
+	b, goodRepo := newBackfiller(t, goods, events)
+	if _, err := b.run(context.Background(), 0); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	for _, g := range goods {
+		id := g.ID
+		snapshot := events.rows[id][0]
+		page := (id - 1) / b.batchSize
+		// EventTime в ClickHouse - секунды, так же, как и время события изменения
+		updateTime := goodRepo.readAt[page].Truncate(time.Second)
+		if !snapshot.EventTime.Before(updateTime) {
+			t.Fatalf("good %d: snapshot at %s is not older than read at %s", id, snapshot.EventTime, goodRepo.readAt[page])
+		}
+	}
+}
@@ -0,0 +1,185 @@
+// eventsaver - отдельный процесс, который только пишет события из шины в ClickHouse.
+// Позволяет масштабировать API и запись событий независимо; в cmd/api при этом
+// ставится event_saver.embedded: false.
+//
+// GET /health - 200, если доступны шина и ClickHouse; GET /metrics - счетчики в формате Prometheus.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/redis/go-redis/v9"
+	"github.com/voikin/hezzl-test/config"
+	"github.com/voikin/hezzl-test/internal/repository"
+	clickhouseRepo "github.com/voikin/hezzl-test/internal/repository/clickhouse"
+	natsRepo "github.com/voikin/hezzl-test/internal/repository/nats"
+	redisRepo "github.com/voikin/hezzl-test/internal/repository/redis"
+	"github.com/voikin/hezzl-test/internal/service/eventSaver"
+)
+
+const (
+	configPath     = "./config/config.yaml"
+	_healthTimeout = 2 * time.Second
+)
+
+// check проверяет одну зависимость для /health
+type check func(ctx context.Context) error
+
+func main() {
+	cfg, err := config.New(configPath)
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+
+	conn, err := clickhouseRepo.Open(cfg.Clickhouse)
+	if err != nil {
+		log.Fatalf("failed to create ClickHouse connection: %v", err)
+	}
+	defer conn.Close()
+
+	checks := map[string]check{
+		"clickhouse": func(ctx context.Context) error { return conn.Ping(ctx) },
+	}
+
+	var (
+		subscriber  repository.EventSubscriber
+		deadLetters repository.DeadLetterRepo
+	)
+
+	switch cfg.Bus {
+	case "nats":
+		nc, err := nats.Connect(cfg.Nats.URL)
+		if err != nil {
+			log.Fatalf("failed to connect to NATS: %v", err)
+		}
+		defer nc.Close()
+
+		js, err := nc.JetStream()
+		if err != nil {
+			log.Fatalf("failed to create JetStream context: %v", err)
+		}
+
+		natsSubscriber, err := natsRepo.NewSubscriber(js, cfg.Nats.Stream, cfg.Nats.FeedStream, cfg.Nats.Consumer, cfg.Webhook.Consumer)
+		if errors.Is(err, natsRepo.ErrMigrationPending) {
+			// публикации пока уходят в старый стрим, консьюмеры подключатся сами после cmd/migrate
+			log.Printf("event consumers are waiting for the stream migration: %v", err)
+		} else if err != nil {
+			log.Fatalf("failed to create NATS subscriber: %v", err)
+		}
+		subscriber = natsSubscriber
+
+		deadLetters, err = natsRepo.NewDeadLetterRepo(js, cfg.Nats.DeadLetter, cfg.Nats.Stream.Replicas)
+		if err != nil {
+			log.Fatalf("failed to create dead letter stream: %v", err)
+		}
+
+		checks["nats"] = func(context.Context) error {
+			if !nc.IsConnected() {
+				return fmt.Errorf("status %s", nc.Status())
+			}
+			return nil
+		}
+	case "redis":
+		redisClient := redis.NewClient(&redis.Options{
+			Addr:     cfg.Redis.Addr,
+			Password: cfg.Redis.Password,
+			DB:       cfg.Redis.DB,
+		})
+		defer redisClient.Close()
+
+		streamBus, err := redisRepo.NewStreamBus(redisClient, cfg.Redis.Stream)
+		if err != nil {
+			log.Fatalf("failed to create Redis stream bus: %v", err)
+		}
+		defer streamBus.Close()
+		subscriber = streamBus
+		deadLetters = redisRepo.NewDeadLetterRepo(redisClient, cfg.Redis.Stream.DeadLetterKey)
+
+		checks["redis"] = func(ctx context.Context) error { return redisClient.Ping(ctx).Err() }
+	default:
+		// шина в памяти живет внутри процесса API, отдельному процессу читать нечего
+		log.Fatalf("event bus %q is not supported by a standalone event saver", cfg.Bus)
+	}
+
+	saver := eventSaver.NewEventSaver(clickhouseRepo.NewEventRepo(conn, cfg.Clickhouse), deadLetters, subscriber, cfg.Nats.Consumer, cfg.EventSaver)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	saver.Start(ctx)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", healthHandler(checks))
+	mux.HandleFunc("/metrics", metricsHandler(saver))
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%s", cfg.EventSaver.StatusPort),
+		Handler: mux,
+	}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("failed to start status server: %v", err)
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	log.Println("Shutting down event saver...")
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancelShutdown()
+
+	cancel()
+	if err := saver.Wait(shutdownCtx); err != nil {
+		log.Printf("event saver: %v", err)
+	}
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("status server shutdown: %v", err)
+	}
+
+	log.Println("Event saver stopped gracefully")
+}
+
+func healthHandler(checks map[string]check) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), _healthTimeout)
+		defer cancel()
+
+		status := http.StatusOK
+		body := ""
+		for name, c := range checks {
+			if err := c(ctx); err != nil {
+				status = http.StatusServiceUnavailable
+				body += fmt.Sprintf("%s: %v\n", name, err)
+			} else {
+				body += fmt.Sprintf("%s: ok\n", name)
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(status)
+		_, _ = fmt.Fprint(w, body)
+	}
+}
+
+func metricsHandler(saver *eventSaver.EventSaver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		for _, m := range saver.Metrics() {
+			_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", m.Name, m.Help, m.Name, m.Type, m.Name, m.Value)
+		}
+	}
+}
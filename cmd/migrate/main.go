@@ -0,0 +1,104 @@
+// migrate обновляет хранилища событий:
+//   - при bus: nats переносит события из стрима nats.stream.legacy в nats.stream.name
+//     (JetStream не меняет retention существующего стрима);
+//   - выполняет скрипты migrations/clickhouse, которых еще нет в logs.schema_migrations.
+//     На свежем контейнере их уже выполнил сам ClickHouse, на существующей базе так
+//     доезжают новые версии схемы;
+//   - применяет к ClickHouse сроки хранения из clickhouse.retention, они зависят от окружения;
+//   - один раз дописывает в logs.goods_hourly агрегаты событий, накопленных до ее появления.
+//     Дозапись ждет границы из logs.goods_hourly_cutoff, до этого migrate надо запускать повторно.
+//
+// Новый скрипт migrations/clickhouse должен выдерживать повторное выполнение: на свежем
+// контейнере migrate выполнит его еще раз, если не узнает по проверке в clickhouse.Migrate.
+//
+//	go run ./cmd/migrate
+package main
+
+import (
+	"context"
+	"io/fs"
+	"log"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/voikin/hezzl-test/config"
+	clickhouseRepo "github.com/voikin/hezzl-test/internal/repository/clickhouse"
+	natsRepo "github.com/voikin/hezzl-test/internal/repository/nats"
+	"github.com/voikin/hezzl-test/migrations"
+)
+
+const configPath = "./config/config.yaml"
+
+func main() {
+	cfg, err := config.New(configPath)
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	if cfg.Bus == "nats" {
+		migrateStream(ctx, cfg)
+	}
+
+	conn, err := clickhouseRepo.Open(cfg.Clickhouse)
+	if err != nil {
+		log.Fatalf("failed to create ClickHouse connection: %v", err)
+	}
+	defer conn.Close()
+
+	applied, err := clickhouseRepo.Migrate(ctx, conn, sub(migrations.Clickhouse, "clickhouse"))
+	if err != nil {
+		log.Fatalf("failed to migrate ClickHouse schema: %v (applied %v, rerun to continue)", err, applied)
+	}
+
+	log.Printf("ClickHouse schema is up to date, applied %v", applied)
+
+	if err := clickhouseRepo.ApplyRetention(ctx, conn, cfg.Clickhouse.Retention); err != nil {
+		log.Fatalf("failed to apply retention: %v", err)
+	}
+
+	log.Printf("retention applied: events %s, rollups %s", cfg.Clickhouse.Retention.Events, cfg.Clickhouse.Retention.Rollups)
+
+	retryAt, err := clickhouseRepo.BackfillRollups(ctx, conn)
+	if err != nil {
+		log.Fatalf("failed to backfill rollups: %v", err)
+	}
+	if !retryAt.IsZero() {
+		log.Printf("rollup backfill postponed, run migrate again after %s; analytics read raw events until then", retryAt.Format(time.RFC3339))
+		return
+	}
+
+	log.Printf("rollups are backfilled")
+}
+
+func migrateStream(ctx context.Context, cfg *config.Config) {
+	nc, err := nats.Connect(cfg.Nats.URL)
+	if err != nil {
+		log.Fatalf("failed to connect to NATS: %v", err)
+	}
+	defer nc.Close()
+
+	js, err := nc.JetStream()
+	if err != nil {
+		log.Fatalf("failed to create JetStream context: %v", err)
+	}
+
+	copied, err := natsRepo.MigrateStream(ctx, js, cfg.Nats.Stream, cfg.Nats.FeedStream, cfg.Nats.Consumer, cfg.Webhook.Consumer)
+	if err != nil {
+		log.Fatalf("failed to migrate stream %s: %v (copied %d messages, rerun to continue)", cfg.Nats.Stream.Legacy, err, copied)
+	}
+
+	log.Printf("stream %s is up to date, %d messages copied from %s", cfg.Nats.Stream.Name, copied, cfg.Nats.Stream.Legacy)
+}
+
+func sub(fsys fs.FS, dir string) fs.FS {
+	sub, err := fs.Sub(fsys, dir)
+	if err != nil {
+		log.Fatalf("failed to open %s: %v", dir, err)
+	}
+	return sub
+}
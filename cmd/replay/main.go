@@ -0,0 +1,284 @@
+// replay заново записывает события в ClickHouse из стрима ленты (nats.feed_stream) или из NDJSON-файла.
+// Стрим конвейера хранит сообщение только до подтверждения консьюмерами, а лента - feed_stream.max_age,
+// поэтому -from-seq и -to-seq - номера в стриме ленты, те же, что id событий в SSE.
+//
+//	go run ./cmd/replay -from-seq 100 -to-seq 500
+//	go run ./cmd/replay -since 2024-03-01T00:00:00Z -until 2024-03-02T00:00:00Z -dry-run
+//	go run ./cmd/replay -file events.ndjson -rate 500
+//
+// Строки с уже записанным EventId схлопывает ReplacingMergeTree, поэтому повторный запуск ничего не задвоит.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/voikin/hezzl-test/config"
+	"github.com/voikin/hezzl-test/internal/domain/event"
+	clickhouseRepo "github.com/voikin/hezzl-test/internal/repository/clickhouse"
+)
+
+const (
+	configPath   = "./config/config.yaml"
+	_idleTimeout = 5 * time.Second
+)
+
+type options struct {
+	fromSeq uint64
+	toSeq   uint64
+	since   time.Time
+	until   time.Time
+	file    string
+	dryRun  bool
+	rate    int
+	batch   int
+}
+
+func main() {
+	var (
+		opts         options
+		since, until string
+	)
+	flag.Uint64Var(&opts.fromSeq, "from-seq", 0, "first stream sequence to replay")
+	flag.Uint64Var(&opts.toSeq, "to-seq", 0, "last stream sequence to replay (0 - up to the end of the stream)")
+	flag.StringVar(&since, "since", "", "replay messages stored after this time, RFC3339")
+	flag.StringVar(&until, "until", "", "replay messages stored before this time, RFC3339")
+	flag.StringVar(&opts.file, "file", "", "read events from an NDJSON file instead of the stream")
+	flag.BoolVar(&opts.dryRun, "dry-run", false, "only report what would be inserted")
+	flag.IntVar(&opts.rate, "rate", 1000, "max events per second (0 - unlimited)")
+	flag.IntVar(&opts.batch, "batch", 500, "insert batch size")
+	flag.Parse()
+
+	var err error
+	if since != "" {
+		if opts.since, err = time.Parse(time.RFC3339, since); err != nil {
+			log.Fatalf("invalid -since: %v", err)
+		}
+	}
+	if until != "" {
+		if opts.until, err = time.Parse(time.RFC3339, until); err != nil {
+			log.Fatalf("invalid -until: %v", err)
+		}
+	}
+	if opts.file == "" && opts.fromSeq == 0 && opts.since.IsZero() {
+		log.Fatalf("one of -file, -from-seq or -since is required")
+	}
+
+	cfg, err := config.New(configPath)
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+
+	conn, err := clickhouseRepo.Open(cfg.Clickhouse)
+	if err != nil {
+		log.Fatalf("failed to create ClickHouse connection: %v", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	r := &replayer{
+		repo:  clickhouseRepo.NewEventRepo(conn, cfg.Clickhouse),
+		opts:  opts,
+		seen:  make(map[string]struct{}),
+		batch: make([]event.ClickhouseEvent, 0, opts.batch),
+	}
+
+	if opts.file != "" {
+		err = r.fromFile(ctx, opts.file)
+	} else {
+		err = r.fromStream(ctx, cfg.Nats)
+	}
+	if err == nil {
+		err = r.flush(ctx)
+	}
+
+	log.Printf("read %d, inserted %d, skipped as duplicates %d, invalid %d", r.read, r.inserted, r.duplicates, r.invalid)
+	if err != nil {
+		log.Fatalf("replay failed: %v", err)
+	}
+}
+
+type eventRepo interface {
+	CreateEvent(ctx context.Context, event []event.ClickhouseEvent) error
+}
+
+type replayer struct {
+	repo  eventRepo
+	opts  options
+	seen  map[string]struct{}
+	batch []event.ClickhouseEvent
+
+	lastFlush  time.Time
+	read       int
+	inserted   int
+	duplicates int
+	invalid    int
+}
+
+func (r *replayer) fromStream(ctx context.Context, cfg config.Nats) error {
+	nc, err := nats.Connect(cfg.URL)
+	if err != nil {
+		return fmt.Errorf("connect to NATS: %w", err)
+	}
+	defer nc.Close()
+
+	js, err := nc.JetStream()
+	if err != nil {
+		return fmt.Errorf("create JetStream context: %w", err)
+	}
+
+	info, err := js.StreamInfo(cfg.FeedStream.Name)
+	if err != nil {
+		return fmt.Errorf("stream info: %w", err)
+	}
+
+	lastSeq := info.State.LastSeq
+	if r.opts.toSeq != 0 && r.opts.toSeq < lastSeq {
+		lastSeq = r.opts.toSeq
+	}
+
+	subOpts := []nats.SubOpt{nats.OrderedConsumer(), nats.BindStream(cfg.FeedStream.Name)}
+	if r.opts.fromSeq != 0 {
+		subOpts = append(subOpts, nats.StartSequence(r.opts.fromSeq))
+	} else {
+		subOpts = append(subOpts, nats.StartTime(r.opts.since))
+	}
+
+	sub, err := js.SubscribeSync(event.SubjectGoodsAll, subOpts...)
+	if err != nil {
+		return fmt.Errorf("subscribe: %w", err)
+	}
+	defer sub.Unsubscribe()
+
+	for {
+		nextCtx, cancelNext := context.WithTimeout(ctx, _idleTimeout)
+		msg, err := sub.NextMsgWithContext(nextCtx)
+		cancelNext()
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+			return nil // в стриме больше нет сообщений
+		}
+		if err != nil {
+			return err
+		}
+
+		meta, err := msg.Metadata()
+		if err != nil {
+			return err
+		}
+		if meta.Sequence.Stream > lastSeq || (!r.opts.until.IsZero() && meta.Timestamp.After(r.opts.until)) {
+			return nil
+		}
+
+		ce, err := event.Unmarshal(msg.Data, msg.Header.Get(event.ContentTypeHeader))
+		if err != nil {
+			log.Printf("seq %d: %v", meta.Sequence.Stream, err)
+			r.invalid++
+			continue
+		}
+
+		if err := r.add(ctx, ce); err != nil {
+			return err
+		}
+
+		if meta.Sequence.Stream == lastSeq {
+			return nil
+		}
+	}
+}
+
+func (r *replayer) fromFile(ctx context.Context, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if len(data) > 0 && len(bytes.TrimSpace(data)) > 0 {
+			ce := event.ClickhouseEvent{}
+			if err := json.Unmarshal(data, &ce); err != nil {
+				log.Printf("line %d: %v", line, err)
+				r.invalid++
+			} else if err := r.add(ctx, ce); err != nil {
+				return err
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (r *replayer) add(ctx context.Context, ce event.ClickhouseEvent) error {
+	r.read++
+	if ce.EventId == "" {
+		ce.EventId = event.ContentId(ce)
+	}
+
+	if _, ok := r.seen[ce.EventId]; ok {
+		r.duplicates++
+		return nil
+	}
+	r.seen[ce.EventId] = struct{}{}
+
+	r.batch = append(r.batch, ce)
+	if len(r.batch) >= r.opts.batch {
+		return r.flush(ctx)
+	}
+	return nil
+}
+
+func (r *replayer) flush(ctx context.Context) error {
+	if len(r.batch) == 0 {
+		return nil
+	}
+
+	r.throttle(ctx, len(r.batch))
+
+	if r.opts.dryRun {
+		log.Printf("dry-run: would insert %d events", len(r.batch))
+	} else if err := r.repo.CreateEvent(ctx, r.batch); err != nil {
+		return err
+	}
+
+	r.inserted += len(r.batch)
+	r.batch = r.batch[:0]
+	return nil
+}
+
+// throttle ждет столько, чтобы вставка n событий не превышала заданный rate.
+func (r *replayer) throttle(ctx context.Context, n int) {
+	if r.opts.rate <= 0 {
+		return
+	}
+
+	minInterval := time.Duration(n) * time.Second / time.Duration(r.opts.rate)
+	wait := time.Until(r.lastFlush.Add(minInterval))
+	if wait > 0 {
+		select {
+		case <-ctx.Done():
+		case <-time.After(wait):
+		}
+	}
+	r.lastFlush = time.Now()
+}
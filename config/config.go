@@ -2,21 +2,30 @@ package config
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/ilyakaznacheev/cleanenv"
 )
 
 type (
 	Config struct {
+		// Bus - транспорт событий: nats | redis | memory
+		Bus        string `yaml:"bus" env:"BUS" env-default:"nats"`
 		API        `yaml:"api"`
 		Postgres   `yaml:"postgres"`
 		Redis      `yaml:"redis"`
 		Nats       `yaml:"nats"`
 		Clickhouse `yaml:"clickhouse"`
+		EventSaver `yaml:"event_saver"`
+		Webhook    `yaml:"webhook"`
+		Feed       `yaml:"feed"`
+		Cache      `yaml:"cache"`
 	}
 
 	API struct {
 		Port string
+		// ShutdownTimeout - общий дедлайн на остановку: HTTP, отправка событий, запись текущих пачек
+		ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env-default:"30s"`
 	}
 
 	Postgres struct {
@@ -24,13 +33,83 @@ type (
 	}
 
 	Redis struct {
-		Addr     string `yaml:"addr" env:"REDIS_ADDR"`
-		Password string `yaml:"password"`
-		DB       int    `yaml:"db"`
+		Addr     string      `yaml:"addr" env:"REDIS_ADDR"`
+		Password string      `yaml:"password"`
+		DB       int         `yaml:"db"`
+		Stream   RedisStream `yaml:"stream"`
+	}
+
+	// RedisStream - стрим событий для bus: redis
+	// RedisStream - стрим событий в Redis. Записи сверх MaxLen удаляются, только когда их уже
+	// прочитали и подтвердили все consumer group. Публикация повторяется MaxRetries раз,
+	// затем событие уходит в спул; спул разбирается и стрим обрезается раз в MaintainInterval
+	RedisStream struct {
+		Key              string        `yaml:"key" env:"REDIS_STREAM_KEY" env-default:"events"`
+		MaxLen           int64         `yaml:"max_len" env-default:"1000000"`
+		DeadLetterKey    string        `yaml:"dead_letter_key" env-default:"events:deadletter"`
+		MaxRetries       int           `yaml:"max_retries" env-default:"3"`
+		RetryBackoff     time.Duration `yaml:"retry_backoff" env-default:"100ms"`
+		SpoolDir         string        `yaml:"spool_dir" env:"REDIS_SPOOL_DIR" env-default:"./spool"`
+		MaintainInterval time.Duration `yaml:"maintain_interval" env-default:"5s"`
 	}
 
 	Nats struct {
-		URL string `yaml:"url" env:"NATS_URL"`
+		URL        string `yaml:"url" env:"NATS_URL"`
+		Publish    `yaml:"publish"`
+		Stream     `yaml:"stream"`
+		Consumer   `yaml:"consumer"`
+		DeadLetter `yaml:"dead_letter"`
+		FeedStream `yaml:"feed_stream"`
+	}
+
+	// FeedStream - стрим событий товаров для ленты изменений (SSE/WebSocket) и cmd/replay. Retention у него
+	// limits: события живут MaxAge независимо от подтверждений, а стрим конвейера копирует их себе.
+	// Subjects не должны пересекаться с Stream.Subjects
+	FeedStream struct {
+		Name     string        `yaml:"name" env-default:"EVENTS_FEED"`
+		Subjects []string      `yaml:"subjects" env-default:"events.goods.>"`
+		MaxAge   time.Duration `yaml:"max_age" env-default:"168h"`
+		MaxBytes int64         `yaml:"max_bytes" env-default:"-1"`
+	}
+
+	// DeadLetter - отдельный стрим для событий, которые не удалось обработать
+	DeadLetter struct {
+		Stream string        `yaml:"stream" env-default:"EVENTS_DLQ"`
+		MaxAge time.Duration `yaml:"max_age" env-default:"720h"`
+	}
+
+	Publish struct {
+		MaxInFlight     int           `yaml:"max_in_flight" env-default:"256"`
+		MaxRetries      int           `yaml:"max_retries" env-default:"5"`
+		RetryBackoff    time.Duration `yaml:"retry_backoff" env-default:"100ms"`
+		MaxRetryBackoff time.Duration `yaml:"max_retry_backoff" env-default:"5s"`
+		// AckTimeout ограничивает ожидание подтверждения одной публикации: после переподключения
+		// nats.go не завершает уже отправленные публикации, и без таймаута они ждали бы вечно
+		AckTimeout time.Duration `yaml:"ack_timeout" env-default:"5s"`
+		SpoolDir   string        `yaml:"spool_dir" env:"NATS_SPOOL_DIR" env-default:"./spool"`
+		// SpoolDrainInterval - как часто разбирать спул, пока соединение не рвется
+		SpoolDrainInterval time.Duration `yaml:"spool_drain_interval" env-default:"5s"`
+		ContentType        string        `yaml:"content_type" env-default:"application/protobuf"` // application/json | application/protobuf
+	}
+
+	// Stream - стрим событий конвейера. Legacy - стрим с тем же набором сабжектов из прошлых версий:
+	// JetStream не меняет retention существующего стрима, поэтому его содержимое переносит cmd/migrate
+	Stream struct {
+		Name      string        `yaml:"name" env-default:"EVENTS_V2"`
+		Legacy    string        `yaml:"legacy" env-default:"EVENTS"`
+		Subjects  []string      `yaml:"subjects" env-default:"events.goods,events.projects"`
+		Retention string        `yaml:"retention" env-default:"interest"` // limits | interest | workqueue
+		MaxAge    time.Duration `yaml:"max_age"`
+		MaxBytes  int64         `yaml:"max_bytes" env-default:"-1"`
+		Replicas  int           `yaml:"replicas" env-default:"1"`
+		Storage   string        `yaml:"storage" env-default:"file"` // file | memory
+	}
+
+	Consumer struct {
+		Durable       string        `yaml:"durable" env-default:"worker"`
+		FilterSubject string        `yaml:"filter_subject"`
+		AckWait       time.Duration `yaml:"ack_wait" env-default:"30s"`
+		MaxDeliver    int           `yaml:"max_deliver" env-default:"5"`
 	}
 
 	Clickhouse struct {
@@ -40,6 +119,81 @@ type (
 		NativePort int    `yaml:"native_port"`
 		HttpPort   int    `yaml:"http_port"`
 		DB         string `yaml:"db"`
+		// Protocol - native (порт NativePort) или http (порт HttpPort, путь HttpPath - для доступа через прокси).
+		// Compression: none, lz4, zstd; по http еще gzip, deflate, br
+		Protocol    string        `yaml:"protocol" env:"CH_PROTOCOL" env-default:"native"`
+		HttpPath    string        `yaml:"http_path" env:"CH_HTTP_PATH"`
+		Compression string        `yaml:"compression" env:"CH_COMPRESSION" env-default:"none"`
+		TLS         ClickhouseTLS `yaml:"tls"`
+		// AsyncInsert включает серверные асинхронные вставки: ClickHouse сам копит мелкие пачки.
+		// С WaitForAsyncInsert вставка возвращается только после записи на диск, иначе ack возможен до нее
+		AsyncInsert        bool      `yaml:"async_insert" env-default:"false"`
+		WaitForAsyncInsert bool      `yaml:"wait_for_async_insert" env-default:"true"`
+		Retention          Retention `yaml:"retention"`
+	}
+
+	ClickhouseTLS struct {
+		Enabled            bool   `yaml:"enabled" env:"CH_TLS" env-default:"false"`
+		CAFile             string `yaml:"ca_file" env:"CH_TLS_CA_FILE"`
+		InsecureSkipVerify bool   `yaml:"insecure_skip_verify" env-default:"false"`
+	}
+
+	// Retention задает срок хранения в ClickHouse; 0 - хранить бессрочно.
+	// Сырые события удаляются по Events, почасовые агрегаты goods_hourly живут по Rollups,
+	// поэтому аналитика по проектам остается доступной и после удаления сырых строк.
+	// Последнее событие каждого товара хранится в goods_latest без срока
+	Retention struct {
+		Events  time.Duration `yaml:"events" env:"CH_RETENTION_EVENTS" env-default:"0"`
+		Rollups time.Duration `yaml:"rollups" env:"CH_RETENTION_ROLLUPS" env-default:"0"`
+	}
+
+	// EventSaver сбрасывает пачку в ClickHouse, когда она набрала BatchSize событий
+	// или первое событие в ней ждет дольше Linger
+	EventSaver struct {
+		BatchSize    int           `yaml:"batch_size" env:"EVENT_SAVER_BATCH_SIZE" env-default:"1000"`
+		Linger       time.Duration `yaml:"linger" env:"EVENT_SAVER_LINGER" env-default:"1s"`
+		FetchTimeout time.Duration `yaml:"fetch_timeout" env-default:"500ms"`
+		// задержка повторной доставки пачки, которую не удалось записать, растет от RetryBackoff до MaxRetryBackoff
+		RetryBackoff    time.Duration `yaml:"retry_backoff" env-default:"1s"`
+		MaxRetryBackoff time.Duration `yaml:"max_retry_backoff" env-default:"1m"`
+		// Workers воркеров читают один durable-консьюмер. С PartitionByProject события
+		// раскладываются по воркерам по ProjectId, и события одного проекта пишутся по порядку
+		Workers            int           `yaml:"workers" env:"EVENT_SAVER_WORKERS" env-default:"1"`
+		PartitionByProject bool          `yaml:"partition_by_project" env-default:"false"`
+		RestartBackoff     time.Duration `yaml:"restart_backoff" env-default:"1s"`
+		// Embedded запускает консьюмер внутри cmd/api. Выключается, когда события пишет отдельный cmd/eventsaver
+		Embedded bool `yaml:"embedded" env:"EVENT_SAVER_EMBEDDED" env-default:"true"`
+		// StatusPort - порт /health и /metrics у cmd/eventsaver
+		StatusPort string `yaml:"status_port" env:"EVENT_SAVER_STATUS_PORT" env-default:"8081"`
+	}
+
+	Webhook struct {
+		Consumer        `yaml:"consumer"`
+		Timeout         time.Duration `yaml:"timeout" env-default:"5s"`
+		MaxAttempts     int           `yaml:"max_attempts" env-default:"5"`
+		RetryBackoff    time.Duration `yaml:"retry_backoff" env-default:"1s"`
+		MaxRetryBackoff time.Duration `yaml:"max_retry_backoff" env-default:"1m"`
+		DisableAfter    int           `yaml:"disable_after" env-default:"10"`
+		// PollInterval - как часто проверять очередь отложенных доставок
+		PollInterval time.Duration `yaml:"poll_interval" env-default:"1s"`
+		// Workers - сколько доставок выполняется одновременно
+		Workers int `yaml:"workers" env-default:"16"`
+		// AllowPrivate разрешает доставку на loopback и адреса внутренних сетей (для локальной разработки)
+		AllowPrivate bool `yaml:"allow_private" env:"WEBHOOK_ALLOW_PRIVATE" env-default:"false"`
+	}
+
+	Cache struct {
+		LocalTTL            time.Duration `yaml:"local_ttl" env-default:"10s"`
+		LocalMaxEntries     int           `yaml:"local_max_entries" env-default:"10000"`
+		InvalidationSubject string        `yaml:"invalidation_subject" env-default:"cache.invalidate"`
+	}
+
+	Feed struct {
+		MaxStreams int           `yaml:"max_streams" env-default:"100"`
+		Heartbeat  time.Duration `yaml:"heartbeat" env-default:"15s"`
+		BufferSize int           `yaml:"buffer_size" env-default:"64"`
+		// AllowedOrigins - страницы, которым можно открывать WebSocket. Пустой список - только тот же хост
+		AllowedOrigins []string `yaml:"allowed_origins" env:"FEED_ALLOWED_ORIGINS"`
 	}
 )
 
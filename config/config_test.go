@@ -0,0 +1,10 @@
+package config
+
+import "testing"
+
+// TestConfigFileParses: config.yaml из репозитория читается без ошибок.
+func TestConfigFileParses(t *testing.T) {
+	if _, err := New("config.yaml"); err != nil {
+		t.Fatalf("New: %v", err)
+	}
+}
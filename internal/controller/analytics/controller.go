@@ -0,0 +1,185 @@
+package analytics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/voikin/hezzl-test/internal/domain/analytics"
+	"github.com/voikin/hezzl-test/internal/service"
+)
+
+type AnalyticsController struct {
+	analyticsService service.AnalyticsService
+}
+
+func NewAnalyticsController(analyticsService service.AnalyticsService) *AnalyticsController {
+	return &AnalyticsController{analyticsService: analyticsService}
+}
+
+func (ac *AnalyticsController) Changes(c *gin.Context) {
+	f, ok := parseFilter(c)
+	if !ok {
+		return
+	}
+
+	granularity := c.DefaultQuery("granularity", analytics.GranularityDay)
+	if granularity != analytics.GranularityDay && granularity != analytics.GranularityHour {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	buckets, err := ac.analyticsService.GetChanges(c.Request.Context(), f, granularity)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "", "detail": err.Error()})
+		return
+	}
+
+	respond(c, buckets, []string{"projectId", "bucket", "changes"}, func() [][]string {
+		records := make([][]string, 0, len(buckets))
+		for _, b := range buckets {
+			records = append(records, []string{itoa(b.ProjectId), b.Bucket.Format(time.RFC3339), utoa(b.Changes)})
+		}
+		return records
+	})
+}
+
+func (ac *AnalyticsController) MostEdited(c *gin.Context) {
+	f, ok := parseFilter(c)
+	if !ok {
+		return
+	}
+
+	limit, ok := parseLimit(c)
+	if !ok {
+		return
+	}
+
+	edits, err := ac.analyticsService.GetMostEdited(c.Request.Context(), f, limit)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "", "detail": err.Error()})
+		return
+	}
+
+	respond(c, edits, []string{"projectId", "id", "name", "edits"}, func() [][]string {
+		records := make([][]string, 0, len(edits))
+		for _, e := range edits {
+			records = append(records, []string{itoa(e.ProjectId), itoa(e.Id), e.Name, utoa(e.Edits)})
+		}
+		return records
+	})
+}
+
+func (ac *AnalyticsController) RemovalRate(c *gin.Context) {
+	f, ok := parseFilter(c)
+	if !ok {
+		return
+	}
+
+	rates, err := ac.analyticsService.GetRemovalRate(c.Request.Context(), f)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "", "detail": err.Error()})
+		return
+	}
+
+	respond(c, rates, []string{"projectId", "created", "removed", "rate"}, func() [][]string {
+		records := make([][]string, 0, len(rates))
+		for _, r := range rates {
+			records = append(records, []string{itoa(r.ProjectId), utoa(r.Created), utoa(r.Removed), ftoa(r.Rate)})
+		}
+		return records
+	})
+}
+
+func (ac *AnalyticsController) ReprioritizationChurn(c *gin.Context) {
+	f, ok := parseFilter(c)
+	if !ok {
+		return
+	}
+
+	churn, err := ac.analyticsService.GetReprioritizationChurn(c.Request.Context(), f)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "", "detail": err.Error()})
+		return
+	}
+
+	respond(c, churn, []string{"projectId", "reprioritizations", "goodsTouched", "perGood"}, func() [][]string {
+		records := make([][]string, 0, len(churn))
+		for _, ch := range churn {
+			records = append(records, []string{itoa(ch.ProjectId), utoa(ch.Reprioritizations), utoa(ch.GoodsTouched), ftoa(ch.PerGood)})
+		}
+		return records
+	})
+}
+
+func (ac *AnalyticsController) ActiveProjects(c *gin.Context) {
+	f, ok := parseFilter(c)
+	if !ok {
+		return
+	}
+
+	limit, ok := parseLimit(c)
+	if !ok {
+		return
+	}
+
+	projects, err := ac.analyticsService.GetActiveProjects(c.Request.Context(), f, limit)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "", "detail": err.Error()})
+		return
+	}
+
+	respond(c, projects, []string{"projectId", "events", "goodsTouched", "lastEventAt"}, func() [][]string {
+		records := make([][]string, 0, len(projects))
+		for _, p := range projects {
+			records = append(records, []string{itoa(p.ProjectId), utoa(p.Events), utoa(p.GoodsTouched), p.LastEventAt.Format(time.RFC3339)})
+		}
+		return records
+	})
+}
+
+// parseFilter читает projectId, from и to (RFC3339). Все параметры необязательные.
+func parseFilter(c *gin.Context) (analytics.Filter, bool) {
+	f := analytics.Filter{}
+	var err error
+
+	if v := c.Query("projectId"); v != "" {
+		f.ProjectId, err = strconv.Atoi(v)
+		if err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return f, false
+		}
+	}
+
+	if v := c.Query("from"); v != "" {
+		f.From, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return f, false
+		}
+	}
+
+	if v := c.Query("to"); v != "" {
+		f.To, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return f, false
+		}
+	}
+
+	return f, true
+}
+
+func parseLimit(c *gin.Context) (int, bool) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "0"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return 0, false
+	}
+	return limit, true
+}
+
+func itoa(v int) string     { return strconv.Itoa(v) }
+func utoa(v uint64) string  { return strconv.FormatUint(v, 10) }
+func ftoa(v float64) string { return strconv.FormatFloat(v, 'f', 4, 64) }
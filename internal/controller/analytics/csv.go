@@ -0,0 +1,32 @@
+package analytics
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// respond отдает data как JSON или, если запрошен format=csv либо Accept: text/csv, как CSV.
+// records вызывается только для CSV.
+func respond(c *gin.Context, data interface{}, header []string, records func() [][]string) {
+	if !wantsCSV(c) {
+		c.JSON(http.StatusOK, data)
+		return
+	}
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	_ = w.Write(header)
+	_ = w.WriteAll(records())
+}
+
+func wantsCSV(c *gin.Context) bool {
+	if format := c.Query("format"); format != "" {
+		return format == "csv"
+	}
+	return strings.Contains(c.GetHeader("Accept"), "text/csv")
+}
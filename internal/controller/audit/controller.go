@@ -0,0 +1,80 @@
+package audit
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/voikin/hezzl-test/internal/domain/audit"
+	"github.com/voikin/hezzl-test/internal/service"
+	"github.com/voikin/hezzl-test/internal/utils"
+)
+
+type AuditController struct {
+	auditService service.AuditService
+}
+
+func NewAuditController(auditService service.AuditService) *AuditController {
+	return &AuditController{auditService: auditService}
+}
+
+// GetAudit - GET /audit?projectId=&goodId=&type=&from=&to=&q=&cursor=&limit=
+func (ac *AuditController) GetAudit(c *gin.Context) {
+	f := audit.Filter{
+		Type: c.Query("type"),
+		Text: c.Query("q"),
+	}
+	var err error
+
+	if v := c.Query("projectId"); v != "" {
+		f.ProjectId, err = strconv.Atoi(v)
+		if err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+	}
+
+	if v := c.Query("goodId"); v != "" {
+		f.GoodId, err = strconv.Atoi(v)
+		if err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+	}
+
+	if v := c.Query("from"); v != "" {
+		f.From, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+	}
+
+	if v := c.Query("to"); v != "" {
+		f.To, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "0"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	page, err := ac.auditService.GetAudit(c.Request.Context(), f, c.Query("cursor"), limit)
+
+	if errors.Is(err, utils.ErrInvalidCursor) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error(), "code": 3, "detail": "{}"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "", "detail": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
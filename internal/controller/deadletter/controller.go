@@ -0,0 +1,94 @@
+package deadletter
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/voikin/hezzl-test/internal/service"
+	"github.com/voikin/hezzl-test/internal/utils"
+)
+
+type DeadLetterController struct {
+	deadLetterService service.DeadLetterService
+}
+
+func NewDeadLetterController(deadLetterService service.DeadLetterService) *DeadLetterController {
+	return &DeadLetterController{deadLetterService: deadLetterService}
+}
+
+func (dc *DeadLetterController) GetDeadLetters(c *gin.Context) {
+	afterSeq, err := strconv.ParseUint(c.DefaultQuery("afterSeq", "0"), 10, 64)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "0"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	dls, err := dc.deadLetterService.GetDeadLetters(c.Request.Context(), afterSeq, limit)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "", "detail": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, dls)
+}
+
+func (dc *DeadLetterController) GetDeadLetter(c *gin.Context) {
+	seq, err := strconv.ParseUint(c.Query("seq"), 10, 64)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	dl, err := dc.deadLetterService.GetDeadLetter(c.Request.Context(), seq)
+
+	if errors.Is(err, utils.ErrDeadLetterNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": err.Error(), "code": 3, "detail": "{}"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "", "detail": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, dl)
+}
+
+func (dc *DeadLetterController) Redrive(c *gin.Context) {
+	seq, err := strconv.ParseUint(c.Query("seq"), 10, 64)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	dl, err := dc.deadLetterService.RedriveDeadLetter(c.Request.Context(), seq)
+
+	if errors.Is(err, utils.ErrDeadLetterNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": err.Error(), "code": 3, "detail": "{}"})
+		return
+	} else if errors.Is(err, utils.ErrDeadLetterNotRedrivable) {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": utils.ErrDeadLetterNotRedrivable.Error(), "code": 3, "detail": err.Error()})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "", "detail": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, ResponseRedrive{Seq: dl.Seq, Subject: dl.Subject, Redriven: true})
+}
+
+func (dc *DeadLetterController) Purge(c *gin.Context) {
+	purged, err := dc.deadLetterService.PurgeDeadLetters(c.Request.Context())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "", "detail": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, ResponsePurge{Purged: purged})
+}
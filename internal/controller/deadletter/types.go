@@ -0,0 +1,11 @@
+package deadletter
+
+type ResponseRedrive struct {
+	Seq      uint64 `json:"seq"`
+	Subject  string `json:"subject"`
+	Redriven bool   `json:"redriven"`
+}
+
+type ResponsePurge struct {
+	Purged uint64 `json:"purged"`
+}
@@ -0,0 +1,62 @@
+package diff
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/voikin/hezzl-test/internal/service"
+	"github.com/voikin/hezzl-test/internal/utils"
+)
+
+type DiffController struct {
+	diffService service.DiffService
+}
+
+func NewDiffController(diffService service.DiffService) *DiffController {
+	return &DiffController{diffService: diffService}
+}
+
+// GetGoodDiff - GET /good/diff?projectId=&id=&from=&to=; from и to в RFC3339, to=now или пустой - текущее состояние
+func (dc *DiffController) GetGoodDiff(c *gin.Context) {
+	projectId, err := strconv.Atoi(c.Query("projectId"))
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	goodId, err := strconv.Atoi(c.Query("id"))
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	from, err := time.Parse(time.RFC3339, c.Query("from"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	var to time.Time
+	if v := c.Query("to"); v != "" && v != "now" {
+		to, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+	}
+
+	d, err := dc.diffService.GetGoodDiff(c.Request.Context(), projectId, goodId, from, to)
+
+	if errors.Is(err, utils.ErrGoodNotFound) || errors.Is(err, utils.ErrGoodVersionNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": err.Error(), "code": 3, "detail": "{}"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "", "detail": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, d)
+}
@@ -0,0 +1,189 @@
+package feed
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/voikin/hezzl-test/internal/service"
+	"github.com/voikin/hezzl-test/internal/utils"
+)
+
+const _writeTimeout = 10 * time.Second
+
+type FeedController struct {
+	feed     service.Feed
+	upgrader websocket.Upgrader
+}
+
+func NewFeedController(feed service.Feed) *FeedController {
+	fc := &FeedController{feed: feed}
+
+	// админка живет на другом домене, поэтому ее адрес перечисляется в конфиге.
+	// Без списка gorilla пускает только страницы с того же хоста
+	if origins := feed.AllowedOrigins(); len(origins) != 0 {
+		fc.upgrader.CheckOrigin = func(r *http.Request) bool {
+			return allowedOrigin(origins, r.Header.Get("Origin"))
+		}
+	}
+
+	return fc
+}
+
+// Events отдает изменения товаров проекта через Server-Sent Events.
+// id каждого события - номер сообщения в JetStream, по нему браузер возобновляет поток через Last-Event-ID.
+func (fc *FeedController) Events(c *gin.Context) {
+	projectId, lastSeq, err := parseParams(c, c.GetHeader("Last-Event-ID"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	ctx := c.Request.Context()
+	events, err := fc.feed.Subscribe(ctx, projectId, lastSeq)
+	if err != nil {
+		abortSubscribe(c, err)
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(fc.feed.Heartbeat())
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(c.Writer, ": heartbeat\n\n")
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+
+			data, err := json.Marshal(ev.Event)
+			if err != nil {
+				continue
+			}
+			_, err = fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", ev.Seq, ev.Event.Type, data)
+			if err != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+		c.Writer.Flush()
+	}
+}
+
+// EventsWS - то же самое через WebSocket. Браузер не умеет ставить заголовки на WebSocket,
+// поэтому номер последнего полученного события передается параметром lastEventId.
+func (fc *FeedController) EventsWS(c *gin.Context) {
+	projectId, lastSeq, err := parseParams(c, c.Query("lastEventId"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	ctx := c.Request.Context()
+	events, err := fc.feed.Subscribe(ctx, projectId, lastSeq)
+	if err != nil {
+		abortSubscribe(c, err)
+		return
+	}
+
+	conn, err := fc.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// читаем только служебные фреймы, чтобы заметить закрытие соединения клиентом
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	heartbeat := time.NewTicker(fc.feed.Heartbeat())
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-closed:
+			return
+		case <-heartbeat.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(_writeTimeout))
+		case ev, ok := <-events:
+			if !ok {
+				_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "slow consumer"), time.Now().Add(_writeTimeout))
+				return
+			}
+
+			_ = conn.SetWriteDeadline(time.Now().Add(_writeTimeout))
+			err = conn.WriteJSON(gin.H{"id": ev.Seq, "type": ev.Event.Type, "data": ev.Event})
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func parseParams(c *gin.Context, lastEventId string) (int, uint64, error) {
+	projectId, err := strconv.Atoi(c.Query("id"))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var lastSeq uint64
+	if lastEventId != "" {
+		lastSeq, err = strconv.ParseUint(lastEventId, 10, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return projectId, lastSeq, nil
+}
+
+// allowedOrigin пускает запросы без Origin (не из браузера) и страницы из списка.
+func allowedOrigin(allowed []string, origin string) bool {
+	if origin == "" {
+		return true
+	}
+
+	for _, o := range allowed {
+		if strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func abortSubscribe(c *gin.Context, err error) {
+	if errors.Is(err, utils.ErrTooManyStreams) {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"message": err.Error(), "code": 3, "detail": "{}"})
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "", "detail": err.Error()})
+}
@@ -2,8 +2,14 @@ package controller
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/voikin/hezzl-test/internal/controller/analytics"
+	"github.com/voikin/hezzl-test/internal/controller/audit"
+	"github.com/voikin/hezzl-test/internal/controller/deadletter"
+	"github.com/voikin/hezzl-test/internal/controller/diff"
+	"github.com/voikin/hezzl-test/internal/controller/feed"
 	"github.com/voikin/hezzl-test/internal/controller/good"
 	"github.com/voikin/hezzl-test/internal/controller/project"
+	"github.com/voikin/hezzl-test/internal/controller/webhook"
 	"github.com/voikin/hezzl-test/internal/service"
 )
 
@@ -11,8 +17,11 @@ func RegisterRoutes(route *gin.Engine, service *service.Service) {
 	baseRoute := route.Group("/")
 
 	projectHandlers := project.NewProjectController(service.ProjectService)
+	feedHandlers := feed.NewFeedController(service.Feed)
 	projectRoute := baseRoute.Group("/project")
 	{
+		projectRoute.GET("/events", feedHandlers.Events)
+		projectRoute.GET("/events/ws", feedHandlers.EventsWS)
 		projectRoute.POST("/create", projectHandlers.Create)
 		projectRoute.PATCH("/update", projectHandlers.Update)
 		projectRoute.DELETE("/remove", projectHandlers.Delete)
@@ -21,6 +30,7 @@ func RegisterRoutes(route *gin.Engine, service *service.Service) {
 	baseRoute.GET("/projects/list", projectHandlers.GetProjects)
 
 	goodHandlers := good.NewGoodController(service.GoodService)
+	diffHandlers := diff.NewDiffController(service.DiffService)
 	goodRoute := baseRoute.Group("/good")
 	{
 		goodRoute.POST("/create", goodHandlers.Create)
@@ -28,6 +38,40 @@ func RegisterRoutes(route *gin.Engine, service *service.Service) {
 		goodRoute.PATCH("/reprioritize", goodHandlers.UpdateGoodPriority)
 		goodRoute.DELETE("/remove", goodHandlers.Delete)
 		goodRoute.GET("/", goodHandlers.GetGood)
+		goodRoute.GET("/diff", diffHandlers.GetGoodDiff)
 	}
 	baseRoute.GET("/goods/list", goodHandlers.GetGoods)
+
+	webhookHandlers := webhook.NewWebhookController(service.WebhookService)
+	webhookRoute := baseRoute.Group("/webhook")
+	{
+		webhookRoute.POST("/create", webhookHandlers.Create)
+		webhookRoute.PATCH("/update", webhookHandlers.Update)
+		webhookRoute.DELETE("/remove", webhookHandlers.Delete)
+		webhookRoute.GET("/", webhookHandlers.GetWebhook)
+		webhookRoute.GET("/deliveries", webhookHandlers.GetDeliveries)
+	}
+	baseRoute.GET("/webhooks/list", webhookHandlers.GetWebhooks)
+
+	analyticsHandlers := analytics.NewAnalyticsController(service.AnalyticsService)
+	analyticsRoute := baseRoute.Group("/analytics")
+	{
+		analyticsRoute.GET("/changes", analyticsHandlers.Changes)
+		analyticsRoute.GET("/goods/most-edited", analyticsHandlers.MostEdited)
+		analyticsRoute.GET("/removal-rate", analyticsHandlers.RemovalRate)
+		analyticsRoute.GET("/reprioritization-churn", analyticsHandlers.ReprioritizationChurn)
+		analyticsRoute.GET("/projects/active", analyticsHandlers.ActiveProjects)
+	}
+
+	auditHandlers := audit.NewAuditController(service.AuditService)
+	baseRoute.GET("/audit", auditHandlers.GetAudit)
+
+	deadLetterHandlers := deadletter.NewDeadLetterController(service.DeadLetterService)
+	deadLetterRoute := baseRoute.Group("/deadletter")
+	{
+		deadLetterRoute.POST("/redrive", deadLetterHandlers.Redrive)
+		deadLetterRoute.GET("/", deadLetterHandlers.GetDeadLetter)
+	}
+	baseRoute.GET("/deadletters/list", deadLetterHandlers.GetDeadLetters)
+	baseRoute.DELETE("/deadletters/purge", deadLetterHandlers.Purge)
 }
@@ -0,0 +1,178 @@
+package webhook
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/voikin/hezzl-test/internal/service"
+	"github.com/voikin/hezzl-test/internal/utils"
+)
+
+type WebhookController struct {
+	webhookService service.WebhookService
+}
+
+func NewWebhookController(webhookService service.WebhookService) *WebhookController {
+	return &WebhookController{webhookService: webhookService}
+}
+
+func (wc *WebhookController) Create(c *gin.Context) {
+	projectId, err := strconv.Atoi(c.Query("projectId"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	req := RequestCreate{}
+	err = c.ShouldBindJSON(&req)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	wh, err := wc.webhookService.CreateWebhook(c.Request.Context(), projectId, req.URL, req.EventTypes, req.Secret)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "", "detail": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, wh)
+}
+
+func (wc *WebhookController) Update(c *gin.Context) {
+	projectId, err := strconv.Atoi(c.Query("projectId"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(c.Query("id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	req := RequestUpdate{}
+	err = c.ShouldBindJSON(&req)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	wh, err := wc.webhookService.UpdateWebhook(c.Request.Context(), id, projectId, req.URL, req.EventTypes, req.Enabled)
+
+	if errors.Is(err, utils.ErrWebhookNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": err.Error(), "code": 3, "detail": "{}"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "", "detail": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, wh)
+}
+
+func (wc *WebhookController) Delete(c *gin.Context) {
+	projectId, err := strconv.Atoi(c.Query("projectId"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(c.Query("id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	_, err = wc.webhookService.DeleteWebhook(c.Request.Context(), id, projectId)
+
+	if errors.Is(err, utils.ErrWebhookNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": err.Error(), "code": 3, "detail": "{}"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "", "detail": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, ResponseRemove{Id: id, ProjectId: projectId, Removed: true})
+}
+
+func (wc *WebhookController) GetWebhook(c *gin.Context) {
+	projectId, err := strconv.Atoi(c.Query("projectId"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(c.Query("id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	wh, err := wc.webhookService.GetWebhook(c.Request.Context(), id, projectId)
+
+	if errors.Is(err, utils.ErrWebhookNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": err.Error(), "code": 3, "detail": "{}"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "", "detail": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, wh)
+}
+
+func (wc *WebhookController) GetWebhooks(c *gin.Context) {
+	projectId, err := strconv.Atoi(c.Query("projectId"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	webhooks, err := wc.webhookService.GetWebhooks(c.Request.Context(), projectId)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, webhooks)
+}
+
+func (wc *WebhookController) GetDeliveries(c *gin.Context) {
+	projectId, err := strconv.Atoi(c.Query("projectId"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(c.Query("id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	var limit int
+	if limitStr := c.Query("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit parameter"})
+			return
+		}
+	}
+
+	deliveries, err := wc.webhookService.GetDeliveries(c.Request.Context(), id, projectId, limit)
+
+	if errors.Is(err, utils.ErrWebhookNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": err.Error(), "code": 3, "detail": "{}"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "", "detail": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, deliveries)
+}
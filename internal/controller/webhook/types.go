@@ -0,0 +1,19 @@
+package webhook
+
+type RequestCreate struct {
+	URL        string   `json:"url" binding:"required"`
+	EventTypes []string `json:"eventTypes"`
+	Secret     string   `json:"secret,omitempty"`
+}
+
+type RequestUpdate struct {
+	URL        string   `json:"url" binding:"required"`
+	EventTypes []string `json:"eventTypes"`
+	Enabled    bool     `json:"enabled"`
+}
+
+type ResponseRemove struct {
+	Id        int  `json:"id"`
+	ProjectId int  `json:"projectId"`
+	Removed   bool `json:"removed"`
+}
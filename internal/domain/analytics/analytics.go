@@ -0,0 +1,52 @@
+package analytics
+
+import "time"
+
+const (
+	GranularityHour = "hour"
+	GranularityDay  = "day"
+)
+
+// Filter - окно и, опционально, проект для аналитических запросов
+type Filter struct {
+	ProjectId int // 0 - все проекты
+	From      time.Time
+	To        time.Time
+}
+
+// ChangesBucket - число изменений товаров проекта за час или день
+type ChangesBucket struct {
+	ProjectId int       `json:"projectId"`
+	Bucket    time.Time `json:"bucket"`
+	Changes   uint64    `json:"changes"`
+}
+
+type GoodEdits struct {
+	ProjectId int    `json:"projectId"`
+	Id        int    `json:"id"`
+	Name      string `json:"name"`
+	Edits     uint64 `json:"edits"`
+}
+
+// RemovalRate - доля удаленных товаров среди созданных за окно
+type RemovalRate struct {
+	ProjectId int     `json:"projectId"`
+	Created   uint64  `json:"created"`
+	Removed   uint64  `json:"removed"`
+	Rate      float64 `json:"rate"`
+}
+
+// ReprioritizationChurn - сколько раз и у скольких товаров менялся приоритет
+type ReprioritizationChurn struct {
+	ProjectId         int     `json:"projectId"`
+	Reprioritizations uint64  `json:"reprioritizations"`
+	GoodsTouched      uint64  `json:"goodsTouched"`
+	PerGood           float64 `json:"perGood"`
+}
+
+type ActiveProject struct {
+	ProjectId    int       `json:"projectId"`
+	Events       uint64    `json:"events"`
+	GoodsTouched uint64    `json:"goodsTouched"`
+	LastEventAt  time.Time `json:"lastEventAt"`
+}
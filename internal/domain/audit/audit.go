@@ -0,0 +1,35 @@
+package audit
+
+import (
+	"time"
+
+	"github.com/voikin/hezzl-test/internal/domain/event"
+)
+
+// Filter - условия поиска по журналу. Нулевые значения не фильтруют.
+type Filter struct {
+	ProjectId int
+	GoodId    int
+	Type      string
+	From      time.Time
+	To        time.Time
+	// Text ищется без учета регистра в названии и описании товара
+	Text string
+}
+
+// Cursor - позиция последнего отданного события: страницы идут от новых к старым
+type Cursor struct {
+	EventTime time.Time
+	EventId   string
+}
+
+// Entry - событие и состояние товара перед ним (nil, если это первое известное событие товара)
+type Entry struct {
+	Event    event.ClickhouseEvent  `json:"event"`
+	Previous *event.ClickhouseEvent `json:"previous"`
+}
+
+type Page struct {
+	Entries    []Entry `json:"entries"`
+	NextCursor string  `json:"nextCursor,omitempty"`
+}
@@ -0,0 +1,36 @@
+package deadletter
+
+import "time"
+
+// SubjectPrefix - префикс subject мертвых писем: deadletter.<исходный subject>
+const SubjectPrefix = "deadletter"
+
+const (
+	ReasonUndecodable = "undecodable"
+	ReasonMaxDeliver  = "max_deliver_exceeded"
+)
+
+// заголовки, в которых мертвое письмо хранит сведения об исходном сообщении
+const (
+	SubjectHeader    = "Dead-Letter-Subject"
+	ReasonHeader     = "Dead-Letter-Reason"
+	ErrorHeader      = "Dead-Letter-Error"
+	StreamSeqHeader  = "Dead-Letter-Stream-Seq"
+	ConsumerHeader   = "Dead-Letter-Consumer"
+	DeliveriesHeader = "Dead-Letter-Deliveries"
+	FailedAtHeader   = "Dead-Letter-Failed-At"
+)
+
+// DeadLetter - событие, которое консьюмер так и не смог обработать
+type DeadLetter struct {
+	Seq         uint64    `json:"seq"`
+	Subject     string    `json:"subject"`
+	StreamSeq   uint64    `json:"streamSeq"`
+	Consumer    string    `json:"consumer"`
+	Reason      string    `json:"reason"`
+	Error       string    `json:"error"`
+	Deliveries  uint64    `json:"deliveries"`
+	FailedAt    time.Time `json:"failedAt"`
+	ContentType string    `json:"contentType"`
+	Data        []byte    `json:"data"`
+}
@@ -0,0 +1,38 @@
+package diff
+
+import "time"
+
+const (
+	// SourceEvent - версия взята из события в ClickHouse
+	SourceEvent = "event"
+	// SourceCurrent - версия взята из текущей строки Postgres
+	SourceCurrent = "current"
+)
+
+// Version - состояние товара, которое участвует в сравнении
+type Version struct {
+	Source      string    `json:"source"`
+	EventId     string    `json:"eventId,omitempty"`
+	EventTime   time.Time `json:"eventTime,omitempty"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Priority    int       `json:"priority"`
+	Removed     bool      `json:"removed"`
+}
+
+type Field struct {
+	Field   string      `json:"field"`
+	From    interface{} `json:"from"`
+	To      interface{} `json:"to"`
+	Changed bool        `json:"changed"`
+}
+
+type GoodDiff struct {
+	ProjectId int     `json:"projectId"`
+	Id        int     `json:"id"`
+	From      Version `json:"from"`
+	To        Version `json:"to"`
+	Fields    []Field `json:"fields"`
+	// DescriptionDiff - построчный unified diff описания; пустой, если описание не менялось
+	DescriptionDiff string `json:"descriptionDiff,omitempty"`
+}
@@ -0,0 +1,126 @@
+package event
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/voikin/hezzl-test/internal/domain/event/eventpb"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	ContentTypeHeader   = "Content-Type"
+	ContentTypeJSON     = "application/json"
+	ContentTypeProtobuf = "application/protobuf"
+)
+
+// Marshal кодирует событие в указанном формате.
+func Marshal(ce ClickhouseEvent, contentType string) ([]byte, error) {
+	switch contentType {
+	case ContentTypeJSON:
+		return json.Marshal(ce)
+	case ContentTypeProtobuf:
+		return proto.Marshal(ce.ToProto())
+	default:
+		return nil, fmt.Errorf("event.Marshal: unsupported content type %q", contentType)
+	}
+}
+
+// Unmarshal декодирует событие. Сообщения без Content-Type считаются JSON,
+// так их публиковали до перехода на protobuf.
+func Unmarshal(data []byte, contentType string) (ClickhouseEvent, error) {
+	switch contentType {
+	case "", ContentTypeJSON:
+		ce := ClickhouseEvent{}
+		err := json.Unmarshal(data, &ce)
+		if err != nil {
+			return ClickhouseEvent{}, fmt.Errorf("event.Unmarshal: %w", err)
+		}
+		return ce, nil
+	case ContentTypeProtobuf:
+		pb := &eventpb.GoodEvent{}
+		err := proto.Unmarshal(data, pb)
+		if err != nil {
+			return ClickhouseEvent{}, fmt.Errorf("event.Unmarshal: %w", err)
+		}
+		return FromProto(pb), nil
+	default:
+		return ClickhouseEvent{}, fmt.Errorf("event.Unmarshal: unsupported content type %q", contentType)
+	}
+}
+
+func (ce ClickhouseEvent) ToProto() *eventpb.GoodEvent {
+	return &eventpb.GoodEvent{
+		EventId:     ce.EventId,
+		Type:        ce.Type,
+		Id:          int64(ce.Id),
+		ProjectId:   int64(ce.ProjectId),
+		Name:        ce.Name,
+		Description: ce.Description,
+		Priority:    int64(ce.Priority),
+		Removed:     ce.Removed,
+		EventTime:   timestamppb.New(ce.EventTime),
+	}
+}
+
+func FromProto(pb *eventpb.GoodEvent) ClickhouseEvent {
+	return ClickhouseEvent{
+		EventId:     pb.GetEventId(),
+		Type:        pb.GetType(),
+		Id:          int(pb.GetId()),
+		ProjectId:   int(pb.GetProjectId()),
+		Name:        pb.GetName(),
+		Description: pb.GetDescription(),
+		Priority:    int(pb.GetPriority()),
+		Removed:     pb.GetRemoved(),
+		EventTime:   pb.GetEventTime().AsTime(),
+	}
+}
+
+// MarshalProjectEvent кодирует событие проекта в указанном формате.
+func MarshalProjectEvent(pe ProjectEvent, contentType string) ([]byte, error) {
+	switch contentType {
+	case ContentTypeJSON:
+		return json.Marshal(pe)
+	case ContentTypeProtobuf:
+		return proto.Marshal(&eventpb.ProjectEvent{
+			EventId:   pe.EventId,
+			Type:      pe.Type,
+			Id:        int64(pe.Id),
+			Name:      pe.Name,
+			Removed:   pe.Removed,
+			EventTime: timestamppb.New(pe.EventTime),
+		})
+	default:
+		return nil, fmt.Errorf("event.MarshalProjectEvent: unsupported content type %q", contentType)
+	}
+}
+
+func UnmarshalProjectEvent(data []byte, contentType string) (ProjectEvent, error) {
+	switch contentType {
+	case "", ContentTypeJSON:
+		pe := ProjectEvent{}
+		err := json.Unmarshal(data, &pe)
+		if err != nil {
+			return ProjectEvent{}, fmt.Errorf("event.UnmarshalProjectEvent: %w", err)
+		}
+		return pe, nil
+	case ContentTypeProtobuf:
+		pb := &eventpb.ProjectEvent{}
+		err := proto.Unmarshal(data, pb)
+		if err != nil {
+			return ProjectEvent{}, fmt.Errorf("event.UnmarshalProjectEvent: %w", err)
+		}
+		return ProjectEvent{
+			EventId:   pb.GetEventId(),
+			Type:      pb.GetType(),
+			Id:        int(pb.GetId()),
+			Name:      pb.GetName(),
+			Removed:   pb.GetRemoved(),
+			EventTime: pb.GetEventTime().AsTime(),
+		}, nil
+	default:
+		return ProjectEvent{}, fmt.Errorf("event.UnmarshalProjectEvent: unsupported content type %q", contentType)
+	}
+}
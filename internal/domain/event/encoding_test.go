@@ -0,0 +1,217 @@
+package event
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/voikin/hezzl-test/internal/domain/event/eventpb"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// после добавления новых полей: go test ./internal/domain/event -run TestProtoLock -update
+var update = flag.Bool("update", false, "write new fields to the lock file")
+
+const lockPath = "../../../api/proto/events/v1/events.lock.json"
+
+// lock: сообщение -> имя поля -> номер поля
+type lock map[string]map[string]int32
+
+// TestProtoLock сверяет сгенерированные protobuf-события с lock-файлом: поле нельзя удалить
+// без reserved, перенумеровать или отдать его номер другому полю.
+func TestProtoLock(t *testing.T) {
+	fd := eventpb.File_events_v1_events_proto
+	current := describe(fd)
+
+	data, err := os.ReadFile(lockPath)
+	if err != nil {
+		t.Fatalf("read lock file: %v", err)
+	}
+
+	locked := lock{}
+	if err := json.Unmarshal(data, &locked); err != nil {
+		t.Fatalf("parse lock file: %v", err)
+	}
+
+	for _, p := range compare(locked, current, fd) {
+		t.Error(p)
+	}
+	if t.Failed() {
+		return
+	}
+
+	if *update {
+		data, err = json.MarshalIndent(current, "", "  ")
+		if err != nil {
+			t.Fatalf("marshal lock file: %v", err)
+		}
+		if err := os.WriteFile(lockPath, append(data, '\n'), 0o644); err != nil {
+			t.Fatalf("write lock file: %v", err)
+		}
+		return
+	}
+
+	for msgName, fields := range current {
+		for fieldName := range fields {
+			if _, ok := locked[msgName][fieldName]; !ok {
+				t.Errorf("%s.%s is not in the lock file, run the test with -update", msgName, fieldName)
+			}
+		}
+	}
+}
+
+func TestProtoLockDetectsBreakingChanges(t *testing.T) {
+	fd := eventpb.File_events_v1_events_proto
+	current := describe(fd)
+
+	locked := lock{}
+	for msgName, fields := range current {
+		locked[msgName] = make(map[string]int32, len(fields))
+		for fieldName, number := range fields {
+			locked[msgName][fieldName] = number
+		}
+	}
+	// в lock-файле поле name с другим номером и поле, которого больше нет
+	locked["GoodEvent"]["name"] += 100
+	locked["GoodEvent"]["gone"] = 99
+
+	problems := compare(locked, current, fd)
+	if len(problems) != 2 {
+		t.Fatalf("compare = %q, want a renumbered and a removed field", problems)
+	}
+}
+
+func TestGoodEventRoundTrip(t *testing.T) {
+	ce := ClickhouseEvent{
+		EventId:     "0b7f3c5e-8a43-4c3b-9d0e-6f1c2a7b9e11",
+		Type:        TypeGoodUpdated,
+		Id:          42,
+		ProjectId:   7,
+		Name:        "good",
+		Description: "многострочное\nописание",
+		Priority:    3,
+		Removed:     true,
+		EventTime:   time.Date(2024, 3, 2, 17, 59, 23, 0, time.UTC),
+	}
+
+	for _, contentType := range []string{ContentTypeJSON, ContentTypeProtobuf} {
+		t.Run(contentType, func(t *testing.T) {
+			data, err := Marshal(ce, contentType)
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+
+			got, err := Unmarshal(data, contentType)
+			if err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			if !got.EventTime.Equal(ce.EventTime) {
+				t.Fatalf("EventTime = %v, want %v", got.EventTime, ce.EventTime)
+			}
+			got.EventTime = ce.EventTime
+			if !reflect.DeepEqual(got, ce) {
+				t.Fatalf("round trip = %+v, want %+v", got, ce)
+			}
+		})
+	}
+}
+
+func TestProjectEventRoundTrip(t *testing.T) {
+	pe := ProjectEvent{
+		EventId:   "5d2b8f0a-1c4e-4f7a-8b3d-2e9c6a1f0b44",
+		Type:      TypeProjectRemoved,
+		Id:        7,
+		Name:      "project",
+		Removed:   true,
+		EventTime: time.Date(2024, 3, 2, 17, 59, 23, 0, time.UTC),
+	}
+
+	for _, contentType := range []string{ContentTypeJSON, ContentTypeProtobuf} {
+		t.Run(contentType, func(t *testing.T) {
+			data, err := MarshalProjectEvent(pe, contentType)
+			if err != nil {
+				t.Fatalf("MarshalProjectEvent: %v", err)
+			}
+
+			got, err := UnmarshalProjectEvent(data, contentType)
+			if err != nil {
+				t.Fatalf("UnmarshalProjectEvent: %v", err)
+			}
+			if !got.EventTime.Equal(pe.EventTime) {
+				t.Fatalf("EventTime = %v, want %v", got.EventTime, pe.EventTime)
+			}
+			got.EventTime = pe.EventTime
+			if !reflect.DeepEqual(got, pe) {
+				t.Fatalf("round trip = %+v, want %+v", got, pe)
+			}
+		})
+	}
+}
+
+// TestUnmarshalWithoutContentType - сообщения, опубликованные до перехода на protobuf, приходят без заголовка.
+func TestUnmarshalWithoutContentType(t *testing.T) {
+	data := []byte(`{"EventId":"legacy","Id":1,"ProjectId":2,"Name":"old","EventTime":"2024-03-02T17:59:23Z"}`)
+
+	ce, err := Unmarshal(data, "")
+	if err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if ce.EventId != "legacy" || ce.Id != 1 || ce.ProjectId != 2 || ce.Name != "old" {
+		t.Fatalf("Unmarshal = %+v", ce)
+	}
+}
+
+func describe(fd protoreflect.FileDescriptor) lock {
+	out := lock{}
+	messages := fd.Messages()
+	for i := 0; i < messages.Len(); i++ {
+		msg := messages.Get(i)
+		fields := make(map[string]int32)
+		for j := 0; j < msg.Fields().Len(); j++ {
+			field := msg.Fields().Get(j)
+			fields[string(field.Name())] = int32(field.Number())
+		}
+		out[string(msg.Name())] = fields
+	}
+	return out
+}
+
+func compare(locked, current lock, fd protoreflect.FileDescriptor) []string {
+	var problems []string
+	for msgName, fields := range locked {
+		msg := fd.Messages().ByName(protoreflect.Name(msgName))
+		if msg == nil {
+			problems = append(problems, fmt.Sprintf("%s: message removed", msgName))
+			continue
+		}
+
+		for fieldName, number := range fields {
+			got, ok := current[msgName][fieldName]
+			switch {
+			case !ok && !msg.ReservedRanges().Has(protoreflect.FieldNumber(number)):
+				problems = append(problems, fmt.Sprintf("%s.%s: field %d removed without reserving its number", msgName, fieldName, number))
+			case ok && got != number:
+				problems = append(problems, fmt.Sprintf("%s.%s: renumbered %d -> %d", msgName, fieldName, number, got))
+			}
+		}
+
+		for fieldName, number := range current[msgName] {
+			if _, ok := fields[fieldName]; ok {
+				continue
+			}
+			for lockedName, lockedNumber := range fields {
+				if lockedNumber == number {
+					problems = append(problems, fmt.Sprintf("%s.%s: reuses number %d of %s", msgName, fieldName, number, lockedName))
+				}
+			}
+		}
+	}
+
+	sort.Strings(problems)
+	return problems
+}
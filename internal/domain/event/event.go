@@ -1,10 +1,61 @@
 package event
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
+const (
+	// SubjectGoods - префикс событий товаров; сами события идут в GoodsSubject(projectId).
+	// Прошлые версии публиковали их прямо в SubjectGoods
+	SubjectGoods    = "events.goods"
+	SubjectGoodsAll = SubjectGoods + ".>"
+	SubjectProjects = "events.projects"
+)
+
+// GoodsSubject - subject событий товаров проекта, чтобы подписаться на один проект можно было фильтром шины.
+func GoodsSubject(projectId int) string {
+	return SubjectGoods + "." + strconv.Itoa(projectId)
+}
+
+// IsGoodsSubject проверяет, что сообщение - событие товара, включая старые события без проекта в subject.
+func IsGoodsSubject(subject string) bool {
+	return subject == SubjectGoods || strings.HasPrefix(subject, SubjectGoods+".")
+}
+
+const (
+	TypeGoodCreated       = "good.created"
+	TypeGoodUpdated       = "good.updated"
+	TypeGoodRemoved       = "good.removed"
+	TypeGoodReprioritized = "good.reprioritized"
+
+	// TypeGoodSnapshot - синтетическое событие с текущим состоянием товара, которое пишет cmd/backfill.
+	// В шину не публикуется, поэтому в Types его нет
+	TypeGoodSnapshot = "good.snapshot"
+
+	TypeProjectCreated = "project.created"
+	TypeProjectUpdated = "project.updated"
+	TypeProjectRemoved = "project.removed"
+)
+
+// Types - все типы событий, на которые можно подписаться.
+var Types = []string{
+	TypeGoodCreated,
+	TypeGoodUpdated,
+	TypeGoodRemoved,
+	TypeGoodReprioritized,
+	TypeProjectCreated,
+	TypeProjectUpdated,
+	TypeProjectRemoved,
+}
+
 type ClickhouseEvent struct {
+	EventId     string    `json:"EventId"`
+	Type        string    `json:"Type,omitempty"`
 	Id          int       `json:"Id"`
 	ProjectId   int       `json:"ProjectId"`
 	Name        string    `json:"Name"`
@@ -13,3 +64,33 @@ type ClickhouseEvent struct {
 	Removed     bool      `json:"Removed,omitempty"`
 	EventTime   time.Time `json:"EventTime"`
 }
+
+type ProjectEvent struct {
+	EventId   string    `json:"EventId"`
+	Type      string    `json:"Type"`
+	Id        int       `json:"Id"`
+	Name      string    `json:"Name"`
+	Removed   bool      `json:"Removed,omitempty"`
+	EventTime time.Time `json:"EventTime"`
+}
+
+// StreamEvent - событие вместе с его номером в стриме JetStream
+type StreamEvent struct {
+	Seq   uint64          `json:"seq"`
+	Event ClickhouseEvent `json:"event"`
+}
+
+// ContentId - детерминированный id для старых событий без EventId,
+// чтобы повторные записи тех же данных не задваивали строки.
+// Время берется с точностью до секунды, как в DateTime и в миграции 4_goods_copy.sql,
+// иначе id одной строки в Go и в ClickHouse разойдутся.
+func ContentId(ce ClickhouseEvent) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d|%d|%s|%s|%d|%t|%d",
+		ce.Id, ce.ProjectId, ce.Name, ce.Description, ce.Priority, ce.Removed, ce.EventTime.Unix()*int64(time.Second))))
+	return "sha256:" + hex.EncodeToString(sum[:16])
+}
+
+// SnapshotEventId - детерминированный id снимка товара: повторный backfill его не задвоит.
+func SnapshotEventId(projectId, id int) string {
+	return fmt.Sprintf("snapshot:%d:%d", projectId, id)
+}
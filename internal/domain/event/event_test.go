@@ -0,0 +1,36 @@
+package event
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+// TestContentIdMatchesMigration: id совпадает с тем, что считает 4_goods_copy.sql
+// для строки, прочитанной из DateTime, даже если в Go у времени есть доли секунды.
+func TestContentIdMatchesMigration(t *testing.T) {
+	ce := ClickhouseEvent{
+		Id:          7,
+		ProjectId:   2,
+		Name:        "good",
+		Description: "desc",
+		Priority:    3,
+		Removed:     true,
+		EventTime:   time.Date(2024, 3, 1, 12, 30, 45, 123456789, time.UTC),
+	}
+
+	// concat(... toString(toUInt64(toUnixTimestamp(EventTime)) * 1000000000)) из миграции
+	sum := sha256.Sum256([]byte("7|2|good|desc|3|true|1709296245000000000"))
+	want := "sha256:" + hex.EncodeToString(sum[:16])
+
+	if got := ContentId(ce); got != want {
+		t.Fatalf("ContentId = %s, want %s", got, want)
+	}
+
+	stored := ce
+	stored.EventTime = ce.EventTime.Truncate(time.Second)
+	if ContentId(stored) != ContentId(ce) {
+		t.Fatal("ContentId depends on sub-second part of EventTime")
+	}
+}
@@ -0,0 +1,4 @@
+// Package eventpb содержит сгенерированные типы событий из api/proto/events/v1/events.proto.
+package eventpb
+
+//go:generate protoc -I ../../../../api/proto --go_out=../../../.. --go_opt=module=github.com/voikin/hezzl-test events/v1/events.proto
@@ -0,0 +1,68 @@
+package webhook
+
+import "time"
+
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+	EventIdHeader   = "X-Webhook-Event-Id"
+	EventTypeHeader = "X-Webhook-Event-Type"
+)
+
+type Webhook struct {
+	ID         int       `json:"id" db:"id"`
+	ProjectId  int       `json:"project_id" db:"project_id"`
+	URL        string    `json:"url" db:"url"`
+	EventTypes []string  `json:"event_types" db:"event_types"`
+	Secret     string    `json:"secret,omitempty" db:"secret"`
+	Enabled    bool      `json:"enabled" db:"enabled"`
+	Failures   int       `json:"failures" db:"failures"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
+
+// Matches проверяет, подписан ли вебхук на событие. Пустой список означает все события.
+func (w Webhook) Matches(eventType string) bool {
+	if len(w.EventTypes) == 0 {
+		return true
+	}
+
+	for _, t := range w.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+type Delivery struct {
+	ID         int       `json:"id" db:"id"`
+	WebhookId  int       `json:"webhook_id" db:"webhook_id"`
+	EventId    string    `json:"event_id" db:"event_id"`
+	EventType  string    `json:"event_type" db:"event_type"`
+	Attempt    int       `json:"attempt" db:"attempt"`
+	StatusCode int       `json:"status_code" db:"status_code"`
+	Error      string    `json:"error,omitempty" db:"error"`
+	Success    bool      `json:"success" db:"success"`
+	DurationMs int64     `json:"duration_ms" db:"duration_ms"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
+
+// Job - запланированная доставка события одному вебхуку. Attempt - число уже сделанных попыток.
+type Job struct {
+	ID        int64
+	Webhook   Webhook
+	EventId   string
+	EventType string
+	Body      []byte
+	Attempt   int
+}
+
+// Payload - тело запроса, которое получает подписчик.
+type Payload struct {
+	EventId    string    `json:"eventId"`
+	Type       string    `json:"type"`
+	ProjectId  int       `json:"projectId"`
+	OccurredAt time.Time `json:"occurredAt"`
+	Data       any       `json:"data"`
+}
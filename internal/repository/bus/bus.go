@@ -0,0 +1,54 @@
+// Package bus описывает транспорт событий независимо от реализации (JetStream, Redis Streams, память).
+package bus
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Message - доставленное событие. Ack/Nak/Term/InProgress имеют ту же семантику, что и в JetStream.
+type Message interface {
+	Subject() string
+	Data() []byte
+	Header(key string) string
+	// Seq - номер сообщения в стриме
+	Seq() uint64
+	Timestamp() time.Time
+	// NumDelivered - сколько раз сообщение уже доставлялось, включая текущую доставку
+	NumDelivered() uint64
+
+	Ack() error
+	Nak(delay time.Duration) error
+	Term() error
+	InProgress() error
+}
+
+// Consumer - durable-консьюмер, сообщения которого делят между собой все его читатели.
+type Consumer interface {
+	// Fetch ждет не дольше maxWait и возвращает до batch сообщений.
+	// Если сообщений не было, возвращает пустой список без ошибки.
+	Fetch(ctx context.Context, batch int, maxWait time.Duration) ([]Message, error)
+	Close() error
+}
+
+// MatchSubject проверяет subject по фильтру в синтаксисе NATS: '*' - один токен, '>' - хвост.
+// Пустой фильтр совпадает со всем.
+func MatchSubject(filter, subject string) bool {
+	if filter == "" || filter == subject {
+		return true
+	}
+
+	f := strings.Split(filter, ".")
+	s := strings.Split(subject, ".")
+	for i, token := range f {
+		if token == ">" {
+			return len(s) > i
+		}
+		if i >= len(s) || (token != "*" && token != s[i]) {
+			return false
+		}
+	}
+
+	return len(f) == len(s)
+}
@@ -0,0 +1,126 @@
+package bus
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	_spoolFile      = "events.spool"
+	_spoolDraining  = "events.spool.draining"
+	_spoolMaxRecord = 1 << 20
+)
+
+// SpoolRecord - одно неотправленное событие в файле спула
+type SpoolRecord struct {
+	Subject     string `json:"subject"`
+	MsgId       string `json:"msgId"`
+	ContentType string `json:"contentType,omitempty"`
+	Data        []byte `json:"data"`
+}
+
+// Spool буферизует на диске события, которые не удалось опубликовать в шину.
+// Формат файла - NDJSON, по одной записи на строку.
+type Spool struct {
+	mu  sync.Mutex
+	dir string
+}
+
+func NewSpool(dir string) (*Spool, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("bus.NewSpool MkdirAll: %w", err)
+	}
+
+	return &Spool{dir: dir}, nil
+}
+
+func (s *Spool) Append(rec SpoolRecord) error {
+	const fName = "Spool.Append"
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fName, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(filepath.Join(s.dir, _spoolFile), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fName, err)
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(data, '\n'))
+	if err != nil {
+		return fmt.Errorf("%s: %w", fName, err)
+	}
+
+	return f.Sync()
+}
+
+// Drain забирает все накопленные записи и передает их в send.
+// Записи, которые send не смог отправить, возвращаются обратно в спул.
+func (s *Spool) Drain(send func(rec SpoolRecord) error) error {
+	const fName = "Spool.Drain"
+	draining := filepath.Join(s.dir, _spoolDraining)
+
+	s.mu.Lock()
+	// если прошлый дренаж упал на полпути, сначала дочитываем его файл
+	if _, err := os.Stat(draining); errors.Is(err, os.ErrNotExist) {
+		err = os.Rename(filepath.Join(s.dir, _spoolFile), draining)
+		if errors.Is(err, os.ErrNotExist) {
+			s.mu.Unlock()
+			return nil
+		}
+		if err != nil {
+			s.mu.Unlock()
+			return fmt.Errorf("%s: %w", fName, err)
+		}
+	}
+	s.mu.Unlock()
+
+	f, err := os.Open(draining)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fName, err)
+	}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), _spoolMaxRecord)
+
+	var sendErr error
+	for scanner.Scan() {
+		var rec SpoolRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue
+		}
+
+		if sendErr == nil {
+			sendErr = send(rec)
+			if sendErr == nil {
+				continue
+			}
+		}
+
+		if err := s.Append(rec); err != nil {
+			f.Close()
+			return fmt.Errorf("%s: %w", fName, err)
+		}
+	}
+	f.Close()
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("%s: %w", fName, err)
+	}
+
+	if err := os.Remove(draining); err != nil {
+		return fmt.Errorf("%s: %w", fName, err)
+	}
+
+	return sendErr
+}
@@ -0,0 +1,45 @@
+package bus
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// TestSpoolDrainReturnsUnsent: после первой неудачной отправки Drain возвращает эту и все
+// следующие записи в спул в прежнем порядке.
+func TestSpoolDrainReturnsUnsent(t *testing.T) {
+	spool, err := NewSpool(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewSpool: %v", err)
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		if err := spool.Append(SpoolRecord{Subject: "test.a", MsgId: id, Data: []byte(id)}); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+
+	errDown := errors.New("down")
+	var sent []string
+	err = spool.Drain(func(rec SpoolRecord) error {
+		if rec.MsgId == "b" {
+			return errDown
+		}
+		sent = append(sent, rec.MsgId)
+		return nil
+	})
+	if !errors.Is(err, errDown) {
+		t.Fatalf("Drain error = %v, want %v", err, errDown)
+	}
+
+	err = spool.Drain(func(rec SpoolRecord) error {
+		sent = append(sent, rec.MsgId)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("second Drain: %v", err)
+	}
+	if fmt.Sprint(sent) != "[a b c]" {
+		t.Fatalf("sent %v, want [a b c]", sent)
+	}
+}
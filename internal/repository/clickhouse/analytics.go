@@ -0,0 +1,309 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/voikin/hezzl-test/internal/domain/analytics"
+	"github.com/voikin/hezzl-test/internal/domain/event"
+)
+
+// AnalyticsRepo - агрегаты по журналу событий. Читает с FINAL, чтобы повторы одного события,
+// еще не схлопнутые слиянием, не завышали счетчики. Снимки из backfill изменениями не считаются.
+type AnalyticsRepo struct {
+	db driver.Conn
+	// rollupsBackfilled - в goods_hourly уже дописаны события до границы из goods_hourly_cutoff
+	rollupsBackfilled atomic.Bool
+}
+
+func NewAnalyticsRepo(db driver.Conn) *AnalyticsRepo {
+	return &AnalyticsRepo{db: db}
+}
+
+// bucketFunc - функции ClickHouse для округления времени; в запрос попадает только значение из этой карты.
+var bucketFunc = map[string]string{
+	analytics.GranularityHour: "toStartOfHour",
+	analytics.GranularityDay:  "toStartOfDay",
+}
+
+func (ar *AnalyticsRepo) GetChanges(ctx context.Context, f analytics.Filter, granularity string) ([]analytics.ChangesBucket, error) {
+	fn, ok := bucketFunc[granularity]
+	if !ok {
+		return nil, fmt.Errorf("clickhouse.GetChanges: unknown granularity %q", granularity)
+	}
+
+	cond, args := filterCondition(f, "EventTime")
+	query := `
+		SELECT ProjectId, ` + fn + `(EventTime) AS Bucket, count() AS Changes
+		FROM goods FINAL
+		WHERE ` + cond + `
+		GROUP BY ProjectId, Bucket
+		ORDER BY ProjectId, Bucket`
+	rollups, err := ar.useRollups(ctx, f)
+	if err != nil {
+		return nil, fmt.Errorf("clickhouse.GetChanges: %w", err)
+	}
+	if rollups {
+		cond, args = filterCondition(f, "Hour")
+		query = `
+		SELECT ProjectId, ` + fn + `(Hour) AS Bucket, sum(Events) AS Changes
+		FROM goods_hourly
+		WHERE ` + cond + `
+		GROUP BY ProjectId, Bucket
+		ORDER BY ProjectId, Bucket`
+	}
+
+	rows, err := ar.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("clickhouse.GetChanges Query: %w", err)
+	}
+	defer rows.Close()
+
+	var buckets []analytics.ChangesBucket
+	for rows.Next() {
+		var (
+			b         analytics.ChangesBucket
+			projectId int32
+		)
+		if err := rows.Scan(&projectId, &b.Bucket, &b.Changes); err != nil {
+			return nil, fmt.Errorf("clickhouse.GetChanges Scan: %w", err)
+		}
+		b.ProjectId = int(projectId)
+		buckets = append(buckets, b)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("clickhouse.GetChanges: %w", err)
+	}
+	return buckets, nil
+}
+
+// GetMostEdited всегда читает сырой журнал: в агрегатах нет разбивки по товарам.
+func (ar *AnalyticsRepo) GetMostEdited(ctx context.Context, f analytics.Filter, limit int) ([]analytics.GoodEdits, error) {
+	cond, args := filterCondition(f, "EventTime")
+	args = append(args, event.TypeGoodUpdated, limit)
+
+	rows, err := ar.db.Query(ctx, `
+		SELECT ProjectId, Id, argMax(Name, (EventTimeNs, EventId)), count() AS Edits
+		FROM goods FINAL
+		WHERE `+cond+` AND Type = ?
+		GROUP BY ProjectId, Id
+		ORDER BY Edits DESC, ProjectId, Id
+		LIMIT ?`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("clickhouse.GetMostEdited Query: %w", err)
+	}
+	defer rows.Close()
+
+	var edits []analytics.GoodEdits
+	for rows.Next() {
+		var (
+			e             analytics.GoodEdits
+			projectId, id int32
+		)
+		if err := rows.Scan(&projectId, &id, &e.Name, &e.Edits); err != nil {
+			return nil, fmt.Errorf("clickhouse.GetMostEdited Scan: %w", err)
+		}
+		e.ProjectId, e.Id = int(projectId), int(id)
+		edits = append(edits, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("clickhouse.GetMostEdited: %w", err)
+	}
+	return edits, nil
+}
+
+func (ar *AnalyticsRepo) GetRemovalRate(ctx context.Context, f analytics.Filter) ([]analytics.RemovalRate, error) {
+	cond, args := filterCondition(f, "EventTime")
+	query := `
+		SELECT ProjectId, countIf(Type = ?) AS Created, countIf(Type = ?) AS Removed
+		FROM goods FINAL
+		WHERE ` + cond + `
+		GROUP BY ProjectId
+		ORDER BY ProjectId`
+	rollups, err := ar.useRollups(ctx, f)
+	if err != nil {
+		return nil, fmt.Errorf("clickhouse.GetRemovalRate: %w", err)
+	}
+	if rollups {
+		cond, args = filterCondition(f, "Hour")
+		query = `
+		SELECT ProjectId, sumIf(Events, Type = ?) AS Created, sumIf(Events, Type = ?) AS Removed
+		FROM goods_hourly
+		WHERE ` + cond + `
+		GROUP BY ProjectId
+		ORDER BY ProjectId`
+	}
+	args = append([]interface{}{event.TypeGoodCreated, event.TypeGoodRemoved}, args...)
+
+	rows, err := ar.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("clickhouse.GetRemovalRate Query: %w", err)
+	}
+	defer rows.Close()
+
+	var rates []analytics.RemovalRate
+	for rows.Next() {
+		var (
+			r         analytics.RemovalRate
+			projectId int32
+		)
+		if err := rows.Scan(&projectId, &r.Created, &r.Removed); err != nil {
+			return nil, fmt.Errorf("clickhouse.GetRemovalRate Scan: %w", err)
+		}
+		r.ProjectId = int(projectId)
+		if r.Created != 0 {
+			r.Rate = float64(r.Removed) / float64(r.Created)
+		}
+		rates = append(rates, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("clickhouse.GetRemovalRate: %w", err)
+	}
+	return rates, nil
+}
+
+func (ar *AnalyticsRepo) GetReprioritizationChurn(ctx context.Context, f analytics.Filter) ([]analytics.ReprioritizationChurn, error) {
+	cond, args := filterCondition(f, "EventTime")
+	query := `
+		SELECT ProjectId, count() AS Reprioritizations, uniqExact(Id) AS GoodsTouched
+		FROM goods FINAL
+		WHERE ` + cond + ` AND Type = ?
+		GROUP BY ProjectId
+		ORDER BY Reprioritizations DESC, ProjectId`
+	rollups, err := ar.useRollups(ctx, f)
+	if err != nil {
+		return nil, fmt.Errorf("clickhouse.GetReprioritizationChurn: %w", err)
+	}
+	if rollups {
+		cond, args = filterCondition(f, "Hour")
+		query = `
+		SELECT ProjectId, sum(Events) AS Reprioritizations, uniqExactMerge(Goods) AS GoodsTouched
+		FROM goods_hourly
+		WHERE ` + cond + ` AND Type = ?
+		GROUP BY ProjectId
+		ORDER BY Reprioritizations DESC, ProjectId`
+	}
+	args = append(args, event.TypeGoodReprioritized)
+
+	rows, err := ar.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("clickhouse.GetReprioritizationChurn Query: %w", err)
+	}
+	defer rows.Close()
+
+	var churn []analytics.ReprioritizationChurn
+	for rows.Next() {
+		var (
+			c         analytics.ReprioritizationChurn
+			projectId int32
+		)
+		if err := rows.Scan(&projectId, &c.Reprioritizations, &c.GoodsTouched); err != nil {
+			return nil, fmt.Errorf("clickhouse.GetReprioritizationChurn Scan: %w", err)
+		}
+		c.ProjectId = int(projectId)
+		if c.GoodsTouched != 0 {
+			c.PerGood = float64(c.Reprioritizations) / float64(c.GoodsTouched)
+		}
+		churn = append(churn, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("clickhouse.GetReprioritizationChurn: %w", err)
+	}
+	return churn, nil
+}
+
+func (ar *AnalyticsRepo) GetActiveProjects(ctx context.Context, f analytics.Filter, limit int) ([]analytics.ActiveProject, error) {
+	cond, args := filterCondition(f, "EventTime")
+	query := `
+		SELECT ProjectId, count() AS Events, uniqExact(Id) AS GoodsTouched, max(EventTime) AS LastEventAt
+		FROM goods FINAL
+		WHERE ` + cond + `
+		GROUP BY ProjectId
+		ORDER BY Events DESC, ProjectId
+		LIMIT ?`
+	rollups, err := ar.useRollups(ctx, f)
+	if err != nil {
+		return nil, fmt.Errorf("clickhouse.GetActiveProjects: %w", err)
+	}
+	if rollups {
+		cond, args = filterCondition(f, "Hour")
+		query = `
+		SELECT ProjectId, sum(Events) AS Events, uniqExactMerge(Goods) AS GoodsTouched, max(LastEventAt) AS LastEventAt
+		FROM goods_hourly
+		WHERE ` + cond + `
+		GROUP BY ProjectId
+		ORDER BY Events DESC, ProjectId
+		LIMIT ?`
+	}
+	args = append(args, limit)
+
+	rows, err := ar.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("clickhouse.GetActiveProjects Query: %w", err)
+	}
+	defer rows.Close()
+
+	var projects []analytics.ActiveProject
+	for rows.Next() {
+		var (
+			p         analytics.ActiveProject
+			projectId int32
+		)
+		if err := rows.Scan(&projectId, &p.Events, &p.GoodsTouched, &p.LastEventAt); err != nil {
+			return nil, fmt.Errorf("clickhouse.GetActiveProjects Scan: %w", err)
+		}
+		p.ProjectId = int(projectId)
+		projects = append(projects, p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("clickhouse.GetActiveProjects: %w", err)
+	}
+	return projects, nil
+}
+
+// useRollups - окно можно посчитать по goods_hourly: оно из целых часов и, пока старые события
+// не дописаны в агрегаты (BackfillRollups), начинается не раньше границы goods_hourly_cutoff.
+func (ar *AnalyticsRepo) useRollups(ctx context.Context, f analytics.Filter) (bool, error) {
+	if !hourAligned(f) {
+		return false, nil
+	}
+	if ar.rollupsBackfilled.Load() {
+		return true, nil
+	}
+
+	cutoff, backfilled, err := rollupCutoff(ctx, ar.db)
+	if err != nil {
+		return false, err
+	}
+	if backfilled {
+		ar.rollupsBackfilled.Store(true)
+		return true, nil
+	}
+	return !f.From.Before(cutoff), nil
+}
+
+// hourAligned - окно из целых часов, которое можно посчитать по goods_hourly без потерь на краях.
+func hourAligned(f analytics.Filter) bool {
+	return f.From.Equal(f.From.Truncate(time.Hour)) && f.To.Equal(f.To.Truncate(time.Hour))
+}
+
+// filterCondition строит условие по окну и проекту; timeColumn - EventTime для goods или Hour для goods_hourly.
+func filterCondition(f analytics.Filter, timeColumn string) (string, []interface{}) {
+	cond := timeColumn + " >= ? AND " + timeColumn + " < ? AND Type != ?"
+	args := []interface{}{f.From, f.To, event.TypeGoodSnapshot}
+
+	if f.ProjectId != 0 {
+		cond += " AND ProjectId = ?"
+		args = append(args, int32(f.ProjectId))
+	}
+
+	return cond, args
+}
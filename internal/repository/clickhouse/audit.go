@@ -0,0 +1,134 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/voikin/hezzl-test/internal/domain/audit"
+	"github.com/voikin/hezzl-test/internal/domain/event"
+)
+
+// _nanoTime - параметр с unix-наносекундами в виде DateTime64(9).
+const _nanoTime = "fromUnixTimestamp64Nano(toInt64(?))"
+
+type AuditRepo struct {
+	db driver.Conn
+}
+
+func NewAuditRepo(db driver.Conn) *AuditRepo {
+	return &AuditRepo{db: db}
+}
+
+// GetAuditEvents возвращает до limit событий по фильтру от новых к старым, строго после cursor.
+func (ar *AuditRepo) GetAuditEvents(ctx context.Context, f audit.Filter, cursor *audit.Cursor, limit int) ([]event.ClickhouseEvent, error) {
+	cond := "1 = 1"
+	var args []interface{}
+
+	if f.ProjectId != 0 {
+		cond += " AND ProjectId = ?"
+		args = append(args, int32(f.ProjectId))
+	}
+	if f.GoodId != 0 {
+		cond += " AND Id = ?"
+		args = append(args, int32(f.GoodId))
+	}
+	if f.Type != "" {
+		cond += " AND Type = ?"
+		args = append(args, f.Type)
+	}
+	if !f.From.IsZero() {
+		cond += " AND EventTime >= ?"
+		args = append(args, f.From)
+	}
+	if !f.To.IsZero() {
+		cond += " AND EventTime < ?"
+		args = append(args, f.To)
+	}
+	if f.Text != "" {
+		cond += " AND (positionCaseInsensitiveUTF8(Name, ?) > 0 OR positionCaseInsensitiveUTF8(Description, ?) > 0)"
+		args = append(args, f.Text, f.Text)
+	}
+	if cursor != nil {
+		// условие по EventTime отсекает куски по ключу, точное сравнение - по EventTimeNs.
+		// time.Time драйвер подставляет с точностью до секунды, поэтому наносекунды передаются числом
+		cond += " AND EventTime <= ? AND (EventTimeNs, EventId) < (" + _nanoTime + ", ?)"
+		args = append(args, cursor.EventTime, cursor.EventTime.UnixNano(), cursor.EventId)
+	}
+	args = append(args, limit)
+
+	rows, err := ar.db.Query(ctx, "SELECT "+_eventColumns+" FROM goods FINAL WHERE "+cond+
+		" ORDER BY EventTimeNs DESC, EventId DESC LIMIT ?", args...)
+	if err != nil {
+		return nil, fmt.Errorf("clickhouse.GetAuditEvents Query: %w", err)
+	}
+	defer rows.Close()
+
+	events, err := scanEvents(rows)
+	if err != nil {
+		return nil, fmt.Errorf("clickhouse.GetAuditEvents: %w", err)
+	}
+	return events, nil
+}
+
+// GetPreviousStates находит для каждого события предыдущее событие того же товара.
+// Историю читает только для товаров из events, поэтому стоимость зависит от размера страницы, а не журнала.
+func (ar *AuditRepo) GetPreviousStates(ctx context.Context, events []event.ClickhouseEvent) (map[string]event.ClickhouseEvent, error) {
+	previous := make(map[string]event.ClickhouseEvent)
+	if len(events) == 0 {
+		return previous, nil
+	}
+
+	projectIds := make([]int32, 0, len(events))
+	goodIds := make([]int32, 0, len(events))
+	var maxTime time.Time
+	for _, ev := range events {
+		projectIds = append(projectIds, int32(ev.ProjectId))
+		goodIds = append(goodIds, int32(ev.Id))
+		if ev.EventTime.After(maxTime) {
+			maxTime = ev.EventTime
+		}
+	}
+
+	rows, err := ar.db.Query(ctx, "SELECT "+_eventColumns+" FROM goods FINAL"+
+		" WHERE ProjectId IN (?) AND Id IN (?) AND EventTime <= ? AND EventTimeNs <= "+_nanoTime+
+		" ORDER BY ProjectId, Id, EventTimeNs, EventId", projectIds, goodIds, maxTime, maxTime.UnixNano())
+	if err != nil {
+		return nil, fmt.Errorf("clickhouse.GetPreviousStates Query: %w", err)
+	}
+	defer rows.Close()
+
+	history, err := scanEvents(rows)
+	if err != nil {
+		return nil, fmt.Errorf("clickhouse.GetPreviousStates: %w", err)
+	}
+
+	type goodKey struct{ projectId, id int }
+	byGood := make(map[goodKey][]event.ClickhouseEvent)
+	for _, ev := range history {
+		key := goodKey{ev.ProjectId, ev.Id}
+		byGood[key] = append(byGood[key], ev)
+	}
+
+	for _, ev := range events {
+		h := byGood[goodKey{ev.ProjectId, ev.Id}]
+		// первое событие истории, не раньше текущего; предыдущее стоит перед ним
+		i := sort.Search(len(h), func(i int) bool {
+			return !eventBefore(h[i], ev)
+		})
+		if i > 0 {
+			previous[ev.EventId] = h[i-1]
+		}
+	}
+
+	return previous, nil
+}
+
+func eventBefore(a, b event.ClickhouseEvent) bool {
+	if !a.EventTime.Equal(b.EventTime) {
+		return a.EventTime.Before(b.EventTime)
+	}
+	return a.EventId < b.EventId
+}
@@ -0,0 +1,95 @@
+package clickhouse
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/voikin/hezzl-test/config"
+)
+
+// compressionMethods - методы сжатия по имени из конфига; для native годятся только lz4 и zstd.
+var compressionMethods = map[string]clickhouse.CompressionMethod{
+	"none":    clickhouse.CompressionNone,
+	"lz4":     clickhouse.CompressionLZ4,
+	"zstd":    clickhouse.CompressionZSTD,
+	"gzip":    clickhouse.CompressionGZIP,
+	"deflate": clickhouse.CompressionDeflate,
+	"br":      clickhouse.CompressionBrotli,
+}
+
+// Open открывает соединение с ClickHouse по настройкам из конфига: по native-протоколу
+// на NativePort или по HTTP на HttpPort. Остальной код от протокола не зависит.
+func Open(cfg config.Clickhouse) (driver.Conn, error) {
+	const fName = "clickhouse.Open"
+
+	opts := &clickhouse.Options{
+		Auth: clickhouse.Auth{
+			Database: cfg.DB,
+			Username: cfg.Username,
+			Password: cfg.Password,
+		},
+	}
+
+	switch cfg.Protocol {
+	case "", "native":
+		opts.Protocol = clickhouse.Native
+		opts.Addr = []string{fmt.Sprintf("%s:%d", cfg.Addr, cfg.NativePort)}
+	case "http":
+		opts.Protocol = clickhouse.HTTP
+		opts.Addr = []string{fmt.Sprintf("%s:%d", cfg.Addr, cfg.HttpPort)}
+		opts.HttpUrlPath = cfg.HttpPath
+	default:
+		return nil, fmt.Errorf("%s: unknown protocol %q", fName, cfg.Protocol)
+	}
+
+	if cfg.Compression != "" {
+		method, ok := compressionMethods[cfg.Compression]
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown compression %q", fName, cfg.Compression)
+		}
+		if opts.Protocol == clickhouse.Native && method != clickhouse.CompressionNone &&
+			method != clickhouse.CompressionLZ4 && method != clickhouse.CompressionZSTD {
+			return nil, fmt.Errorf("%s: compression %q is supported only over http", fName, cfg.Compression)
+		}
+		opts.Compression = &clickhouse.Compression{Method: method}
+	}
+
+	if cfg.TLS.Enabled {
+		tlsCfg, err := tlsConfig(cfg.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fName, err)
+		}
+		opts.TLS = tlsCfg
+	}
+
+	conn, err := clickhouse.Open(opts)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fName, err)
+	}
+
+	return conn, nil
+}
+
+func tlsConfig(cfg config.ClickhouseTLS) (*tls.Config, error) {
+	tlsCfg := &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}
+	if cfg.CAFile == "" {
+		return tlsCfg, nil
+	}
+
+	pem, err := os.ReadFile(cfg.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("read CA file: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in CA file %s", cfg.CAFile)
+	}
+	tlsCfg.RootCAs = pool
+
+	return tlsCfg, nil
+}
@@ -0,0 +1,54 @@
+package clickhouse
+
+import (
+	"context"
+	sqldriver "database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/voikin/hezzl-test/internal/utils"
+)
+
+// коды исключений ClickHouse, после которых тот же запрос стоит просто повторить
+var _transientCodes = map[int32]bool{
+	159: true, // TIMEOUT_EXCEEDED
+	202: true, // TOO_MANY_SIMULTANEOUS_QUERIES
+	209: true, // SOCKET_TIMEOUT
+	210: true, // NETWORK_ERROR
+	241: true, // MEMORY_LIMIT_EXCEEDED
+	242: true, // TABLE_IS_READ_ONLY
+	252: true, // TOO_MANY_PARTS
+	319: true, // UNKNOWN_STATUS_OF_INSERT
+	999: true, // KEEPER_EXCEPTION
+}
+
+// wrapUnavailable помечает ошибки связи и временной перегрузки ClickHouse через utils.ErrStorageUnavailable,
+// чтобы вызывающий мог отличить их от отказа принять сами данные.
+func wrapUnavailable(err error) error {
+	if err == nil || !transient(err) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", utils.ErrStorageUnavailable, err)
+}
+
+func transient(err error) bool {
+	var exception *clickhouse.Exception
+	if errors.As(err, &exception) {
+		return _transientCodes[exception.Code]
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, clickhouse.ErrAcquireConnTimeout) ||
+		errors.Is(err, sqldriver.ErrBadConn) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE)
+}
@@ -0,0 +1,41 @@
+package clickhouse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/voikin/hezzl-test/internal/utils"
+)
+
+func TestWrapUnavailable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"connection refused", &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, true},
+		{"broken connection", fmt.Errorf("read: %w", io.EOF), true},
+		{"deadline", context.DeadlineExceeded, true},
+		{"pool exhausted", clickhouse.ErrAcquireConnTimeout, true},
+		{"too many parts", &clickhouse.Exception{Code: 252, Message: "Too many parts"}, true},
+		{"bad data", &clickhouse.Exception{Code: 27, Message: "Cannot parse input"}, false},
+		{"unknown column", &clickhouse.Exception{Code: 16, Message: "No such column"}, false},
+		{"other", errors.New("append: converting string to Int32 is unsupported"), false},
+	}
+
+	for _, tt := range tests {
+		err := wrapUnavailable(fmt.Errorf("clickhouse.CreateEvent Send: %w", tt.err))
+		if got := errors.Is(err, utils.ErrStorageUnavailable); got != tt.want {
+			t.Errorf("%s: unavailable = %t, want %t", tt.name, got, tt.want)
+		}
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: the original error is lost: %v", tt.name, err)
+		}
+	}
+}
@@ -4,31 +4,169 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/voikin/hezzl-test/config"
 	"github.com/voikin/hezzl-test/internal/domain/event"
 )
 
 type EventRepo struct {
-	db driver.Conn
+	db                 driver.Conn
+	asyncInsert        bool
+	waitForAsyncInsert bool
 }
 
-func NewEventRepo(db driver.Conn) *EventRepo {
-	return &EventRepo{db: db}
+func NewEventRepo(db driver.Conn, cfg config.Clickhouse) *EventRepo {
+	return &EventRepo{
+		db:                 db,
+		asyncInsert:        cfg.AsyncInsert,
+		waitForAsyncInsert: cfg.WaitForAsyncInsert,
+	}
 }
 
+// CreateEvent пишет события одной колоночной пачкой через нативный протокол.
 func (er *EventRepo) CreateEvent(ctx context.Context, clickhouseEvents []event.ClickhouseEvent) error {
-	insertQuery := "INSERT INTO goods (Id, ProjectId, Name, Description, Priority, Removed, EventTime) VALUES "
-	var args []interface{}
+	if len(clickhouseEvents) == 0 {
+		return nil
+	}
+
+	if er.asyncInsert {
+		wait := 0
+		if er.waitForAsyncInsert {
+			wait = 1
+		}
+		ctx = clickhouse.Context(ctx, clickhouse.WithSettings(clickhouse.Settings{
+			"async_insert":          1,
+			"wait_for_async_insert": wait,
+		}))
+	}
+
+	batch, err := er.db.PrepareBatch(ctx, "INSERT INTO goods ("+_eventColumns+", EventTime)")
+	if err != nil {
+		return wrapUnavailable(fmt.Errorf("clickhouse.CreateEvent PrepareBatch: %w", err))
+	}
 
 	for _, ce := range clickhouseEvents {
-		insertQuery += "(?, ?, ?, ?, ?, ?, ?),"
-		args = append(args, ce.Id, ce.ProjectId, ce.Name, ce.Description, ce.Priority, ce.Removed, ce.EventTime)
+		err = batch.Append(ce.EventId, ce.Type, int32(ce.Id), int32(ce.ProjectId), ce.Name, ce.Description,
+			int32(ce.Priority), ce.Removed, ce.EventTime, ce.EventTime)
+		if err != nil {
+			_ = batch.Abort()
+			return fmt.Errorf("clickhouse.CreateEvent Append: %w", err)
+		}
 	}
 
-	insertQuery = insertQuery[:len(insertQuery)-1] // чтобы убрать последнюю запятую
-	err := er.db.Exec(ctx, insertQuery, args...)
+	err = batch.Send()
 	if err != nil {
-		return fmt.Errorf("clickhouse.CreateEvent Exec: %w", err)
+		return wrapUnavailable(fmt.Errorf("clickhouse.CreateEvent Send: %w", err))
 	}
 	return nil
 }
+
+// GoodsWithEvents возвращает те из ids товаров, у которых в ClickHouse уже есть хотя бы одна строка.
+// Проверяет goods_latest: после удаления старых строк goods по сроку хранения товар остается в ней.
+func (er *EventRepo) GoodsWithEvents(ctx context.Context, ids []int) (map[int]struct{}, error) {
+	existing := make(map[int]struct{})
+	if len(ids) == 0 {
+		return existing, nil
+	}
+
+	goodIds := make([]int32, len(ids))
+	for i, id := range ids {
+		goodIds[i] = int32(id)
+	}
+
+	rows, err := er.db.Query(ctx, "SELECT DISTINCT Id FROM goods_latest WHERE Id IN (?)", goodIds)
+	if err != nil {
+		return nil, wrapUnavailable(fmt.Errorf("clickhouse.GoodsWithEvents Query: %w", err))
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int32
+		if err := rows.Scan(&id); err != nil {
+			return nil, wrapUnavailable(fmt.Errorf("clickhouse.GoodsWithEvents Scan: %w", err))
+		}
+		existing[int(id)] = struct{}{}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, wrapUnavailable(fmt.Errorf("clickhouse.GoodsWithEvents: %w", err))
+	}
+
+	return existing, nil
+}
+
+// _eventColumns читают время события из EventTimeNs: в EventTime только секунды,
+// и события одной секунды по нему не упорядочить.
+const _eventColumns = "EventId, Type, Id, ProjectId, Name, Description, Priority, Removed, EventTimeNs"
+
+// GetGoodEvents возвращает историю товара по порядку. FINAL убирает еще не схлопнутые
+// слиянием повторы одного события. Последнее событие берется и из goods_latest:
+// если старые строки удалены по сроку хранения, история не становится пустой.
+func (er *EventRepo) GetGoodEvents(ctx context.Context, projectId, id int) ([]event.ClickhouseEvent, error) {
+	rows, err := er.db.Query(ctx, "SELECT "+_eventColumns+" FROM ("+
+		" SELECT "+_eventColumns+" FROM goods FINAL WHERE ProjectId = ? AND Id = ?"+
+		" UNION DISTINCT"+
+		" SELECT "+_eventColumns+" FROM goods_latest FINAL WHERE ProjectId = ? AND Id = ?"+
+		") ORDER BY EventTimeNs, EventId",
+		int32(projectId), int32(id), int32(projectId), int32(id))
+	if err != nil {
+		return nil, fmt.Errorf("clickhouse.GetGoodEvents Query: %w", err)
+	}
+	defer rows.Close()
+
+	events, err := scanEvents(rows)
+	if err != nil {
+		return nil, fmt.Errorf("clickhouse.GetGoodEvents: %w", err)
+	}
+	return events, nil
+}
+
+// GetGoodStates возвращает последнее известное состояние каждого товара проекта из goods_latest,
+// на которую не действует срок хранения. argMax по EventTimeNs не зависит от того, успели ли слиться строки.
+func (er *EventRepo) GetGoodStates(ctx context.Context, projectId int) ([]event.ClickhouseEvent, error) {
+	rows, err := er.db.Query(ctx, `
+		SELECT
+			argMax(EventId, (EventTimeNs, EventId)),
+			argMax(Type, (EventTimeNs, EventId)),
+			Id,
+			ProjectId,
+			argMax(Name, (EventTimeNs, EventId)),
+			argMax(Description, (EventTimeNs, EventId)),
+			argMax(Priority, (EventTimeNs, EventId)),
+			argMax(Removed, (EventTimeNs, EventId)),
+			max(EventTimeNs)
+		FROM goods_latest
+		WHERE ProjectId = ?
+		GROUP BY ProjectId, Id
+		ORDER BY Id`, int32(projectId))
+	if err != nil {
+		return nil, fmt.Errorf("clickhouse.GetGoodStates Query: %w", err)
+	}
+	defer rows.Close()
+
+	events, err := scanEvents(rows)
+	if err != nil {
+		return nil, fmt.Errorf("clickhouse.GetGoodStates: %w", err)
+	}
+	return events, nil
+}
+
+func scanEvents(rows driver.Rows) ([]event.ClickhouseEvent, error) {
+	var events []event.ClickhouseEvent
+
+	for rows.Next() {
+		var (
+			ce                      event.ClickhouseEvent
+			id, projectId, priority int32
+		)
+		err := rows.Scan(&ce.EventId, &ce.Type, &id, &projectId, &ce.Name, &ce.Description, &priority, &ce.Removed, &ce.EventTime)
+		if err != nil {
+			return nil, err
+		}
+		ce.Id, ce.ProjectId, ce.Priority = int(id), int(projectId), int(priority)
+		events = append(events, ce)
+	}
+
+	return events, rows.Err()
+}
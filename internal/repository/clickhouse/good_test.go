@@ -0,0 +1,157 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/voikin/hezzl-test/internal/domain/event"
+)
+
+// Бенчмарки пишут в настоящий ClickHouse: адрес задается CH_BENCH_DSN,
+// например clickhouse://default:@localhost:9000/default. Без него бенчмарки пропускаются.
+const benchEvents = 100_000
+
+// benchConn создает одноразовую базу с таблицей goods той же схемы, что и в миграциях.
+func benchConn(b *testing.B) driver.Conn {
+	b.Helper()
+
+	dsn := os.Getenv("CH_BENCH_DSN")
+	if dsn == "" {
+		b.Skip("CH_BENCH_DSN is not set")
+	}
+
+	opts, err := clickhouse.ParseDSN(dsn)
+	if err != nil {
+		b.Fatalf("ParseDSN: %v", err)
+	}
+
+	ctx := context.Background()
+	admin, err := clickhouse.Open(opts)
+	if err != nil {
+		b.Fatalf("Open: %v", err)
+	}
+
+	db := fmt.Sprintf("bench_%d", time.Now().UnixNano())
+	if err := admin.Exec(ctx, "CREATE DATABASE "+db); err != nil {
+		b.Fatalf("CREATE DATABASE: %v", err)
+	}
+	b.Cleanup(func() {
+		_ = admin.Exec(ctx, "DROP DATABASE IF EXISTS "+db)
+		_ = admin.Close()
+	})
+
+	opts.Auth.Database = db
+	conn, err := clickhouse.Open(opts)
+	if err != nil {
+		b.Fatalf("Open %s: %v", db, err)
+	}
+	b.Cleanup(func() { _ = conn.Close() })
+
+	err = conn.Exec(ctx, `
+		CREATE TABLE goods (
+			EventId String,
+			Type LowCardinality(String),
+			Id Int32,
+			ProjectId Int32,
+			Name String,
+			Description String,
+			Priority Int32,
+			Removed Bool,
+			EventTime DateTime,
+			EventTimeNs DateTime64(9) DEFAULT EventTime
+		) ENGINE = ReplacingMergeTree()
+		PARTITION BY toYYYYMM(EventTime)
+		ORDER BY (ProjectId, Id, EventTime, EventId)`)
+	if err != nil {
+		b.Fatalf("CREATE TABLE: %v", err)
+	}
+
+	return conn
+}
+
+func benchEventBatch(n int) []event.ClickhouseEvent {
+	now := time.Now().UTC().Truncate(time.Second)
+	events := make([]event.ClickhouseEvent, n)
+	for i := range events {
+		events[i] = event.ClickhouseEvent{
+			EventId:     fmt.Sprintf("bench-%d", i),
+			Type:        event.TypeGoodUpdated,
+			Id:          i,
+			ProjectId:   i%100 + 1,
+			Name:        fmt.Sprintf("good %d", i),
+			Description: "benchmark",
+			Priority:    i,
+			EventTime:   now,
+		}
+	}
+	return events
+}
+
+// createEventConcat - прежняя реализация CreateEvent: один INSERT ... VALUES
+// с набором плейсхолдеров на каждое событие.
+func createEventConcat(ctx context.Context, db driver.Conn, clickhouseEvents []event.ClickhouseEvent) error {
+	var (
+		sb   strings.Builder
+		args = make([]interface{}, 0, len(clickhouseEvents)*10)
+	)
+	sb.WriteString("INSERT INTO goods (" + _eventColumns + ", EventTime) VALUES ")
+	for i, ce := range clickhouseEvents {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString("(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+		args = append(args, ce.EventId, ce.Type, int32(ce.Id), int32(ce.ProjectId), ce.Name, ce.Description,
+			int32(ce.Priority), ce.Removed, ce.EventTime, ce.EventTime)
+	}
+	return db.Exec(ctx, sb.String(), args...)
+}
+
+func BenchmarkCreateEventConcat(b *testing.B) {
+	conn := benchConn(b)
+	events := benchEventBatch(benchEvents)
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := createEventConcat(ctx, conn, events); err != nil {
+			b.Fatalf("createEventConcat: %v", err)
+		}
+	}
+	b.ReportMetric(float64(benchEvents*b.N)/b.Elapsed().Seconds(), "events/s")
+}
+
+func BenchmarkCreateEventBatch(b *testing.B) {
+	conn := benchConn(b)
+	events := benchEventBatch(benchEvents)
+	repo := &EventRepo{db: conn}
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := repo.CreateEvent(ctx, events); err != nil {
+			b.Fatalf("CreateEvent: %v", err)
+		}
+	}
+	b.ReportMetric(float64(benchEvents*b.N)/b.Elapsed().Seconds(), "events/s")
+}
+
+func BenchmarkCreateEventAsyncInsert(b *testing.B) {
+	conn := benchConn(b)
+	events := benchEventBatch(benchEvents)
+	repo := &EventRepo{db: conn, asyncInsert: true, waitForAsyncInsert: true}
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := repo.CreateEvent(ctx, events); err != nil {
+			b.Fatalf("CreateEvent: %v", err)
+		}
+	}
+	b.ReportMetric(float64(benchEvents*b.N)/b.Elapsed().Seconds(), "events/s")
+}
@@ -0,0 +1,172 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+// _appliedChecks узнают скрипты, уже выполненные на базе до появления schema_migrations:
+// при первом старте контейнера скрипты выполняет сам ClickHouse и номера версий не записывает.
+// Нужны только скриптам, которые нельзя или дорого выполнять повторно, остальные написаны
+// так, что повтор ничего не меняет. Запрос возвращает число больше нуля, если скрипт уже выполнен.
+var _appliedChecks = map[int]string{
+	// после 4 goods_v2 уже переименована в goods
+	3: "SELECT count() FROM system.tables WHERE database = currentDatabase() AND (name = 'goods_v2' OR (name = 'goods' AND engine = 'ReplacingMergeTree'))",
+	4: "SELECT count() FROM system.tables WHERE database = currentDatabase() AND name = 'goods' AND engine = 'ReplacingMergeTree'",
+	7: "SELECT count() FROM system.tables WHERE database = currentDatabase() AND name = 'goods_latest_mv'",
+}
+
+type migration struct {
+	version    int
+	name       string
+	statements []string
+}
+
+// Migrate выполняет скрипты из fsys, которых еще нет в schema_migrations, по возрастанию версии -
+// числа в начале имени файла. Версия записывается после того, как выполнены все запросы скрипта,
+// поэтому прерванный скрипт при следующем запуске выполняется заново. Возвращает имена выполненных скриптов.
+func Migrate(ctx context.Context, db driver.Conn, fsys fs.FS) ([]string, error) {
+	const fName = "clickhouse.Migrate"
+
+	migrations, err := readMigrations(fsys)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fName, err)
+	}
+
+	err = db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			Version UInt32,
+			AppliedAt DateTime DEFAULT now()
+		) ENGINE = ReplacingMergeTree()
+		ORDER BY Version`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: create schema_migrations: %w", fName, err)
+	}
+
+	applied, err := appliedVersions(ctx, db)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fName, err)
+	}
+
+	var done []string
+	for _, m := range migrations {
+		if _, ok := applied[m.version]; ok {
+			continue
+		}
+
+		skip, err := alreadyApplied(ctx, db, m.version)
+		if err != nil {
+			return done, fmt.Errorf("%s: %s: %w", fName, m.name, err)
+		}
+
+		if !skip {
+			for _, stmt := range m.statements {
+				if err := db.Exec(ctx, stmt); err != nil {
+					return done, fmt.Errorf("%s: %s: %w", fName, m.name, err)
+				}
+			}
+			done = append(done, m.name)
+		}
+
+		if err := db.Exec(ctx, "INSERT INTO schema_migrations (Version) VALUES (?)", uint32(m.version)); err != nil {
+			return done, fmt.Errorf("%s: %s: record version: %w", fName, m.name, err)
+		}
+	}
+
+	return done, nil
+}
+
+func appliedVersions(ctx context.Context, db driver.Conn) (map[int]struct{}, error) {
+	rows, err := db.Query(ctx, "SELECT Version FROM schema_migrations FINAL")
+	if err != nil {
+		return nil, fmt.Errorf("schema_migrations Query: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]struct{})
+	for rows.Next() {
+		var version uint32
+		if err := rows.Scan(&version); err != nil {
+			return nil, fmt.Errorf("schema_migrations Scan: %w", err)
+		}
+		applied[int(version)] = struct{}{}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("schema_migrations: %w", err)
+	}
+	return applied, nil
+}
+
+func alreadyApplied(ctx context.Context, db driver.Conn, version int) (bool, error) {
+	query, ok := _appliedChecks[version]
+	if !ok {
+		return false, nil
+	}
+
+	var n uint64
+	if err := db.QueryRow(ctx, query).Scan(&n); err != nil {
+		return false, fmt.Errorf("check: %w", err)
+	}
+	return n > 0, nil
+}
+
+// readMigrations читает скрипты *.sql из корня fsys и делит их на отдельные запросы:
+// драйвер выполняет только один запрос за вызов.
+func readMigrations(fsys fs.FS) ([]migration, error) {
+	names, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]migration, 0, len(names))
+	seen := make(map[int]string, len(names))
+	for _, name := range names {
+		prefix, _, _ := strings.Cut(path.Base(name), "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("%s: name must start with a version number", name)
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("%s and %s have the same version", other, name)
+		}
+		seen[version] = name
+
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{version: version, name: name, statements: splitStatements(string(data))})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	return migrations, nil
+}
+
+// splitStatements убирает строки комментариев и делит скрипт по ";".
+// Точка с запятой внутри строковых литералов в скриптах не встречается.
+func splitStatements(script string) []string {
+	var b strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "--") {
+			continue
+		}
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+
+	var statements []string
+	for _, stmt := range strings.Split(b.String(), ";") {
+		if stmt = strings.TrimSpace(stmt); stmt != "" {
+			statements = append(statements, stmt)
+		}
+	}
+	return statements
+}
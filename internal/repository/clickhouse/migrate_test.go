@@ -0,0 +1,57 @@
+package clickhouse
+
+import (
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/voikin/hezzl-test/migrations"
+)
+
+// TestReadMigrations: встроенные скрипты читаются по порядку версий, каждый делится
+// на отдельные запросы без комментариев.
+func TestReadMigrations(t *testing.T) {
+	fsys, err := fs.Sub(migrations.Clickhouse, "clickhouse")
+	if err != nil {
+		t.Fatalf("Sub: %v", err)
+	}
+
+	ms, err := readMigrations(fsys)
+	if err != nil {
+		t.Fatalf("readMigrations: %v", err)
+	}
+	if len(ms) == 0 {
+		t.Fatal("no migrations embedded")
+	}
+
+	for i, m := range ms {
+		if i > 0 && m.version <= ms[i-1].version {
+			t.Fatalf("%s comes after %s", m.name, ms[i-1].name)
+		}
+		if len(m.statements) == 0 {
+			t.Fatalf("%s has no statements", m.name)
+		}
+		for _, stmt := range m.statements {
+			if strings.Contains(stmt, ";") || strings.HasPrefix(stmt, "--") {
+				t.Fatalf("%s: statement is not split: %q", m.name, stmt)
+			}
+		}
+	}
+
+	copyScript := ms[3]
+	if copyScript.version != 4 || len(copyScript.statements) != 2 {
+		t.Fatalf("4_goods_copy.sql split into %d statements: %q", len(copyScript.statements), copyScript.statements)
+	}
+}
+
+func TestReadMigrationsRejectsBadNames(t *testing.T) {
+	for name, fsys := range map[string]fstest.MapFS{
+		"no version": {"logs.sql": {Data: []byte("SELECT 1")}},
+		"duplicate":  {"1_a.sql": {Data: []byte("SELECT 1")}, "1_b.sql": {Data: []byte("SELECT 1")}},
+	} {
+		if _, err := readMigrations(fsys); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
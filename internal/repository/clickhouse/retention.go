@@ -0,0 +1,57 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/voikin/hezzl-test/config"
+)
+
+// ApplyRetention выставляет TTL сырым событиям и почасовым агрегатам по конфигу.
+// goods_latest с последним событием каждого товара не ограничивается.
+// Таблица, у которой TTL уже совпадает с нужным, не трогается: изменение TTL
+// запускает мутацию, переписывающую все куски.
+func ApplyRetention(ctx context.Context, db driver.Conn, cfg config.Retention) error {
+	const fName = "clickhouse.ApplyRetention"
+
+	tables := []struct {
+		name, column string
+		ttl          time.Duration
+	}{
+		{"goods", "EventTime", cfg.Events},
+		{"goods_hourly", "Hour", cfg.Rollups},
+	}
+
+	for _, t := range tables {
+		if err := applyTTL(ctx, db, t.name, t.column, t.ttl); err != nil {
+			return fmt.Errorf("%s: %s: %w", fName, t.name, err)
+		}
+	}
+
+	return nil
+}
+
+func applyTTL(ctx context.Context, db driver.Conn, table, column string, ttl time.Duration) error {
+	var engine string
+	if err := db.QueryRow(ctx,
+		"SELECT engine_full FROM system.tables WHERE database = currentDatabase() AND name = ?", table,
+	).Scan(&engine); err != nil {
+		return err
+	}
+
+	if ttl <= 0 {
+		if !strings.Contains(engine, " TTL ") {
+			return nil
+		}
+		return db.Exec(ctx, "ALTER TABLE "+table+" REMOVE TTL")
+	}
+
+	expr := fmt.Sprintf("%s + toIntervalSecond(%d)", column, int64(ttl/time.Second))
+	if strings.Contains(engine, " TTL "+expr) {
+		return nil
+	}
+	return db.Exec(ctx, "ALTER TABLE "+table+" MODIFY TTL "+expr)
+}
@@ -0,0 +1,82 @@
+package clickhouse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+// rollupBackfillDelay - запас после границы goods_hourly_cutoff на события, которые
+// доезжают до ClickHouse с задержкой; события до границы, записанные позже дозаписи, в агрегаты не попадут.
+const rollupBackfillDelay = 15 * time.Minute
+
+var errNoRollupCutoff = errors.New("goods_hourly_cutoff is empty, apply migrations/clickhouse/5_goods_hourly.sql")
+
+// BackfillRollups один раз дописывает в goods_hourly агрегаты событий до границы из goods_hourly_cutoff,
+// события после нее считает материализованное представление. Пока граница не прошла, ничего
+// не делает и возвращает время, после которого запуск надо повторить; после дозаписи возвращает нулевое время.
+func BackfillRollups(ctx context.Context, db driver.Conn) (time.Time, error) {
+	const fName = "clickhouse.BackfillRollups"
+
+	cutoff, backfilled, err := rollupCutoff(ctx, db)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s: %w", fName, err)
+	}
+	if backfilled {
+		return time.Time{}, nil
+	}
+
+	retryAt := cutoff.Add(rollupBackfillDelay)
+	if time.Now().Before(retryAt) {
+		return retryAt, nil
+	}
+
+	err = db.Exec(ctx, `
+		INSERT INTO goods_hourly
+		SELECT
+			ProjectId,
+			toStartOfHour(EventTime) AS Hour,
+			Type,
+			count() AS Events,
+			uniqExactState(Id) AS Goods,
+			max(EventTime) AS LastEventAt
+		FROM goods FINAL
+		WHERE EventTime < ?
+		GROUP BY ProjectId, Hour, Type`, cutoff)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s: insert: %w", fName, err)
+	}
+
+	if err := db.Exec(ctx, "INSERT INTO goods_hourly_cutoff (Cutoff, Backfilled) VALUES (?, 1)", cutoff); err != nil {
+		return time.Time{}, fmt.Errorf("%s: mark backfilled: %w", fName, err)
+	}
+
+	return time.Time{}, nil
+}
+
+func rollupCutoff(ctx context.Context, db driver.Conn) (time.Time, bool, error) {
+	rows, err := db.Query(ctx, "SELECT Cutoff, Backfilled FROM goods_hourly_cutoff FINAL ORDER BY Cutoff LIMIT 1")
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return time.Time{}, false, err
+		}
+		return time.Time{}, false, errNoRollupCutoff
+	}
+
+	var (
+		cutoff     time.Time
+		backfilled uint8
+	)
+	if err := rows.Scan(&cutoff, &backfilled); err != nil {
+		return time.Time{}, false, err
+	}
+	return cutoff, backfilled == 1, rows.Err()
+}
@@ -0,0 +1,101 @@
+package local
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+type entry struct {
+	value   []byte
+	expires time.Time
+}
+
+// Cache - in-process кэш поверх Redis. Записи живут не дольше ttl,
+// а между репликами инвалидируются через шину в NATS.
+type Cache struct {
+	mu         sync.RWMutex
+	entries    map[string]entry
+	ttl        time.Duration
+	maxEntries int
+}
+
+func NewCache(ttl time.Duration, maxEntries int) *Cache {
+	return &Cache{
+		entries:    make(map[string]entry),
+		ttl:        ttl,
+		maxEntries: maxEntries,
+	}
+}
+
+func (c *Cache) Get(key string) ([]byte, bool) {
+	c.mu.RLock()
+	e, ok := c.entries[key]
+	c.mu.RUnlock()
+
+	if !ok || time.Now().After(e.expires) {
+		return nil, false
+	}
+
+	return e.value, true
+}
+
+func (c *Cache) Set(key string, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.maxEntries {
+		c.evict()
+	}
+
+	c.entries[key] = entry{value: value, expires: time.Now().Add(c.ttl)}
+}
+
+func (c *Cache) Delete(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range keys {
+		delete(c.entries, key)
+	}
+}
+
+func (c *Cache) DeletePrefix(prefixes ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key := range c.entries {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(key, prefix) {
+				delete(c.entries, key)
+				break
+			}
+		}
+	}
+}
+
+func (c *Cache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[string]entry)
+}
+
+// evict освобождает место: сначала протухшие записи, если их нет - любую.
+func (c *Cache) evict() {
+	now := time.Now()
+	for key, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, key)
+		}
+	}
+
+	if len(c.entries) < c.maxEntries {
+		return
+	}
+
+	for key := range c.entries {
+		delete(c.entries, key)
+		return
+	}
+}
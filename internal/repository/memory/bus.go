@@ -0,0 +1,319 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/voikin/hezzl-test/config"
+	"github.com/voikin/hezzl-test/internal/domain/event"
+	"github.com/voikin/hezzl-test/internal/repository/bus"
+)
+
+const (
+	_maxMessages    = 100_000
+	_dedupWindow    = 2 * time.Minute
+	_redeliveryPoll = 50 * time.Millisecond
+	msgIdHeader     = "Nats-Msg-Id"
+)
+
+type storedMsg struct {
+	seq     uint64
+	subject string
+	header  map[string]string
+	data    []byte
+	at      time.Time
+}
+
+type pendingMsg struct {
+	deliveries uint64
+	deadline   time.Time
+}
+
+type consumerState struct {
+	cfg     config.Consumer
+	nextSeq uint64
+	pending map[uint64]*pendingMsg
+}
+
+// EventBus - стрим событий в памяти процесса с durable-консьюмерами, ack и повторной доставкой.
+// Нужен для локального запуска без NATS (bus: memory) и для тестов.
+type EventBus struct {
+	mu        sync.Mutex
+	msgs      []storedMsg
+	lastSeq   uint64
+	maxAge    time.Duration
+	dedup     map[string]time.Time
+	consumers map[string]*consumerState
+	notify    chan struct{}
+}
+
+func NewEventBus(cfg config.Stream) *EventBus {
+	return &EventBus{
+		maxAge:    cfg.MaxAge,
+		dedup:     make(map[string]time.Time),
+		consumers: make(map[string]*consumerState),
+		notify:    make(chan struct{}),
+	}
+}
+
+// Publish сохраняет событие. Как и JetStream, отбрасывает повтор с тем же msgId в окне дедупликации.
+func (b *EventBus) Publish(subject, msgId, contentType string, data []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if msgId != "" {
+		if at, ok := b.dedup[msgId]; ok && now.Sub(at) < _dedupWindow {
+			return
+		}
+		b.dedup[msgId] = now
+	}
+
+	b.lastSeq++
+	b.msgs = append(b.msgs, storedMsg{
+		seq:     b.lastSeq,
+		subject: subject,
+		header:  map[string]string{msgIdHeader: msgId, event.ContentTypeHeader: contentType},
+		data:    data,
+		at:      now,
+	})
+	b.trim(now)
+
+	close(b.notify)
+	b.notify = make(chan struct{})
+}
+
+func (b *EventBus) Flush() {}
+
+// Consume возвращает durable-консьюмер. Состояние консьюмера живет в шине,
+// поэтому повторный Consume с тем же именем продолжает с того же места.
+func (b *EventBus) Consume(cfg config.Consumer) (bus.Consumer, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	st, ok := b.consumers[cfg.Durable]
+	if !ok {
+		st = &consumerState{nextSeq: 1, pending: make(map[uint64]*pendingMsg)}
+		b.consumers[cfg.Durable] = st
+	}
+	st.cfg = cfg
+
+	return &consumer{bus: b, state: st}, nil
+}
+
+// Follow доставляет в handler события subject, начиная с startSeq (0 - только новые).
+func (b *EventBus) Follow(subject string, startSeq uint64, handler func(bus.Message)) (func() error, error) {
+	b.mu.Lock()
+	next := startSeq
+	if next == 0 {
+		next = b.lastSeq + 1
+	}
+	b.mu.Unlock()
+
+	stop := make(chan struct{})
+	var once sync.Once
+
+	go func() {
+		for {
+			b.mu.Lock()
+			var batch []storedMsg
+			for ; next <= b.lastSeq; next++ {
+				msg, ok := b.get(next)
+				if ok && bus.MatchSubject(subject, msg.subject) {
+					batch = append(batch, msg)
+				}
+			}
+			notify := b.notify
+			b.mu.Unlock()
+
+			for _, msg := range batch {
+				handler(&message{stored: msg, deliveries: 1})
+			}
+
+			select {
+			case <-stop:
+				return
+			case <-notify:
+			}
+		}
+	}()
+
+	return func() error {
+		once.Do(func() { close(stop) })
+		return nil
+	}, nil
+}
+
+func (b *EventBus) get(seq uint64) (storedMsg, bool) {
+	if len(b.msgs) == 0 || seq < b.msgs[0].seq || seq > b.lastSeq {
+		return storedMsg{}, false
+	}
+	return b.msgs[seq-b.msgs[0].seq], true
+}
+
+func (b *EventBus) trim(now time.Time) {
+	drop := 0
+	for drop < len(b.msgs) && (len(b.msgs)-drop > _maxMessages || (b.maxAge > 0 && now.Sub(b.msgs[drop].at) > b.maxAge)) {
+		drop++
+	}
+	if drop > 0 {
+		b.msgs = append(b.msgs[:0:0], b.msgs[drop:]...)
+	}
+
+	if b.lastSeq%1000 == 0 {
+		for id, at := range b.dedup {
+			if now.Sub(at) >= _dedupWindow {
+				delete(b.dedup, id)
+			}
+		}
+	}
+}
+
+// collect выбирает сообщения для консьюмера: сначала те, что пора доставить повторно, потом новые.
+func (b *EventBus) collect(c *consumer, batch int, now time.Time) []bus.Message {
+	st := c.state
+	var out []bus.Message
+
+	seqs := make([]uint64, 0, len(st.pending))
+	for seq := range st.pending {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+
+	for _, seq := range seqs {
+		if len(out) == batch {
+			return out
+		}
+
+		p := st.pending[seq]
+		if now.Before(p.deadline) {
+			continue
+		}
+
+		msg, ok := b.get(seq)
+		if !ok || (st.cfg.MaxDeliver > 0 && p.deliveries >= uint64(st.cfg.MaxDeliver)) {
+			delete(st.pending, seq)
+			continue
+		}
+
+		p.deliveries++
+		p.deadline = now.Add(st.cfg.AckWait)
+		out = append(out, &message{consumer: c, stored: msg, deliveries: p.deliveries})
+	}
+
+	for ; len(out) < batch && st.nextSeq <= b.lastSeq; st.nextSeq++ {
+		msg, ok := b.get(st.nextSeq)
+		if !ok || !bus.MatchSubject(st.cfg.FilterSubject, msg.subject) {
+			continue
+		}
+
+		st.pending[msg.seq] = &pendingMsg{deliveries: 1, deadline: now.Add(st.cfg.AckWait)}
+		out = append(out, &message{consumer: c, stored: msg, deliveries: 1})
+	}
+
+	return out
+}
+
+type consumer struct {
+	bus   *EventBus
+	state *consumerState
+}
+
+func (c *consumer) Fetch(ctx context.Context, batch int, maxWait time.Duration) ([]bus.Message, error) {
+	deadline := time.Now().Add(maxWait)
+
+	for {
+		c.bus.mu.Lock()
+		msgs := c.bus.collect(c, batch, time.Now())
+		notify := c.bus.notify
+		c.bus.mu.Unlock()
+
+		if len(msgs) != 0 {
+			return msgs, nil
+		}
+
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return nil, nil
+		}
+		// повторные доставки не порождают уведомлений, поэтому периодически перепроверяем pending
+		if wait > _redeliveryPoll {
+			wait = _redeliveryPoll
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-notify:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+func (c *consumer) Close() error {
+	return nil
+}
+
+func (c *consumer) update(seq uint64, fn func(st *consumerState, p *pendingMsg)) {
+	c.bus.mu.Lock()
+	defer c.bus.mu.Unlock()
+
+	if p, ok := c.state.pending[seq]; ok {
+		fn(c.state, p)
+	}
+}
+
+type message struct {
+	consumer   *consumer
+	stored     storedMsg
+	deliveries uint64
+}
+
+func (m *message) Subject() string          { return m.stored.subject }
+func (m *message) Data() []byte             { return m.stored.data }
+func (m *message) Header(key string) string { return m.stored.header[key] }
+func (m *message) Seq() uint64              { return m.stored.seq }
+func (m *message) Timestamp() time.Time     { return m.stored.at }
+func (m *message) NumDelivered() uint64     { return m.deliveries }
+
+func (m *message) Ack() error {
+	return m.Term()
+}
+
+func (m *message) Nak(delay time.Duration) error {
+	if m.consumer != nil {
+		m.consumer.update(m.stored.seq, func(_ *consumerState, p *pendingMsg) {
+			p.deadline = time.Now().Add(delay)
+		})
+	}
+	return nil
+}
+
+func (m *message) Term() error {
+	if m.consumer != nil {
+		m.consumer.update(m.stored.seq, func(st *consumerState, _ *pendingMsg) {
+			delete(st.pending, m.stored.seq)
+		})
+	}
+	return nil
+}
+
+func (m *message) InProgress() error {
+	if m.consumer != nil {
+		m.consumer.update(m.stored.seq, func(st *consumerState, p *pendingMsg) {
+			p.deadline = time.Now().Add(st.cfg.AckWait)
+		})
+	}
+	return nil
+}
+
+// Invalidator - заглушка шины инвалидаций: в памяти работает один инстанс,
+// и свой локальный кэш он чистит сам.
+type Invalidator struct{}
+
+func (Invalidator) Invalidate(keys, prefixes []string) {}
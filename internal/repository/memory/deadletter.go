@@ -0,0 +1,80 @@
+package memory
+
+import (
+	"context"
+	"sync"
+
+	"github.com/voikin/hezzl-test/internal/domain/deadletter"
+	"github.com/voikin/hezzl-test/internal/utils"
+)
+
+// DeadLetterRepo хранит мертвые письма в памяти процесса (bus: memory).
+type DeadLetterRepo struct {
+	mu      sync.Mutex
+	lastSeq uint64
+	letters []deadletter.DeadLetter
+}
+
+func NewDeadLetterRepo() *DeadLetterRepo {
+	return &DeadLetterRepo{}
+}
+
+func (dr *DeadLetterRepo) AddDeadLetter(_ context.Context, dl deadletter.DeadLetter) error {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+
+	dr.lastSeq++
+	dl.Seq = dr.lastSeq
+	dr.letters = append(dr.letters, dl)
+	return nil
+}
+
+func (dr *DeadLetterRepo) GetDeadLetters(_ context.Context, afterSeq uint64, limit int) ([]deadletter.DeadLetter, error) {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+
+	dls := make([]deadletter.DeadLetter, 0, limit)
+	for _, dl := range dr.letters {
+		if len(dls) == limit {
+			break
+		}
+		if dl.Seq > afterSeq {
+			dls = append(dls, dl)
+		}
+	}
+	return dls, nil
+}
+
+func (dr *DeadLetterRepo) GetDeadLetter(_ context.Context, seq uint64) (deadletter.DeadLetter, error) {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+
+	for _, dl := range dr.letters {
+		if dl.Seq == seq {
+			return dl, nil
+		}
+	}
+	return deadletter.DeadLetter{}, utils.ErrDeadLetterNotFound
+}
+
+func (dr *DeadLetterRepo) DeleteDeadLetter(_ context.Context, seq uint64) error {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+
+	for i, dl := range dr.letters {
+		if dl.Seq == seq {
+			dr.letters = append(dr.letters[:i], dr.letters[i+1:]...)
+			return nil
+		}
+	}
+	return utils.ErrDeadLetterNotFound
+}
+
+func (dr *DeadLetterRepo) PurgeDeadLetters(_ context.Context) (uint64, error) {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+
+	n := uint64(len(dr.letters))
+	dr.letters = nil
+	return n, nil
+}
@@ -0,0 +1,144 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/voikin/hezzl-test/config"
+	"github.com/voikin/hezzl-test/internal/domain/deadletter"
+	"github.com/voikin/hezzl-test/internal/domain/event"
+	"github.com/voikin/hezzl-test/internal/utils"
+)
+
+// DeadLetterRepo хранит мертвые письма в отдельном стриме, сведения об исходном сообщении - в заголовках.
+type DeadLetterRepo struct {
+	js     nats.JetStreamContext
+	stream string
+}
+
+func NewDeadLetterRepo(js nats.JetStreamContext, cfg config.DeadLetter, replicas int) (*DeadLetterRepo, error) {
+	err := EnsureStream(js, config.Stream{
+		Name:      cfg.Stream,
+		Subjects:  []string{deadletter.SubjectPrefix + ".>"},
+		Retention: "limits",
+		MaxAge:    cfg.MaxAge,
+		MaxBytes:  -1,
+		Replicas:  replicas,
+		Storage:   "file",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &DeadLetterRepo{js: js, stream: cfg.Stream}, nil
+}
+
+func (dr *DeadLetterRepo) AddDeadLetter(ctx context.Context, dl deadletter.DeadLetter) error {
+	msg := nats.NewMsg(deadletter.SubjectPrefix + "." + dl.Subject)
+	msg.Data = dl.Data
+	msg.Header.Set(event.ContentTypeHeader, dl.ContentType)
+	msg.Header.Set(deadletter.SubjectHeader, dl.Subject)
+	msg.Header.Set(deadletter.ReasonHeader, dl.Reason)
+	msg.Header.Set(deadletter.ErrorHeader, dl.Error)
+	msg.Header.Set(deadletter.StreamSeqHeader, strconv.FormatUint(dl.StreamSeq, 10))
+	msg.Header.Set(deadletter.ConsumerHeader, dl.Consumer)
+	msg.Header.Set(deadletter.DeliveriesHeader, strconv.FormatUint(dl.Deliveries, 10))
+	msg.Header.Set(deadletter.FailedAtHeader, dl.FailedAt.Format(time.RFC3339Nano))
+
+	_, err := dr.js.PublishMsg(msg, nats.Context(ctx))
+	if err != nil {
+		return fmt.Errorf("nats.AddDeadLetter PublishMsg: %w", err)
+	}
+	return nil
+}
+
+// GetDeadLetters возвращает до limit писем с номером больше afterSeq.
+func (dr *DeadLetterRepo) GetDeadLetters(ctx context.Context, afterSeq uint64, limit int) ([]deadletter.DeadLetter, error) {
+	info, err := dr.js.StreamInfo(dr.stream, nats.Context(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("nats.GetDeadLetters StreamInfo: %w", err)
+	}
+
+	seq := afterSeq + 1
+	if seq < info.State.FirstSeq {
+		seq = info.State.FirstSeq
+	}
+
+	dls := make([]deadletter.DeadLetter, 0, limit)
+	for ; seq <= info.State.LastSeq && len(dls) < limit; seq++ {
+		raw, err := dr.js.GetMsg(dr.stream, seq, nats.Context(ctx))
+		if errors.Is(err, nats.ErrMsgNotFound) {
+			continue // удаленное письмо
+		}
+		if err != nil {
+			return nil, fmt.Errorf("nats.GetDeadLetters GetMsg: %w", err)
+		}
+		dls = append(dls, toDeadLetter(raw))
+	}
+
+	return dls, nil
+}
+
+func (dr *DeadLetterRepo) GetDeadLetter(ctx context.Context, seq uint64) (deadletter.DeadLetter, error) {
+	raw, err := dr.js.GetMsg(dr.stream, seq, nats.Context(ctx))
+	if errors.Is(err, nats.ErrMsgNotFound) {
+		return deadletter.DeadLetter{}, utils.ErrDeadLetterNotFound
+	}
+	if err != nil {
+		return deadletter.DeadLetter{}, fmt.Errorf("nats.GetDeadLetter GetMsg: %w", err)
+	}
+
+	return toDeadLetter(raw), nil
+}
+
+func (dr *DeadLetterRepo) DeleteDeadLetter(ctx context.Context, seq uint64) error {
+	err := dr.js.DeleteMsg(dr.stream, seq, nats.Context(ctx))
+	if errors.Is(err, nats.ErrMsgNotFound) {
+		return utils.ErrDeadLetterNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("nats.DeleteDeadLetter DeleteMsg: %w", err)
+	}
+	return nil
+}
+
+// PurgeDeadLetters удаляет все письма и возвращает их количество.
+func (dr *DeadLetterRepo) PurgeDeadLetters(ctx context.Context) (uint64, error) {
+	info, err := dr.js.StreamInfo(dr.stream, nats.Context(ctx))
+	if err != nil {
+		return 0, fmt.Errorf("nats.PurgeDeadLetters StreamInfo: %w", err)
+	}
+
+	err = dr.js.PurgeStream(dr.stream, nats.Context(ctx))
+	if err != nil {
+		return 0, fmt.Errorf("nats.PurgeDeadLetters PurgeStream: %w", err)
+	}
+
+	return info.State.Msgs, nil
+}
+
+func toDeadLetter(raw *nats.RawStreamMsg) deadletter.DeadLetter {
+	streamSeq, _ := strconv.ParseUint(raw.Header.Get(deadletter.StreamSeqHeader), 10, 64)
+	deliveries, _ := strconv.ParseUint(raw.Header.Get(deadletter.DeliveriesHeader), 10, 64)
+	failedAt, err := time.Parse(time.RFC3339Nano, raw.Header.Get(deadletter.FailedAtHeader))
+	if err != nil {
+		failedAt = raw.Time
+	}
+
+	return deadletter.DeadLetter{
+		Seq:         raw.Sequence,
+		Subject:     raw.Header.Get(deadletter.SubjectHeader),
+		StreamSeq:   streamSeq,
+		Consumer:    raw.Header.Get(deadletter.ConsumerHeader),
+		Reason:      raw.Header.Get(deadletter.ReasonHeader),
+		Error:       raw.Header.Get(deadletter.ErrorHeader),
+		Deliveries:  deliveries,
+		FailedAt:    failedAt,
+		ContentType: raw.Header.Get(event.ContentTypeHeader),
+		Data:        raw.Data,
+	}
+}
@@ -0,0 +1,154 @@
+package nats
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/voikin/hezzl-test/config"
+	"github.com/voikin/hezzl-test/internal/repository/bus"
+	"github.com/voikin/hezzl-test/internal/repository/nats/natstest"
+)
+
+// TestFeedOutlivesAcks проверяет, что лента читает события проекта из своего стрима
+// и после того, как конвейер их подтвердил и стрим конвейера их удалил.
+func TestFeedOutlivesAcks(t *testing.T) {
+	_, _, js := natstest.RunJetStream(t)
+	cfg := testStreamConfig()
+	feed := testFeedConfig()
+	worker := config.Consumer{Durable: "worker", FilterSubject: "events.>", AckWait: time.Minute, MaxDeliver: 5}
+
+	s, err := NewSubscriber(js, cfg, feed, worker)
+	if err != nil {
+		t.Fatalf("NewSubscriber: %v", err)
+	}
+
+	publish(t, js, "events.goods.1", "a")
+	publish(t, js, "events.goods.2", "b")
+	publish(t, js, "events.projects", "c")
+	publish(t, js, "events.goods.1", "d")
+
+	consumer, err := s.Consume(worker)
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	defer consumer.Close()
+
+	var acked int
+	for acked < 4 {
+		msgs, err := consumer.Fetch(context.Background(), 10, time.Second)
+		if err != nil || len(msgs) == 0 {
+			t.Fatalf("Fetch: %d messages, %v", len(msgs), err)
+		}
+		for _, msg := range msgs {
+			if err := msg.Ack(); err != nil {
+				t.Fatalf("Ack: %v", err)
+			}
+			acked++
+		}
+	}
+
+	waitFor(t, "ingestion stream to drop acked messages", func() bool {
+		info, err := js.StreamInfo(cfg.Name)
+		return err == nil && info.State.Msgs == 0
+	})
+	waitFor(t, "feed stream to receive goods events", func() bool {
+		info, err := js.StreamInfo(feed.Name)
+		return err == nil && info.State.Msgs == 3
+	})
+
+	got := follow(t, s, "events.goods.1", 1, 2)
+	if got[0].data != "a" || got[1].data != "d" {
+		t.Fatalf("project 1 feed = %+v, want a, d", got)
+	}
+
+	// возобновление после первого события, как по Last-Event-ID
+	resumed := follow(t, s, "events.goods.1", got[0].seq+1, 1)
+	if resumed[0].data != "d" || resumed[0].seq != got[1].seq {
+		t.Fatalf("resumed feed = %+v, want d at seq %d", resumed, got[1].seq)
+	}
+}
+
+// TestFeedStreamTakesOverSubjects - стрим конвейера из прошлой версии владеет всеми events.>,
+// и стрим ленты можно создать только после того, как он отдаст ему events.goods.<projectId>.
+func TestFeedStreamTakesOverSubjects(t *testing.T) {
+	_, _, js := natstest.RunJetStream(t)
+	cfg := testStreamConfig()
+	cfg.Legacy = ""
+
+	if _, err := js.AddStream(&nats.StreamConfig{Name: cfg.Name, Subjects: []string{"events.>"}, Retention: nats.InterestPolicy}); err != nil {
+		t.Fatalf("AddStream: %v", err)
+	}
+
+	if _, err := NewSubscriber(js, cfg, testFeedConfig()); err != nil {
+		t.Fatalf("NewSubscriber: %v", err)
+	}
+
+	info, err := js.StreamInfo(cfg.Name)
+	if err != nil {
+		t.Fatalf("StreamInfo: %v", err)
+	}
+	if len(info.Config.Sources) != 1 || info.Config.Sources[0].Name != "EVENTS_FEED" {
+		t.Fatalf("ingestion stream sources = %v, want EVENTS_FEED", sourceNames(info.Config.Sources))
+	}
+
+	ack, err := js.Publish("events.goods.1", []byte("a"))
+	if err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if ack.Stream != "EVENTS_FEED" {
+		t.Fatalf("events.goods.1 stored in %s, want EVENTS_FEED", ack.Stream)
+	}
+}
+
+type followed struct {
+	seq  uint64
+	data string
+}
+
+func follow(t *testing.T, s *Subscriber, subject string, startSeq uint64, want int) []followed {
+	t.Helper()
+
+	var (
+		mu  sync.Mutex
+		got []followed
+	)
+	unsubscribe, err := s.Follow(subject, startSeq, func(msg bus.Message) {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, followed{seq: msg.Seq(), data: string(msg.Data())})
+	})
+	if err != nil {
+		t.Fatalf("Follow: %v", err)
+	}
+	defer unsubscribe()
+
+	waitFor(t, "followed messages", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(got) >= want
+	})
+	// лишние сообщения, если они есть, успевают прийти
+	time.Sleep(100 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != want {
+		t.Fatalf("Follow(%s) got %+v, want %d messages", subject, got, want)
+	}
+	return got
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
@@ -2,11 +2,10 @@ package nats
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
+	"log"
 	"time"
 
-	"github.com/nats-io/nats.go"
+	"github.com/google/uuid"
 	"github.com/voikin/hezzl-test/internal/domain/event"
 	"github.com/voikin/hezzl-test/internal/domain/good"
 )
@@ -21,67 +20,57 @@ type GoodRepo interface {
 	UpdateGoodPriority(ctx context.Context, projectID, goodID, newPriority int) ([]good.Good, error)
 }
 
+// EventPublisher - продублировал repository.EventPublisher по той же причине
+type EventPublisher interface {
+	Publish(subject, msgId, contentType string, data []byte)
+	Flush()
+}
+
 type GoodRepoNats struct {
 	GoodRepo
-	stream nats.JetStreamContext
+	publisher   EventPublisher
+	contentType string
 }
 
-func NewGoodRepo(repo GoodRepo, js nats.JetStreamContext) *GoodRepoNats {
-	cfg := &nats.StreamConfig{
-		Name:      "EVENTS",
-		Subjects:  []string{"events.>"},
-		Retention: nats.WorkQueuePolicy,
+func NewGoodRepo(repo GoodRepo, publisher EventPublisher, contentType string) *GoodRepoNats {
+	return &GoodRepoNats{
+		GoodRepo:    repo,
+		publisher:   publisher,
+		contentType: contentType,
 	}
+}
 
-	_, err := js.AddStream(cfg)
+func (grn *GoodRepoNats) CreateGood(ctx context.Context, name string, projectId int) (good.Good, error) {
+	good, err := grn.GoodRepo.CreateGood(ctx, name, projectId)
 	if err != nil {
-		panic(err)
+		return good, err
 	}
 
-	return &GoodRepoNats{
-		GoodRepo: repo,
-		stream:   js,
-	}
+	grn.sendEvent(newGoodEvent(good, event.TypeGoodCreated))
+
+	return good, nil
 }
 
 func (grn *GoodRepoNats) UpdateGood(ctx context.Context, name, description string, id, campaignId int) (good.Good, error) {
 	good, err := grn.GoodRepo.UpdateGood(ctx, name, description, id, campaignId)
-
 	if err != nil {
 		return good, err
 	}
 
-	ce := &event.ClickhouseEvent{
-		Id:          good.ID,
-		ProjectId:   good.ProjectId,
-		Name:        good.Name,
-		Description: good.Description,
-		Priority:    good.Priority,
-		Removed:     good.Removed,
-		EventTime:   time.Now(),
-	}
-
-	grn.sendEvent(ce)
+	grn.sendEvent(newGoodEvent(good, event.TypeGoodUpdated))
 
-	return good, err
+	return good, nil
 }
 
 func (grn *GoodRepoNats) DeleteGood(ctx context.Context, id, projectId int) (good.Good, error) {
 	good, err := grn.GoodRepo.DeleteGood(ctx, id, projectId)
-
-	ce := &event.ClickhouseEvent{
-		Id:          good.ID,
-		ProjectId:   good.ProjectId,
-		Name:        good.Name,
-		Description: good.Description,
-		Priority:    good.Priority,
-		Removed:     good.Removed,
-		EventTime:   time.Now(),
+	if err != nil {
+		return good, err
 	}
 
-	grn.sendEvent(ce)
+	grn.sendEvent(newGoodEvent(good, event.TypeGoodRemoved))
 
-	return good, err
+	return good, nil
 }
 
 func (grn *GoodRepoNats) UpdateGoodPriority(ctx context.Context, projectID, goodID, newPriority int) ([]good.Good, error) {
@@ -91,27 +80,32 @@ func (grn *GoodRepoNats) UpdateGoodPriority(ctx context.Context, projectID, good
 	}
 
 	for _, good := range updatedGoods {
-		ce := &event.ClickhouseEvent{
-			Id:          good.ID,
-			ProjectId:   good.ProjectId,
-			Name:        good.Name,
-			Description: good.Description,
-			Priority:    good.Priority,
-			Removed:     good.Removed,
-			EventTime:   time.Now(),
-		}
-
-		grn.sendEvent(ce)
+		grn.sendEvent(newGoodEvent(good, event.TypeGoodReprioritized))
 	}
 
 	return updatedGoods, nil
 }
 
-func (grn *GoodRepoNats) sendEvent(event *event.ClickhouseEvent) {
-	data, err := json.Marshal(event)
+func (grn *GoodRepoNats) sendEvent(ce *event.ClickhouseEvent) {
+	data, err := event.Marshal(*ce, grn.contentType)
 	if err != nil {
+		log.Printf("nats.sendEvent Marshal: %v", err)
 		return
 	}
-	s, err := grn.stream.Publish("events.goods", data)
-	fmt.Println(s.Domain, s.Stream, s.Sequence, err, event)
+
+	grn.publisher.Publish(event.GoodsSubject(ce.ProjectId), ce.EventId, grn.contentType, data)
+}
+
+func newGoodEvent(good good.Good, eventType string) *event.ClickhouseEvent {
+	return &event.ClickhouseEvent{
+		EventId:     uuid.NewString(),
+		Type:        eventType,
+		Id:          good.ID,
+		ProjectId:   good.ProjectId,
+		Name:        good.Name,
+		Description: good.Description,
+		Priority:    good.Priority,
+		Removed:     good.Removed,
+		EventTime:   time.Now(),
+	}
 }
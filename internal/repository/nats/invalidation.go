@@ -0,0 +1,114 @@
+package nats
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/voikin/hezzl-test/internal/repository/local"
+)
+
+// invalidation - сообщение шины. Seq растет на единицу для каждого сообщения инстанса,
+// по разрыву в Seq получатель понимает, что пропустил инвалидацию.
+type invalidation struct {
+	Instance string   `json:"instance"`
+	Seq      uint64   `json:"seq"`
+	Keys     []string `json:"keys,omitempty"`
+	Prefixes []string `json:"prefixes,omitempty"`
+}
+
+// InvalidationBus рассылает инвалидации локальных кэшей между репликами через core NATS.
+type InvalidationBus struct {
+	nc       *nats.Conn
+	subject  string
+	instance string
+	seq      atomic.Uint64
+	local    *local.Cache
+
+	mu      sync.Mutex
+	lastSeq map[string]uint64
+	sub     *nats.Subscription
+}
+
+func NewInvalidationBus(nc *nats.Conn, subject string, cache *local.Cache) (*InvalidationBus, error) {
+	b := &InvalidationBus{
+		nc:       nc,
+		subject:  subject,
+		instance: uuid.NewString(),
+		local:    cache,
+		lastSeq:  make(map[string]uint64),
+	}
+
+	sub, err := nc.Subscribe(subject, b.handle)
+	if err != nil {
+		return nil, fmt.Errorf("nats.NewInvalidationBus Subscribe: %w", err)
+	}
+	b.sub = sub
+
+	// пока соединения не было, сообщения могли потеряться
+	statuses := nc.StatusChanged(nats.CONNECTED)
+	go func() {
+		for range statuses {
+			b.flush("reconnected")
+		}
+	}()
+
+	return b, nil
+}
+
+// Invalidate сообщает остальным репликам, какие ключи и префиксы ключей надо выкинуть.
+func (b *InvalidationBus) Invalidate(keys, prefixes []string) {
+	data, err := json.Marshal(invalidation{
+		Instance: b.instance,
+		Seq:      b.seq.Add(1),
+		Keys:     keys,
+		Prefixes: prefixes,
+	})
+	if err != nil {
+		log.Printf("nats.Invalidate Marshal: %v", err)
+		return
+	}
+
+	if err := b.nc.Publish(b.subject, data); err != nil {
+		log.Printf("nats.Invalidate Publish: %v", err)
+	}
+}
+
+func (b *InvalidationBus) Close() error {
+	return b.sub.Unsubscribe()
+}
+
+func (b *InvalidationBus) handle(msg *nats.Msg) {
+	var inv invalidation
+	if err := json.Unmarshal(msg.Data, &inv); err != nil {
+		b.flush("malformed message")
+		return
+	}
+
+	// свой локальный кэш уже почищен при мутации
+	if inv.Instance == b.instance {
+		return
+	}
+
+	b.mu.Lock()
+	last, known := b.lastSeq[inv.Instance]
+	b.lastSeq[inv.Instance] = inv.Seq
+	b.mu.Unlock()
+
+	if known && inv.Seq != last+1 {
+		b.flush(fmt.Sprintf("instance %s seq gap %d -> %d", inv.Instance, last, inv.Seq))
+		return
+	}
+
+	b.local.Delete(inv.Keys...)
+	b.local.DeletePrefix(inv.Prefixes...)
+}
+
+func (b *InvalidationBus) flush(reason string) {
+	log.Printf("local cache flushed: %s", reason)
+	b.local.Flush()
+}
@@ -0,0 +1,112 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/voikin/hezzl-test/config"
+)
+
+const (
+	_migrateConsumer = "migrate"
+	_migrateBatch    = 100
+	_migrateWait     = time.Second
+)
+
+// MigrateStream переносит события из стрима cfg.Legacy в стрим cfg.Name и удаляет старый стрим.
+// Retention существующего стрима JetStream не меняет, поэтому создается новый стрим: старый отдает ему
+// сабжекты, новый вместе со всеми durable-консьюмерами создается до первого сообщения (при interest
+// сообщение без консьюмеров сразу удаляется), после чего остаток старого стрима копируется с прежним
+// Nats-Msg-Id. Публикации во время переноса сразу попадают в новый стрим. Возвращает число скопированных сообщений.
+// Повторный запуск после сбоя продолжает с того же места: сообщение удаляется из старого стрима только после копирования.
+func MigrateStream(ctx context.Context, js nats.JetStreamContext, cfg config.Stream, feed config.FeedStream, consumers ...config.Consumer) (int, error) {
+	const fName = "nats.MigrateStream"
+
+	if cfg.Legacy == "" || cfg.Legacy == cfg.Name {
+		return 0, nil
+	}
+
+	legacy, err := js.StreamInfo(cfg.Legacy)
+	if errors.Is(err, nats.ErrStreamNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%s StreamInfo: %w", fName, err)
+	}
+
+	if legacy.Config.Retention == nats.WorkQueuePolicy {
+		// на workqueue сообщение читает только один консьюмер; неподтвержденные
+		// сообщения удаленных консьюмеров остаются в стриме и будут скопированы
+		for name := range js.ConsumerNames(cfg.Legacy) {
+			if name == _migrateConsumer {
+				continue
+			}
+			if err := js.DeleteConsumer(cfg.Legacy, name); err != nil {
+				return 0, fmt.Errorf("%s DeleteConsumer %s: %w", fName, name, err)
+			}
+		}
+	}
+
+	legacyCfg := legacy.Config
+	legacyCfg.Subjects = []string{"migrating." + cfg.Legacy}
+	if _, err := js.UpdateStream(&legacyCfg); err != nil {
+		return 0, fmt.Errorf("%s UpdateStream %s: %w", fName, cfg.Legacy, err)
+	}
+
+	if err := ensurePipeline(js, cfg, feed, consumers); err != nil {
+		return 0, fmt.Errorf("%s: %w", fName, err)
+	}
+
+	sub, err := js.PullSubscribe("", _migrateConsumer, nats.BindStream(cfg.Legacy), nats.AckExplicit())
+	if err != nil {
+		return 0, fmt.Errorf("%s PullSubscribe: %w", fName, err)
+	}
+
+	copied := 0
+	for {
+		fetchCtx, cancel := context.WithTimeout(ctx, _migrateWait)
+		msgs, err := sub.Fetch(_migrateBatch, nats.Context(fetchCtx))
+		cancel()
+		if ctx.Err() != nil {
+			return copied, fmt.Errorf("%s: %w", fName, ctx.Err())
+		}
+		if err != nil && !errors.Is(err, nats.ErrTimeout) && !errors.Is(err, context.DeadlineExceeded) {
+			return copied, fmt.Errorf("%s Fetch: %w", fName, err)
+		}
+
+		for _, msg := range msgs {
+			out := nats.NewMsg(msg.Subject)
+			out.Header = msg.Header
+			out.Data = msg.Data
+			if _, err := js.PublishMsg(out, nats.Context(ctx)); err != nil {
+				return copied, fmt.Errorf("%s PublishMsg: %w", fName, err)
+			}
+			if err := msg.AckSync(nats.Context(ctx)); err != nil {
+				return copied, fmt.Errorf("%s Ack: %w", fName, err)
+			}
+			copied++
+		}
+
+		if len(msgs) != 0 {
+			continue
+		}
+
+		// workqueue удаляет подтвержденные сообщения, остальные политики - нет
+		consumer, err := sub.ConsumerInfo()
+		if err != nil {
+			return copied, fmt.Errorf("%s ConsumerInfo: %w", fName, err)
+		}
+		if consumer.NumPending == 0 && consumer.NumAckPending == 0 {
+			break
+		}
+	}
+
+	if err := js.DeleteStream(cfg.Legacy); err != nil {
+		return copied, fmt.Errorf("%s DeleteStream %s: %w", fName, cfg.Legacy, err)
+	}
+
+	return copied, nil
+}
@@ -0,0 +1,193 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/voikin/hezzl-test/config"
+	"github.com/voikin/hezzl-test/internal/domain/event"
+	"github.com/voikin/hezzl-test/internal/repository/nats/natstest"
+)
+
+func testStreamConfig() config.Stream {
+	return config.Stream{
+		Name:      "EVENTS_V2",
+		Legacy:    "EVENTS",
+		Subjects:  []string{"events.goods", "events.projects"},
+		Retention: "interest",
+		MaxBytes:  -1,
+		Replicas:  1,
+		Storage:   "file",
+	}
+}
+
+func testFeedConfig() config.FeedStream {
+	return config.FeedStream{
+		Name:     "EVENTS_FEED",
+		Subjects: []string{"events.goods.>"},
+		MaxAge:   time.Hour,
+		MaxBytes: -1,
+	}
+}
+
+// productionConsumers - консьюмеры eventSaver и вебхуков из config/config.yaml,
+// чтобы тесты проверяли те же фильтры, что и прод.
+func productionConsumers(t *testing.T) (worker, webhooks config.Consumer) {
+	t.Helper()
+
+	cfg, err := config.New("../../../config/config.yaml")
+	if err != nil {
+		t.Fatalf("config.New: %v", err)
+	}
+	return cfg.Nats.Consumer, cfg.Webhook.Consumer
+}
+
+func publish(t *testing.T, js nats.JetStreamContext, subject, msgId string) {
+	t.Helper()
+	msg := nats.NewMsg(subject)
+	msg.Header.Set(nats.MsgIdHdr, msgId)
+	msg.Data = []byte(msgId)
+	if _, err := js.PublishMsg(msg); err != nil {
+		t.Fatalf("publish %s: %v", msgId, err)
+	}
+}
+
+// TestMigrateStream воспроизводит стрим из первой версии (workqueue с durable worker, у которого
+// есть неподтвержденное сообщение) и проверяет, что перенос ничего не теряет.
+func TestMigrateStream(t *testing.T) {
+	_, _, js := natstest.RunJetStream(t)
+	ctx := context.Background()
+	cfg := testStreamConfig()
+	worker, webhooks := productionConsumers(t)
+
+	if _, err := js.AddStream(&nats.StreamConfig{Name: "EVENTS", Subjects: []string{"events.>"}, Retention: nats.WorkQueuePolicy}); err != nil {
+		t.Fatalf("AddStream: %v", err)
+	}
+	legacySub, err := js.PullSubscribe("events.goods", "worker", nats.BindStream("EVENTS"))
+	if err != nil {
+		t.Fatalf("PullSubscribe: %v", err)
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		publish(t, js, "events.goods", id)
+	}
+	if _, err := legacySub.Fetch(1); err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+
+	if err := EnsureStream(js, cfg); !errors.Is(err, ErrMigrationPending) {
+		t.Fatalf("EnsureStream before migration = %v, want ErrMigrationPending", err)
+	}
+
+	copied, err := MigrateStream(ctx, js, cfg, testFeedConfig(), worker, webhooks)
+	if err != nil {
+		t.Fatalf("MigrateStream: %v", err)
+	}
+	if copied != 3 {
+		t.Fatalf("copied %d messages, want 3", copied)
+	}
+
+	if _, err := js.StreamInfo("EVENTS"); !errors.Is(err, nats.ErrStreamNotFound) {
+		t.Fatalf("legacy stream still exists: %v", err)
+	}
+	if _, err := NewSubscriber(js, cfg, testFeedConfig(), worker, webhooks); err != nil {
+		t.Fatalf("NewSubscriber after migration: %v", err)
+	}
+
+	// старый сабжект, сабжект проекта через EVENTS_FEED и события проектов
+	publish(t, js, "events.goods", "d")
+	publish(t, js, event.GoodsSubject(7), "e")
+	publish(t, js, event.SubjectProjects, "p")
+	// повтор с тем же Nats-Msg-Id отбрасывается и после переноса
+	publish(t, js, "events.goods", "a")
+
+	want := []string{"a", "b", "c", "d", "e", "p"}
+	for _, consumer := range []config.Consumer{worker, webhooks} {
+		durable := consumer.Durable
+		sub, err := js.PullSubscribe(consumer.FilterSubject, durable, nats.Bind("EVENTS_V2", durable))
+		if err != nil {
+			t.Fatalf("PullSubscribe %s: %v", durable, err)
+		}
+
+		// сообщения из EVENTS_FEED доезжают через source асинхронно
+		got := map[string]bool{}
+		deadline := time.Now().Add(5 * time.Second)
+		for len(got) < len(want) && time.Now().Before(deadline) {
+			msgs, err := sub.Fetch(10, nats.MaxWait(200*time.Millisecond))
+			if err != nil && !errors.Is(err, nats.ErrTimeout) {
+				t.Fatalf("Fetch %s: %v", durable, err)
+			}
+			for _, msg := range msgs {
+				id := msg.Header.Get(nats.MsgIdHdr)
+				if got[id] {
+					t.Fatalf("%s got %s twice", durable, id)
+				}
+				got[id] = true
+				_ = msg.AckSync()
+			}
+		}
+		for _, id := range want {
+			if !got[id] {
+				t.Fatalf("%s got %v, want %v", durable, got, want)
+			}
+		}
+	}
+
+	// interest: подтвержденные обоими консьюмерами сообщения удаляются
+	info, err := js.StreamInfo("EVENTS_V2")
+	if err != nil {
+		t.Fatalf("StreamInfo: %v", err)
+	}
+	if info.State.Msgs != 0 {
+		t.Fatalf("stream keeps %d acked messages", info.State.Msgs)
+	}
+
+	copied, err = MigrateStream(ctx, js, cfg, testFeedConfig(), worker, webhooks)
+	if err != nil || copied != 0 {
+		t.Fatalf("second MigrateStream = %d, %v, want a no-op", copied, err)
+	}
+}
+
+func TestEnsureStreamRetentionChange(t *testing.T) {
+	_, _, js := natstest.RunJetStream(t)
+	cfg := testStreamConfig()
+	cfg.Legacy = ""
+
+	if _, err := js.AddStream(&nats.StreamConfig{Name: cfg.Name, Subjects: cfg.Subjects, Retention: nats.WorkQueuePolicy}); err != nil {
+		t.Fatalf("AddStream: %v", err)
+	}
+
+	if err := EnsureStream(js, cfg); !errors.Is(err, ErrMigrationPending) {
+		t.Fatalf("EnsureStream = %v, want ErrMigrationPending", err)
+	}
+}
+
+func TestSubscriberWaitsForMigration(t *testing.T) {
+	_, _, js := natstest.RunJetStream(t)
+	cfg := testStreamConfig()
+	worker, _ := productionConsumers(t)
+
+	if _, err := js.AddStream(&nats.StreamConfig{Name: "EVENTS", Subjects: []string{"events.>"}, Retention: nats.WorkQueuePolicy}); err != nil {
+		t.Fatalf("AddStream: %v", err)
+	}
+
+	s, err := NewSubscriber(js, cfg, testFeedConfig(), worker)
+	if !errors.Is(err, ErrMigrationPending) {
+		t.Fatalf("NewSubscriber = %v, want ErrMigrationPending", err)
+	}
+	if _, err := s.Consume(worker); !errors.Is(err, ErrMigrationPending) {
+		t.Fatalf("Consume before migration = %v, want ErrMigrationPending", err)
+	}
+
+	if _, err := MigrateStream(context.Background(), js, cfg, testFeedConfig(), worker); err != nil {
+		t.Fatalf("MigrateStream: %v", err)
+	}
+
+	consumer, err := s.Consume(worker)
+	if err != nil {
+		t.Fatalf("Consume after migration: %v", err)
+	}
+	_ = consumer.Close()
+}
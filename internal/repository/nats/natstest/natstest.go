@@ -0,0 +1,59 @@
+// Package natstest запускает встроенный NATS-сервер с JetStream для тестов.
+package natstest
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	natsserver "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+)
+
+// RunJetStream поднимает сервер на свободном порту с хранилищем во временном каталоге теста
+// и возвращает подключение к нему; сервер и подключение закрываются вместе с тестом.
+func RunJetStream(t testing.TB) (*server.Server, *nats.Conn, nats.JetStreamContext) {
+	t.Helper()
+
+	opts := natsserver.DefaultTestOptions
+	opts.Port = -1
+	opts.JetStream = true
+	opts.StoreDir = t.TempDir()
+
+	srv := natsserver.RunServer(&opts)
+	t.Cleanup(srv.Shutdown)
+
+	nc, err := nats.Connect(srv.ClientURL(), nats.MaxReconnects(-1), nats.ReconnectWait(50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("connect to NATS: %v", err)
+	}
+	t.Cleanup(nc.Close)
+
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatalf("JetStream: %v", err)
+	}
+
+	return srv, nc, js
+}
+
+// Restart останавливает сервер и поднимает новый на том же порту с тем же хранилищем,
+// как при перезапуске NATS в проде. Подключения из RunJetStream переподключаются сами.
+func Restart(t testing.TB, srv *server.Server) *server.Server {
+	t.Helper()
+
+	opts := natsserver.DefaultTestOptions
+	opts.Port = srv.Addr().(*net.TCPAddr).Port
+	opts.JetStream = true
+	// сервер хранит данные в подкаталоге jetstream каталога из опций
+	opts.StoreDir = filepath.Dir(srv.StoreDir())
+
+	srv.Shutdown()
+	srv.WaitForShutdown()
+
+	restarted := natsserver.RunServer(&opts)
+	t.Cleanup(restarted.Shutdown)
+	return restarted
+}
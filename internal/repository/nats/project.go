@@ -0,0 +1,89 @@
+package nats
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/voikin/hezzl-test/internal/domain/event"
+	"github.com/voikin/hezzl-test/internal/domain/project"
+)
+
+// чтобы не было цикличного импорта из repository
+type ProjectRepo interface {
+	CreateProject(ctx context.Context, name string) (project.Project, error)
+	UpdateProject(ctx context.Context, name string, id int) (project.Project, error)
+	DeleteProject(ctx context.Context, id int) (project.Project, error)
+	GetProject(ctx context.Context, id int) (project.Project, error)
+	GetProjects(ctx context.Context) ([]project.Project, error)
+}
+
+type ProjectRepoNats struct {
+	ProjectRepo
+	publisher   EventPublisher
+	contentType string
+}
+
+func NewProjectRepo(repo ProjectRepo, publisher EventPublisher, contentType string) *ProjectRepoNats {
+	return &ProjectRepoNats{
+		ProjectRepo: repo,
+		publisher:   publisher,
+		contentType: contentType,
+	}
+}
+
+func (prn *ProjectRepoNats) CreateProject(ctx context.Context, name string) (project.Project, error) {
+	proj, err := prn.ProjectRepo.CreateProject(ctx, name)
+	if err != nil {
+		return proj, err
+	}
+
+	prn.sendEvent(newProjectEvent(proj, event.TypeProjectCreated))
+
+	return proj, nil
+}
+
+func (prn *ProjectRepoNats) UpdateProject(ctx context.Context, name string, id int) (project.Project, error) {
+	proj, err := prn.ProjectRepo.UpdateProject(ctx, name, id)
+	if err != nil {
+		return proj, err
+	}
+
+	prn.sendEvent(newProjectEvent(proj, event.TypeProjectUpdated))
+
+	return proj, nil
+}
+
+func (prn *ProjectRepoNats) DeleteProject(ctx context.Context, id int) (project.Project, error) {
+	proj, err := prn.ProjectRepo.DeleteProject(ctx, id)
+	if err != nil {
+		return proj, err
+	}
+
+	pe := newProjectEvent(proj, event.TypeProjectRemoved)
+	pe.Removed = true
+	prn.sendEvent(pe)
+
+	return proj, nil
+}
+
+func (prn *ProjectRepoNats) sendEvent(pe *event.ProjectEvent) {
+	data, err := event.MarshalProjectEvent(*pe, prn.contentType)
+	if err != nil {
+		log.Printf("nats.sendEvent MarshalProjectEvent: %v", err)
+		return
+	}
+
+	prn.publisher.Publish(event.SubjectProjects, pe.EventId, prn.contentType, data)
+}
+
+func newProjectEvent(proj project.Project, eventType string) *event.ProjectEvent {
+	return &event.ProjectEvent{
+		EventId:   uuid.NewString(),
+		Type:      eventType,
+		Id:        proj.ID,
+		Name:      proj.Name,
+		EventTime: time.Now(),
+	}
+}
@@ -0,0 +1,251 @@
+package nats
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/voikin/hezzl-test/config"
+	"github.com/voikin/hezzl-test/internal/domain/event"
+	"github.com/voikin/hezzl-test/internal/repository/bus"
+)
+
+var (
+	errNotConnected = errors.New("nats: not connected")
+	errAckTimeout   = errors.New("nats: publish ack timeout")
+)
+
+// Publisher асинхронно публикует события в JetStream с ретраями.
+// Если NATS недоступен, события складываются в спул на диске и отправляются
+// после переподключения, после следующей успешной публикации или по таймеру.
+type Publisher struct {
+	nc    *nats.Conn
+	js    nats.JetStreamContext
+	spool *bus.Spool
+
+	inFlight        chan struct{}
+	maxRetries      int
+	retryBackoff    time.Duration
+	maxRetryBackoff time.Duration
+	ackTimeout      time.Duration
+
+	// spooled - в спуле могут быть записи, успешная публикация запускает его разбор
+	spooled atomic.Bool
+	stop    chan struct{}
+	once    sync.Once
+
+	// pending - число начатых публикаций и разборов спула. Это счетчик под мьютексом, а не WaitGroup:
+	// разбор спула стартует из обработчика переподключения в любой момент, в том числе во время Flush
+	mu       sync.Mutex
+	idle     *sync.Cond
+	pending  int
+	draining bool
+}
+
+func NewPublisher(nc *nats.Conn, cfg config.Publish) (*Publisher, error) {
+	js, err := nc.JetStream(nats.PublishAsyncMaxPending(cfg.MaxInFlight))
+	if err != nil {
+		return nil, fmt.Errorf("nats.NewPublisher JetStream: %w", err)
+	}
+
+	spool, err := bus.NewSpool(cfg.SpoolDir)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Publisher{
+		nc:              nc,
+		js:              js,
+		spool:           spool,
+		inFlight:        make(chan struct{}, cfg.MaxInFlight),
+		maxRetries:      cfg.MaxRetries,
+		retryBackoff:    cfg.RetryBackoff,
+		maxRetryBackoff: cfg.MaxRetryBackoff,
+		ackTimeout:      cfg.AckTimeout,
+		stop:            make(chan struct{}),
+	}
+	p.idle = sync.NewCond(&p.mu)
+
+	nc.SetReconnectHandler(func(*nats.Conn) {
+		p.drainSpool()
+	})
+	p.drainSpool()
+	if cfg.SpoolDrainInterval > 0 {
+		go p.drainPeriodically(cfg.SpoolDrainInterval)
+	}
+
+	return p, nil
+}
+
+// Close останавливает периодический разбор спула. Начатые публикации дожидается Flush.
+func (p *Publisher) Close() {
+	p.once.Do(func() { close(p.stop) })
+}
+
+// drainPeriodically разбирает спул, даже если соединение не рвалось:
+// туда попадают и события, которые JetStream отклонил при живом соединении.
+func (p *Publisher) drainPeriodically(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			if p.nc.IsConnected() {
+				p.drainSpool()
+			}
+		}
+	}
+}
+
+// Publish ставит событие в очередь на отправку и не блокирует вызывающего,
+// пока число неподтвержденных сообщений не превысит MaxInFlight.
+func (p *Publisher) Publish(subject, msgId, contentType string, data []byte) {
+	rec := bus.SpoolRecord{Subject: subject, MsgId: msgId, ContentType: contentType, Data: data}
+
+	if !p.nc.IsConnected() {
+		p.toSpool(rec)
+		return
+	}
+
+	p.inFlight <- struct{}{}
+	p.begin()
+	go func() {
+		defer func() {
+			<-p.inFlight
+			p.done()
+		}()
+
+		if err := p.publishWithRetry(rec); err != nil {
+			log.Printf("nats publish %s %s: %v, spooling", subject, msgId, err)
+			p.toSpool(rec)
+			return
+		}
+		if p.spooled.Load() {
+			p.drainSpool()
+		}
+	}()
+}
+
+// Flush ждет завершения всех начатых публикаций и разбора спула,
+// включая разбор, начатый переподключением уже во время ожидания.
+func (p *Publisher) Flush() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for p.pending > 0 {
+		p.idle.Wait()
+	}
+}
+
+func (p *Publisher) begin() {
+	p.mu.Lock()
+	p.pending++
+	p.mu.Unlock()
+}
+
+func (p *Publisher) done() {
+	p.mu.Lock()
+	p.pending--
+	if p.pending == 0 {
+		p.idle.Broadcast()
+	}
+	p.mu.Unlock()
+}
+
+func (p *Publisher) publishWithRetry(rec bus.SpoolRecord) error {
+	backoff := p.retryBackoff
+	var err error
+
+	for attempt := 0; attempt <= p.maxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+			if backoff > p.maxRetryBackoff {
+				backoff = p.maxRetryBackoff
+			}
+		}
+
+		if !p.nc.IsConnected() {
+			err = errNotConnected
+			continue
+		}
+
+		err = p.publishOnce(rec)
+		if err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+func (p *Publisher) publishOnce(rec bus.SpoolRecord) error {
+	msg := nats.NewMsg(rec.Subject)
+	msg.Data = rec.Data
+	// JetStream отбрасывает дубликаты с тем же Nats-Msg-Id, поэтому ретраи безопасны
+	msg.Header.Set(nats.MsgIdHdr, rec.MsgId)
+	if rec.ContentType != "" {
+		msg.Header.Set(event.ContentTypeHeader, rec.ContentType)
+	}
+
+	future, err := p.js.PublishMsgAsync(msg)
+	if err != nil {
+		return err
+	}
+
+	// после переподключения nats.go помечает уже отправленные публикации ошибкой,
+	// но в канал Err ее не пишет, поэтому ожидание ограничено таймаутом и считается сбоем
+	timer := time.NewTimer(p.ackTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-future.Ok():
+		return nil
+	case err := <-future.Err():
+		return err
+	case <-timer.C:
+		return errAckTimeout
+	}
+}
+
+func (p *Publisher) toSpool(rec bus.SpoolRecord) {
+	if err := p.spool.Append(rec); err != nil {
+		log.Printf("nats spool %s %s: %v, event lost", rec.Subject, rec.MsgId, err)
+		return
+	}
+	p.spooled.Store(true)
+}
+
+func (p *Publisher) drainSpool() {
+	p.mu.Lock()
+	if p.draining {
+		p.mu.Unlock()
+		return
+	}
+	p.draining = true
+	p.pending++
+	p.mu.Unlock()
+	p.spooled.Store(false)
+
+	go func() {
+		defer func() {
+			p.mu.Lock()
+			p.draining = false
+			p.mu.Unlock()
+			p.done()
+		}()
+
+		err := p.spool.Drain(p.publishWithRetry)
+		if err != nil {
+			// неотправленные записи вернулись в спул
+			p.spooled.Store(true)
+			log.Printf("nats spool drain: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,144 @@
+package nats
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/voikin/hezzl-test/config"
+	"github.com/voikin/hezzl-test/internal/repository/nats/natstest"
+)
+
+func testPublishConfig(t *testing.T) config.Publish {
+	return config.Publish{
+		MaxInFlight:        16,
+		MaxRetries:         3,
+		RetryBackoff:       20 * time.Millisecond,
+		MaxRetryBackoff:    100 * time.Millisecond,
+		AckTimeout:         300 * time.Millisecond,
+		SpoolDir:           t.TempDir(),
+		SpoolDrainInterval: 100 * time.Millisecond,
+	}
+}
+
+func newTestPublisher(t *testing.T, nc *nats.Conn, cfg config.Publish) *Publisher {
+	t.Helper()
+
+	p, err := NewPublisher(nc, cfg)
+	if err != nil {
+		t.Fatalf("NewPublisher: %v", err)
+	}
+	t.Cleanup(p.Close)
+	return p
+}
+
+func addTestStream(t *testing.T, js nats.JetStreamContext) {
+	t.Helper()
+
+	if _, err := js.AddStream(&nats.StreamConfig{Name: "TEST", Subjects: []string{"test.>"}, Storage: nats.FileStorage}); err != nil {
+		t.Fatalf("AddStream: %v", err)
+	}
+}
+
+func streamMsgs(js nats.JetStreamContext) uint64 {
+	info, err := js.StreamInfo("TEST")
+	if err != nil {
+		return 0
+	}
+	return info.State.Msgs
+}
+
+// flushWithin падает, если Flush не вернулся за timeout.
+func flushWithin(t *testing.T, p *Publisher, timeout time.Duration) {
+	t.Helper()
+
+	done := make(chan struct{})
+	go func() {
+		p.Flush()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		p.mu.Lock()
+		pending := p.pending
+		p.mu.Unlock()
+		t.Fatalf("Flush did not return in %s, pending=%d", timeout, pending)
+	}
+}
+
+// TestPublisherSurvivesRestart: публикации, отправленные во время перезапуска NATS, не зависают
+// и не теряются: Flush возвращается, а все события в итоге оказываются в стриме.
+func TestPublisherSurvivesRestart(t *testing.T) {
+	srv, nc, js := natstest.RunJetStream(t)
+	addTestStream(t, js)
+	p := newTestPublisher(t, nc, testPublishConfig(t))
+
+	const total = 100
+	for i := 0; i < total/2; i++ {
+		p.Publish("test.a", fmt.Sprintf("m%d", i), "", []byte("x"))
+	}
+	natstest.Restart(t, srv)
+	for i := total / 2; i < total; i++ {
+		p.Publish("test.a", fmt.Sprintf("m%d", i), "", []byte("x"))
+	}
+
+	flushWithin(t, p, 10*time.Second)
+	waitFor(t, "all events in the stream", func() bool { return streamMsgs(js) == total })
+}
+
+// TestPublisherBoundsAckWait: подтверждение, которое никогда не придет, не держит публикацию
+// вечно - событие уходит в спул, а Flush возвращается.
+func TestPublisherBoundsAckWait(t *testing.T) {
+	_, nc, js := natstest.RunJetStream(t)
+	// обычный подписчик без ответа: публикация получает адресата, но не подтверждение
+	sub, err := nc.Subscribe("test.blackhole", func(*nats.Msg) {})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	cfg := testPublishConfig(t)
+	cfg.SpoolDrainInterval = 0
+	p := newTestPublisher(t, nc, cfg)
+
+	p.Publish("test.blackhole", "lost-ack", "", []byte("x"))
+	flushWithin(t, p, 5*time.Second)
+
+	if entries, err := os.ReadDir(cfg.SpoolDir); err != nil || len(entries) == 0 {
+		t.Fatalf("event was not spooled: %v", err)
+	}
+
+	// после появления стрима событие доезжает из спула при следующей успешной публикации
+	if err := sub.Unsubscribe(); err != nil {
+		t.Fatalf("Unsubscribe: %v", err)
+	}
+	if _, err := js.AddStream(&nats.StreamConfig{Name: "TEST", Subjects: []string{"test.>"}}); err != nil {
+		t.Fatalf("AddStream: %v", err)
+	}
+	p.Publish("test.a", "next", "", []byte("x"))
+	waitFor(t, "spooled event published", func() bool { return streamMsgs(js) == 2 })
+}
+
+// TestPublisherDrainsSpoolWhileConnected: события, отклоненные JetStream без обрыва соединения,
+// разбираются из спула по таймеру, не дожидаясь переподключения.
+func TestPublisherDrainsSpoolWhileConnected(t *testing.T) {
+	_, nc, js := natstest.RunJetStream(t)
+	cfg := testPublishConfig(t)
+	p := newTestPublisher(t, nc, cfg)
+
+	// стрима еще нет: JetStream отвечает ошибкой, события уходят в спул
+	for i := 0; i < 3; i++ {
+		p.Publish("test.a", fmt.Sprintf("m%d", i), "", []byte("x"))
+	}
+	flushWithin(t, p, 5*time.Second)
+
+	addTestStream(t, js)
+	waitFor(t, "spool drained", func() bool { return streamMsgs(js) == 3 })
+
+	if reconnects := nc.Stats().Reconnects; reconnects != 0 {
+		t.Fatalf("connection reconnected %d times", reconnects)
+	}
+}
@@ -0,0 +1,200 @@
+package nats
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/nats-io/nats.go"
+	"github.com/voikin/hezzl-test/config"
+)
+
+// ErrMigrationPending - стрим из конфига еще нельзя создать или обновить без cmd/migrate.
+var ErrMigrationPending = errors.New("nats: stream migration pending")
+
+// EnsureStream создает стрим из конфига, а если он уже есть - приводит его настройки к конфигу.
+// Если сабжекты еще занимает стрим Legacy или нужна смена retention, которую JetStream не умеет
+// делать на месте, возвращает ErrMigrationPending с описанием, что запустить.
+func EnsureStream(js nats.JetStreamContext, cfg config.Stream) error {
+	if err := checkLegacy(js, cfg); err != nil {
+		return err
+	}
+
+	return ensureStream(js, cfg)
+}
+
+func checkLegacy(js nats.JetStreamContext, cfg config.Stream) error {
+	const fName = "nats.EnsureStream"
+
+	if cfg.Legacy != "" && cfg.Legacy != cfg.Name {
+		_, err := js.StreamInfo(cfg.Legacy)
+		if err == nil {
+			return fmt.Errorf("%s: %w: stream %s still owns %v, run cmd/migrate to move it into %s",
+				fName, ErrMigrationPending, cfg.Legacy, cfg.Subjects, cfg.Name)
+		}
+		if !errors.Is(err, nats.ErrStreamNotFound) {
+			return fmt.Errorf("%s StreamInfo: %w", fName, err)
+		}
+	}
+
+	return nil
+}
+
+// ensurePipeline готовит стримы конвейера: стрим ленты cfg.Name владеет сабжектами событий товаров
+// и хранит их MaxAge, стрим конвейера копирует их из него, а остальные события получает напрямую.
+// Так консьюмеры конвейера подтверждают собственные копии, и лента не зависит от их скорости.
+// Источник добавляется в стрим конвейера последним: при interest сообщение, пришедшее раньше
+// durable-консьюмеров, сразу удаляется.
+func ensurePipeline(js nats.JetStreamContext, cfg config.Stream, feed config.FeedStream, consumers []config.Consumer) error {
+	const fName = "nats.ensurePipeline"
+
+	// существующий стрим сразу отдает сабжекты ленты, новый создается пока без источника
+	_, err := js.StreamInfo(cfg.Name)
+	switch {
+	case errors.Is(err, nats.ErrStreamNotFound):
+		err = ensureStream(js, cfg)
+	case err == nil:
+		err = ensureStream(js, cfg, feed.Name)
+	default:
+		err = fmt.Errorf("%s StreamInfo: %w", fName, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, consumer := range consumers {
+		if err := EnsureConsumer(js, cfg.Name, consumer); err != nil {
+			return err
+		}
+	}
+
+	err = ensureStream(js, config.Stream{
+		Name:      feed.Name,
+		Subjects:  feed.Subjects,
+		Retention: "limits",
+		MaxAge:    feed.MaxAge,
+		MaxBytes:  feed.MaxBytes,
+		Replicas:  cfg.Replicas,
+		Storage:   cfg.Storage,
+	})
+	if err != nil {
+		return err
+	}
+
+	return ensureStream(js, cfg, feed.Name)
+}
+
+// ensureStream создает или обновляет стрим; sources - стримы, из которых сервер копирует в него сообщения.
+func ensureStream(js nats.JetStreamContext, cfg config.Stream, sources ...string) error {
+	const fName = "nats.EnsureStream"
+	streamCfg, err := streamConfig(cfg, sources)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fName, err)
+	}
+
+	info, err := js.StreamInfo(cfg.Name)
+	if errors.Is(err, nats.ErrStreamNotFound) {
+		_, err = js.AddStream(streamCfg)
+		if err != nil {
+			return fmt.Errorf("%s AddStream: %w", fName, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s StreamInfo: %w", fName, err)
+	}
+
+	if info.Config.Retention != streamCfg.Retention {
+		return fmt.Errorf("%s: %w: stream %s has %s retention, config wants %s; JetStream can't change it in place, "+
+			"set stream.legacy to %s, give stream.name a new name and run cmd/migrate",
+			fName, ErrMigrationPending, cfg.Name, info.Config.Retention, streamCfg.Retention, cfg.Name)
+	}
+
+	if streamUpToDate(info.Config, *streamCfg) {
+		return nil
+	}
+
+	_, err = js.UpdateStream(streamCfg)
+	if err != nil {
+		return fmt.Errorf("%s UpdateStream: %w", fName, err)
+	}
+
+	return nil
+}
+
+// EnsureConsumer создает durable pull-консьюмер или обновляет его настройки.
+func EnsureConsumer(js nats.JetStreamContext, stream string, cfg config.Consumer) error {
+	const fName = "nats.EnsureConsumer"
+	consumerCfg := &nats.ConsumerConfig{
+		Durable:       cfg.Durable,
+		FilterSubject: cfg.FilterSubject,
+		AckPolicy:     nats.AckExplicitPolicy,
+		AckWait:       cfg.AckWait,
+		MaxDeliver:    cfg.MaxDeliver,
+	}
+
+	_, err := js.ConsumerInfo(stream, cfg.Durable)
+	if errors.Is(err, nats.ErrConsumerNotFound) {
+		_, err = js.AddConsumer(stream, consumerCfg)
+		if err != nil {
+			return fmt.Errorf("%s AddConsumer: %w", fName, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s ConsumerInfo: %w", fName, err)
+	}
+
+	_, err = js.UpdateConsumer(stream, consumerCfg)
+	if err != nil {
+		return fmt.Errorf("%s UpdateConsumer: %w", fName, err)
+	}
+
+	return nil
+}
+
+func streamConfig(cfg config.Stream, sources []string) (*nats.StreamConfig, error) {
+	var retention nats.RetentionPolicy
+	if err := retention.UnmarshalJSON([]byte(strconv.Quote(cfg.Retention))); err != nil {
+		return nil, fmt.Errorf("retention: %w", err)
+	}
+
+	var storage nats.StorageType
+	if err := storage.UnmarshalJSON([]byte(strconv.Quote(cfg.Storage))); err != nil {
+		return nil, fmt.Errorf("storage: %w", err)
+	}
+
+	var streamSources []*nats.StreamSource
+	for _, source := range sources {
+		streamSources = append(streamSources, &nats.StreamSource{Name: source})
+	}
+
+	return &nats.StreamConfig{
+		Name:      cfg.Name,
+		Subjects:  cfg.Subjects,
+		Sources:   streamSources,
+		Retention: retention,
+		MaxAge:    cfg.MaxAge,
+		MaxBytes:  cfg.MaxBytes,
+		Replicas:  cfg.Replicas,
+		Storage:   storage,
+	}, nil
+}
+
+func sourceNames(sources []*nats.StreamSource) []string {
+	var names []string
+	for _, source := range sources {
+		names = append(names, source.Name)
+	}
+	return names
+}
+
+func streamUpToDate(current, wanted nats.StreamConfig) bool {
+	return reflect.DeepEqual(current.Subjects, wanted.Subjects) &&
+		reflect.DeepEqual(sourceNames(current.Sources), sourceNames(wanted.Sources)) &&
+		current.MaxAge == wanted.MaxAge &&
+		current.MaxBytes == wanted.MaxBytes &&
+		current.Replicas == wanted.Replicas &&
+		current.Storage == wanted.Storage
+}
@@ -0,0 +1,164 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/voikin/hezzl-test/config"
+	"github.com/voikin/hezzl-test/internal/repository/bus"
+)
+
+// Subscriber читает события из стрима JetStream: durable-консьюмеры - из стрима конвейера,
+// Follow - из стрима ленты изменений.
+type Subscriber struct {
+	js        nats.JetStreamContext
+	cfg       config.Stream
+	feed      config.FeedStream
+	consumers []config.Consumer
+
+	mu    sync.Mutex
+	ready bool
+}
+
+// NewSubscriber приводит стримы конвейера и ленты к настройкам из конфига и заранее создает durable-консьюмеры:
+// при retention interest сообщение, опубликованное до появления консьюмера, ему уже не достанется.
+// Если стрим ждет cmd/migrate, возвращает подписчика вместе с ErrMigrationPending: Consume и Follow
+// будут возвращать ту же ошибку, пока перенос не закончится, а после заработают без перезапуска.
+func NewSubscriber(js nats.JetStreamContext, cfg config.Stream, feed config.FeedStream, consumers ...config.Consumer) (*Subscriber, error) {
+	s := &Subscriber{js: js, cfg: cfg, feed: feed, consumers: consumers}
+	return s, s.ensure()
+}
+
+func (s *Subscriber) ensure() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ready {
+		return nil
+	}
+
+	if err := checkLegacy(s.js, s.cfg); err != nil {
+		return err
+	}
+	if err := ensurePipeline(s.js, s.cfg, s.feed, s.consumers); err != nil {
+		return err
+	}
+
+	s.ready = true
+	return nil
+}
+
+func (s *Subscriber) Consume(cfg config.Consumer) (bus.Consumer, error) {
+	if err := s.ensure(); err != nil {
+		return nil, err
+	}
+
+	err := EnsureConsumer(s.js, s.cfg.Name, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// nats.go сверяет subject подписки с FilterSubject консьюмера
+	sub, err := s.js.PullSubscribe(cfg.FilterSubject, cfg.Durable, nats.Bind(s.cfg.Name, cfg.Durable))
+	if err != nil {
+		return nil, fmt.Errorf("nats.Consume PullSubscribe: %w", err)
+	}
+
+	return &pullConsumer{sub: sub}, nil
+}
+
+func (s *Subscriber) Follow(subject string, startSeq uint64, handler func(bus.Message)) (func() error, error) {
+	if err := s.ensure(); err != nil {
+		return nil, err
+	}
+
+	opts := []nats.SubOpt{nats.OrderedConsumer(), nats.BindStream(s.feed.Name)}
+	if startSeq > 0 {
+		opts = append(opts, nats.StartSequence(startSeq))
+	} else {
+		opts = append(opts, nats.DeliverNew())
+	}
+
+	sub, err := s.js.Subscribe(subject, func(msg *nats.Msg) {
+		handler(newMessage(msg))
+	}, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("nats.Follow Subscribe: %w", err)
+	}
+
+	return sub.Unsubscribe, nil
+}
+
+type pullConsumer struct {
+	sub *nats.Subscription
+}
+
+func (c *pullConsumer) Fetch(ctx context.Context, batch int, maxWait time.Duration) ([]bus.Message, error) {
+	fetchCtx, cancel := context.WithTimeout(ctx, maxWait)
+	defer cancel()
+
+	msgs, err := c.sub.Fetch(batch, nats.Context(fetchCtx))
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, nats.ErrTimeout) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("nats.Fetch: %w", err)
+	}
+
+	out := make([]bus.Message, 0, len(msgs))
+	for _, msg := range msgs {
+		out = append(out, newMessage(msg))
+	}
+
+	return out, nil
+}
+
+func (c *pullConsumer) Close() error {
+	return c.sub.Unsubscribe()
+}
+
+type message struct {
+	msg  *nats.Msg
+	meta *nats.MsgMetadata
+}
+
+func newMessage(msg *nats.Msg) *message {
+	meta, err := msg.Metadata()
+	if err != nil {
+		meta = &nats.MsgMetadata{}
+	}
+	return &message{msg: msg, meta: meta}
+}
+
+func (m *message) Subject() string          { return m.msg.Subject }
+func (m *message) Data() []byte             { return m.msg.Data }
+func (m *message) Header(key string) string { return m.msg.Header.Get(key) }
+func (m *message) Seq() uint64              { return m.meta.Sequence.Stream }
+func (m *message) Timestamp() time.Time     { return m.meta.Timestamp }
+func (m *message) NumDelivered() uint64     { return m.meta.NumDelivered }
+
+func (m *message) Ack() error {
+	return m.msg.Ack()
+}
+
+func (m *message) Nak(delay time.Duration) error {
+	if delay <= 0 {
+		return m.msg.Nak()
+	}
+	return m.msg.NakWithDelay(delay)
+}
+
+func (m *message) Term() error {
+	return m.msg.Term()
+}
+
+func (m *message) InProgress() error {
+	return m.msg.InProgress()
+}
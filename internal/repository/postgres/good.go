@@ -150,6 +150,35 @@ func (gr *GoodRepo) GetGoods(ctx context.Context, limit, offset int) ([]good.Goo
 	return goodsList, nil
 }
 
+// GetGoodsAfter возвращает до limit товаров с id больше afterId по возрастанию id,
+// включая удаленные. Нужен для постраничного обхода всей таблицы.
+func (gr *GoodRepo) GetGoodsAfter(ctx context.Context, afterId, limit int) ([]good.Good, error) {
+	const fName = "GetGoodsAfter"
+	rows, err := gr.db.QueryContext(ctx,
+		"SELECT id, project_id, name, description, priority, removed, created_at FROM goods WHERE id > $1 ORDER BY id LIMIT $2",
+		afterId, limit)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fName, err)
+	}
+	defer rows.Close()
+
+	var goodsList []good.Good
+	for rows.Next() {
+		var good good.Good
+		err := rows.Scan(&good.ID, &good.ProjectId, &good.Name, &good.Description, &good.Priority, &good.Removed, &good.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fName, err)
+		}
+		goodsList = append(goodsList, good)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", fName, err)
+	}
+
+	return goodsList, nil
+}
+
 func (gr *GoodRepo) UpdateGoodPriority(ctx context.Context, projectID, goodID, newPriority int) ([]good.Good, error) {
 	const fName = "UpdateGoodPriority"
 
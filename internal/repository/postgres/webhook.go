@@ -0,0 +1,307 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/voikin/hezzl-test/internal/domain/webhook"
+	"github.com/voikin/hezzl-test/internal/utils"
+)
+
+const webhookColumns = "id, project_id, url, event_types, secret, enabled, failures, created_at"
+
+type WebhookRepo struct {
+	db *sql.DB
+}
+
+func NewWebhookRepo(db *sql.DB) *WebhookRepo {
+	return &WebhookRepo{
+		db: db,
+	}
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanWebhook(row scanner) (webhook.Webhook, error) {
+	var wh webhook.Webhook
+	err := row.Scan(&wh.ID, &wh.ProjectId, &wh.URL, pq.Array(&wh.EventTypes), &wh.Secret, &wh.Enabled, &wh.Failures, &wh.CreatedAt)
+	return wh, err
+}
+
+func (wr *WebhookRepo) CreateWebhook(ctx context.Context, wh webhook.Webhook) (webhook.Webhook, error) {
+	const fName = "CreateWebhook"
+	row := wr.db.QueryRowContext(ctx,
+		"INSERT INTO webhooks (project_id, url, event_types, secret) VALUES ($1, $2, $3, $4) RETURNING "+webhookColumns,
+		wh.ProjectId, wh.URL, pq.Array(wh.EventTypes), wh.Secret)
+
+	created, err := scanWebhook(row)
+	if err != nil {
+		return webhook.Webhook{}, fmt.Errorf("%s: %w", fName, err)
+	}
+
+	return created, nil
+}
+
+func (wr *WebhookRepo) UpdateWebhook(ctx context.Context, wh webhook.Webhook) (webhook.Webhook, error) {
+	const fName = "UpdateWebhook"
+	row := wr.db.QueryRowContext(ctx,
+		"UPDATE webhooks SET url = $1, event_types = $2, enabled = $3, failures = CASE WHEN $3 THEN 0 ELSE failures END WHERE id = $4 AND project_id = $5 RETURNING "+webhookColumns,
+		wh.URL, pq.Array(wh.EventTypes), wh.Enabled, wh.ID, wh.ProjectId)
+
+	updated, err := scanWebhook(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return webhook.Webhook{}, utils.ErrWebhookNotFound
+		}
+		return webhook.Webhook{}, fmt.Errorf("%s: %w", fName, err)
+	}
+
+	return updated, nil
+}
+
+func (wr *WebhookRepo) DeleteWebhook(ctx context.Context, id, projectId int) (webhook.Webhook, error) {
+	const fName = "DeleteWebhook"
+	row := wr.db.QueryRowContext(ctx, "DELETE FROM webhooks WHERE id = $1 AND project_id = $2 RETURNING "+webhookColumns, id, projectId)
+
+	deleted, err := scanWebhook(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return webhook.Webhook{}, utils.ErrWebhookNotFound
+		}
+		return webhook.Webhook{}, fmt.Errorf("%s: %w", fName, err)
+	}
+
+	return deleted, nil
+}
+
+func (wr *WebhookRepo) GetWebhook(ctx context.Context, id, projectId int) (webhook.Webhook, error) {
+	const fName = "GetWebhook"
+	row := wr.db.QueryRowContext(ctx, "SELECT "+webhookColumns+" FROM webhooks WHERE id = $1 AND project_id = $2", id, projectId)
+
+	wh, err := scanWebhook(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return webhook.Webhook{}, utils.ErrWebhookNotFound
+		}
+		return webhook.Webhook{}, fmt.Errorf("%s: %w", fName, err)
+	}
+
+	return wh, nil
+}
+
+func (wr *WebhookRepo) GetWebhooks(ctx context.Context, projectId int) ([]webhook.Webhook, error) {
+	const fName = "GetWebhooks"
+	rows, err := wr.db.QueryContext(ctx, "SELECT "+webhookColumns+" FROM webhooks WHERE project_id = $1 ORDER BY id", projectId)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fName, err)
+	}
+	defer rows.Close()
+
+	var webhooks []webhook.Webhook
+	for rows.Next() {
+		wh, err := scanWebhook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fName, err)
+		}
+		webhooks = append(webhooks, wh)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", fName, err)
+	}
+
+	return webhooks, nil
+}
+
+func (wr *WebhookRepo) GetActiveWebhooks(ctx context.Context, projectId int, eventType string) ([]webhook.Webhook, error) {
+	const fName = "GetActiveWebhooks"
+	rows, err := wr.db.QueryContext(ctx,
+		"SELECT "+webhookColumns+" FROM webhooks WHERE project_id = $1 AND enabled AND (cardinality(event_types) = 0 OR $2 = ANY(event_types))",
+		projectId, eventType)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fName, err)
+	}
+	defer rows.Close()
+
+	var webhooks []webhook.Webhook
+	for rows.Next() {
+		wh, err := scanWebhook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fName, err)
+		}
+		webhooks = append(webhooks, wh)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", fName, err)
+	}
+
+	return webhooks, nil
+}
+
+// EnqueueJobs планирует доставку события вебхукам. Повторная постановка того же события
+// (например, после переотправки сообщения шиной) ничего не делает.
+func (wr *WebhookRepo) EnqueueJobs(ctx context.Context, webhookIds []int, eventId, eventType string, body []byte) error {
+	const fName = "EnqueueJobs"
+	ids := make([]int64, len(webhookIds))
+	for i, id := range webhookIds {
+		ids[i] = int64(id)
+	}
+
+	_, err := wr.db.ExecContext(ctx,
+		"INSERT INTO webhook_jobs (webhook_id, event_id, event_type, body) SELECT unnest($1::int[]), $2, $3, $4 ON CONFLICT (webhook_id, event_id) DO NOTHING",
+		pq.Array(ids), eventId, eventType, string(body))
+	if err != nil {
+		return fmt.Errorf("%s: %w", fName, err)
+	}
+
+	return nil
+}
+
+// ClaimJobs забирает до limit доставок, время которых подошло, и откладывает их на lease,
+// чтобы другой экземпляр не взял их, пока идет попытка. Если процесс упадет, доставка
+// вернется в очередь по истечении lease.
+func (wr *WebhookRepo) ClaimJobs(ctx context.Context, limit int, lease time.Duration) ([]webhook.Job, error) {
+	const fName = "ClaimJobs"
+	rows, err := wr.db.QueryContext(ctx,
+		`UPDATE webhook_jobs j SET next_attempt_at = now() + make_interval(secs => $2)
+		FROM webhooks w
+		WHERE w.id = j.webhook_id AND j.id IN (
+			SELECT jj.id FROM webhook_jobs jj JOIN webhooks ww ON ww.id = jj.webhook_id AND ww.enabled
+			WHERE jj.next_attempt_at <= now()
+			ORDER BY jj.next_attempt_at
+			LIMIT $1
+			FOR UPDATE OF jj SKIP LOCKED
+		)
+		RETURNING j.id, j.event_id, j.event_type, j.body, j.attempt,
+			w.id, w.project_id, w.url, w.event_types, w.secret, w.enabled, w.failures, w.created_at`,
+		limit, lease.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fName, err)
+	}
+	defer rows.Close()
+
+	var jobs []webhook.Job
+	for rows.Next() {
+		var (
+			job  webhook.Job
+			body string
+			wh   = &job.Webhook
+		)
+		err := rows.Scan(&job.ID, &job.EventId, &job.EventType, &body, &job.Attempt,
+			&wh.ID, &wh.ProjectId, &wh.URL, pq.Array(&wh.EventTypes), &wh.Secret, &wh.Enabled, &wh.Failures, &wh.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fName, err)
+		}
+		job.Body = []byte(body)
+		jobs = append(jobs, job)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", fName, err)
+	}
+
+	return jobs, nil
+}
+
+// RescheduleJob записывает число сделанных попыток и откладывает следующую на delay.
+func (wr *WebhookRepo) RescheduleJob(ctx context.Context, id int64, attempt int, delay time.Duration) error {
+	const fName = "RescheduleJob"
+	_, err := wr.db.ExecContext(ctx,
+		"UPDATE webhook_jobs SET attempt = $2, next_attempt_at = now() + make_interval(secs => $3) WHERE id = $1",
+		id, attempt, delay.Seconds())
+	if err != nil {
+		return fmt.Errorf("%s: %w", fName, err)
+	}
+
+	return nil
+}
+
+func (wr *WebhookRepo) DeleteJob(ctx context.Context, id int64) error {
+	const fName = "DeleteJob"
+	_, err := wr.db.ExecContext(ctx, "DELETE FROM webhook_jobs WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fName, err)
+	}
+
+	return nil
+}
+
+// RecordFailure увеличивает счетчик неудачных доставок подряд и выключает вебхук,
+// если счетчик достиг disableAfter.
+func (wr *WebhookRepo) RecordFailure(ctx context.Context, id, disableAfter int) (bool, error) {
+	const fName = "RecordFailure"
+	// вместе с выключением удаляем запланированные доставки, чтобы они не ушли после повторного включения
+	var enabled bool
+	err := wr.db.QueryRowContext(ctx,
+		`WITH updated AS (
+			UPDATE webhooks SET failures = failures + 1, enabled = enabled AND failures + 1 < $2 WHERE id = $1 RETURNING id, enabled
+		), dropped AS (
+			DELETE FROM webhook_jobs WHERE webhook_id IN (SELECT id FROM updated WHERE NOT enabled)
+		)
+		SELECT enabled FROM updated`,
+		id, disableAfter).Scan(&enabled)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, utils.ErrWebhookNotFound
+		}
+		return false, fmt.Errorf("%s: %w", fName, err)
+	}
+
+	return !enabled, nil
+}
+
+func (wr *WebhookRepo) ResetFailures(ctx context.Context, id int) error {
+	const fName = "ResetFailures"
+	_, err := wr.db.ExecContext(ctx, "UPDATE webhooks SET failures = 0 WHERE id = $1 AND failures != 0", id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fName, err)
+	}
+
+	return nil
+}
+
+func (wr *WebhookRepo) CreateDelivery(ctx context.Context, d webhook.Delivery) error {
+	const fName = "CreateDelivery"
+	_, err := wr.db.ExecContext(ctx,
+		"INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, attempt, status_code, error, success, duration_ms) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		d.WebhookId, d.EventId, d.EventType, d.Attempt, d.StatusCode, d.Error, d.Success, d.DurationMs)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fName, err)
+	}
+
+	return nil
+}
+
+func (wr *WebhookRepo) GetDeliveries(ctx context.Context, webhookId, limit int) ([]webhook.Delivery, error) {
+	const fName = "GetDeliveries"
+	rows, err := wr.db.QueryContext(ctx,
+		"SELECT id, webhook_id, event_id, event_type, attempt, status_code, error, success, duration_ms, created_at FROM webhook_deliveries WHERE webhook_id = $1 ORDER BY id DESC LIMIT $2",
+		webhookId, limit)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fName, err)
+	}
+	defer rows.Close()
+
+	var deliveries []webhook.Delivery
+	for rows.Next() {
+		var d webhook.Delivery
+		err := rows.Scan(&d.ID, &d.WebhookId, &d.EventId, &d.EventType, &d.Attempt, &d.StatusCode, &d.Error, &d.Success, &d.DurationMs, &d.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fName, err)
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", fName, err)
+	}
+
+	return deliveries, nil
+}
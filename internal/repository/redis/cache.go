@@ -0,0 +1,88 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/voikin/hezzl-test/internal/repository/local"
+)
+
+// Invalidator рассылает инвалидации локальных кэшей другим репликам
+type Invalidator interface {
+	Invalidate(keys, prefixes []string)
+}
+
+// layeredCache - локальный кэш инстанса перед общим Redis
+type layeredCache struct {
+	redis *redis.Client
+	local *local.Cache
+	bus   Invalidator
+}
+
+func (lc *layeredCache) get(ctx context.Context, key string) ([]byte, error) {
+	if val, ok := lc.local.Get(key); ok {
+		return val, nil
+	}
+
+	val, err := lc.redis.Get(ctx, key).Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	lc.local.Set(key, val)
+	return val, nil
+}
+
+func (lc *layeredCache) set(ctx context.Context, key string, data []byte) {
+	lc.redis.SetNX(ctx, key, data, _defaultExpiration)
+	lc.local.Set(key, data)
+}
+
+// invalidate удаляет ключи из Redis и локального кэша, а префиксы - только из локального,
+// после чего оповещает остальные реплики.
+func (lc *layeredCache) invalidate(ctx context.Context, keys, prefixes []string) {
+	lc.redis.Del(ctx, keys...)
+	lc.local.Delete(keys...)
+	lc.local.DeletePrefix(prefixes...)
+	lc.bus.Invalidate(keys, prefixes)
+}
+
+// version возвращает текущую версию пространства ключей; счетчика еще нет - версия 0.
+func (lc *layeredCache) version(ctx context.Context, namespace string) (int64, error) {
+	v, err := lc.redis.Get(ctx, namespace).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return v, err
+}
+
+// bump одной транзакцией увеличивает версии пространств. Ключи со старыми версиями
+// больше никто не читает, в Redis и локальных кэшах они доживают до истечения TTL.
+// Без INCR старые страницы читались бы до TTL, поэтому неудачная транзакция повторяется;
+// лишний INCR после ответа, потерянного в сети, безвреден.
+func (lc *layeredCache) bump(ctx context.Context, namespaces ...string) error {
+	const fName = "redis.bump"
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		pipe := lc.redis.TxPipeline()
+		for _, ns := range namespaces {
+			pipe.Incr(ctx, ns)
+		}
+		if _, err = pipe.Exec(ctx); err == nil {
+			return nil
+		}
+		if attempt == _bumpAttempts {
+			return fmt.Errorf("%s %v: %w", fName, namespaces, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s %v: %w", fName, namespaces, err)
+		case <-time.After(time.Duration(attempt) * _bumpBackoff):
+		}
+	}
+}
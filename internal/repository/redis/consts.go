@@ -4,4 +4,13 @@ import "time"
 
 const (
 	_defaultExpiration = time.Minute
+
+	// счетчики версий пространств ключей товаров: версия входит в ключ кэша,
+	// и INCR после изменения разом делает недоступными все ключи со старой версией
+	_goodsNamespace        = "ns:goods"
+	_goodsProjectNamespace = "ns:goods:%d"
+
+	// повторы INCR версий после записи в базу, пауза растет линейно
+	_bumpAttempts = 3
+	_bumpBackoff  = 50 * time.Millisecond
 )
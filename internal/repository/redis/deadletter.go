@@ -0,0 +1,127 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/voikin/hezzl-test/internal/domain/deadletter"
+	"github.com/voikin/hezzl-test/internal/utils"
+)
+
+const (
+	_fieldReason     = "reason"
+	_fieldError      = "error"
+	_fieldStreamSeq  = "stream_seq"
+	_fieldConsumer   = "consumer"
+	_fieldDeliveries = "deliveries"
+	_fieldFailedAt   = "failed_at"
+)
+
+// DeadLetterRepo хранит мертвые письма в отдельном стриме Redis (bus: redis).
+type DeadLetterRepo struct {
+	client *redis.Client
+	key    string
+}
+
+func NewDeadLetterRepo(client *redis.Client, key string) *DeadLetterRepo {
+	return &DeadLetterRepo{client: client, key: key}
+}
+
+func (dr *DeadLetterRepo) AddDeadLetter(ctx context.Context, dl deadletter.DeadLetter) error {
+	err := dr.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: dr.key,
+		Values: map[string]interface{}{
+			_fieldSubject:     dl.Subject,
+			_fieldContentType: dl.ContentType,
+			_fieldData:        dl.Data,
+			_fieldReason:      dl.Reason,
+			_fieldError:       dl.Error,
+			_fieldStreamSeq:   dl.StreamSeq,
+			_fieldConsumer:    dl.Consumer,
+			_fieldDeliveries:  dl.Deliveries,
+			_fieldFailedAt:    dl.FailedAt.Format(time.RFC3339Nano),
+		},
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("redis.AddDeadLetter XAdd: %w", err)
+	}
+	return nil
+}
+
+func (dr *DeadLetterRepo) GetDeadLetters(ctx context.Context, afterSeq uint64, limit int) ([]deadletter.DeadLetter, error) {
+	xmsgs, err := dr.client.XRangeN(ctx, dr.key, seqToId(afterSeq+1), "+", int64(limit)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis.GetDeadLetters XRangeN: %w", err)
+	}
+
+	dls := make([]deadletter.DeadLetter, 0, len(xmsgs))
+	for _, xmsg := range xmsgs {
+		dls = append(dls, toDeadLetter(xmsg))
+	}
+	return dls, nil
+}
+
+func (dr *DeadLetterRepo) GetDeadLetter(ctx context.Context, seq uint64) (deadletter.DeadLetter, error) {
+	id := seqToId(seq)
+	xmsgs, err := dr.client.XRange(ctx, dr.key, id, id).Result()
+	if err != nil {
+		return deadletter.DeadLetter{}, fmt.Errorf("redis.GetDeadLetter XRange: %w", err)
+	}
+	if len(xmsgs) == 0 {
+		return deadletter.DeadLetter{}, utils.ErrDeadLetterNotFound
+	}
+
+	return toDeadLetter(xmsgs[0]), nil
+}
+
+func (dr *DeadLetterRepo) DeleteDeadLetter(ctx context.Context, seq uint64) error {
+	n, err := dr.client.XDel(ctx, dr.key, seqToId(seq)).Result()
+	if err != nil {
+		return fmt.Errorf("redis.DeleteDeadLetter XDel: %w", err)
+	}
+	if n == 0 {
+		return utils.ErrDeadLetterNotFound
+	}
+	return nil
+}
+
+func (dr *DeadLetterRepo) PurgeDeadLetters(ctx context.Context) (uint64, error) {
+	n, err := dr.client.XLen(ctx, dr.key).Result()
+	if err != nil {
+		return 0, fmt.Errorf("redis.PurgeDeadLetters XLen: %w", err)
+	}
+
+	err = dr.client.Del(ctx, dr.key).Err()
+	if err != nil {
+		return 0, fmt.Errorf("redis.PurgeDeadLetters Del: %w", err)
+	}
+
+	return uint64(n), nil
+}
+
+func toDeadLetter(xmsg redis.XMessage) deadletter.DeadLetter {
+	field := func(name string) string {
+		val, _ := xmsg.Values[name].(string)
+		return val
+	}
+
+	streamSeq, _ := strconv.ParseUint(field(_fieldStreamSeq), 10, 64)
+	deliveries, _ := strconv.ParseUint(field(_fieldDeliveries), 10, 64)
+	failedAt, _ := time.Parse(time.RFC3339Nano, field(_fieldFailedAt))
+
+	return deadletter.DeadLetter{
+		Seq:         idToSeq(xmsg.ID),
+		Subject:     field(_fieldSubject),
+		StreamSeq:   streamSeq,
+		Consumer:    field(_fieldConsumer),
+		Reason:      field(_fieldReason),
+		Error:       field(_fieldError),
+		Deliveries:  deliveries,
+		FailedAt:    failedAt,
+		ContentType: field(_fieldContentType),
+		Data:        []byte(field(_fieldData)),
+	}
+}
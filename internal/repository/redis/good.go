@@ -4,9 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log"
 
 	"github.com/redis/go-redis/v9"
 	"github.com/voikin/hezzl-test/internal/domain/good"
+	"github.com/voikin/hezzl-test/internal/repository/local"
 )
 
 // продублировал для избежания цикличного импорта из repository
@@ -21,23 +23,36 @@ type GoodRepo interface {
 
 type RedisGoodRepo struct {
 	GoodRepo
-	cache *redis.Client
+	cache *layeredCache
 }
 
-func NewRedisGoodRepo(repo GoodRepo, client *redis.Client) *RedisGoodRepo {
+func NewRedisGoodRepo(repo GoodRepo, client *redis.Client, localCache *local.Cache, bus Invalidator) *RedisGoodRepo {
 	return &RedisGoodRepo{
 		GoodRepo: repo,
-		cache:    client,
+		cache:    &layeredCache{redis: client, local: localCache, bus: bus},
 	}
 }
 
 func (gr *RedisGoodRepo) CreateGood(ctx context.Context, name string, projectId int) (good.Good, error) {
-	return gr.GoodRepo.CreateGood(ctx, name, projectId)
+	it, err := gr.GoodRepo.CreateGood(ctx, name, projectId)
+	if err != nil {
+		return it, err
+	}
+
+	gr.invalidate(ctx, projectId)
+	return it, nil
 }
 
+// GetGoods кэширует страницы под версией общего пространства товаров: любое изменение
+// товара меняет версию, и все страницы перестают читаться одновременно.
 func (gr *RedisGoodRepo) GetGoods(ctx context.Context, limit, offset int) ([]good.Good, error) {
-	goodsKey := fmt.Sprintf("GetGoods-%d-%d", limit, offset)
-	val, err := gr.cache.Get(ctx, goodsKey).Bytes()
+	ver, err := gr.cache.version(ctx, _goodsNamespace)
+	if err != nil {
+		return gr.GoodRepo.GetGoods(ctx, limit, offset)
+	}
+
+	goodsKey := fmt.Sprintf("GetGoods-v%d-%d-%d", ver, limit, offset)
+	val, err := gr.cache.get(ctx, goodsKey)
 
 	if err != nil {
 		goodsList, err := gr.GoodRepo.GetGoods(ctx, limit, offset)
@@ -49,7 +64,7 @@ func (gr *RedisGoodRepo) GetGoods(ctx context.Context, limit, offset int) ([]goo
 		if err != nil {
 			return goodsList, nil
 		}
-		gr.cache.SetNX(ctx, goodsKey, data, _defaultExpiration)
+		gr.cache.set(ctx, goodsKey, data)
 		return goodsList, nil
 	}
 
@@ -58,13 +73,18 @@ func (gr *RedisGoodRepo) GetGoods(ctx context.Context, limit, offset int) ([]goo
 	if err != nil {
 		return nil, err
 	}
-	
+
 	return goodsList, err
 }
 
 func (gr *RedisGoodRepo) GetGood(ctx context.Context, id, projectId int) (good.Good, error) {
-	goodKey := fmt.Sprintf("GetGood-%d-%d", id, projectId)
-	val, err := gr.cache.Get(ctx, goodKey).Bytes()
+	ver, err := gr.cache.version(ctx, fmt.Sprintf(_goodsProjectNamespace, projectId))
+	if err != nil {
+		return gr.GoodRepo.GetGood(ctx, id, projectId)
+	}
+
+	goodKey := fmt.Sprintf("GetGood-v%d-%d-%d", ver, id, projectId)
+	val, err := gr.cache.get(ctx, goodKey)
 
 	if err != nil {
 		it, err := gr.GoodRepo.GetGood(ctx, id, projectId)
@@ -76,7 +96,7 @@ func (gr *RedisGoodRepo) GetGood(ctx context.Context, id, projectId int) (good.G
 		if err != nil {
 			return it, nil
 		}
-		gr.cache.SetNX(ctx, goodKey, data, _defaultExpiration)
+		gr.cache.set(ctx, goodKey, data)
 		return it, nil
 	}
 
@@ -89,13 +109,23 @@ func (gr *RedisGoodRepo) GetGood(ctx context.Context, id, projectId int) (good.G
 }
 
 func (gr *RedisGoodRepo) UpdateGood(ctx context.Context, name, description string, id, projectId int) (good.Good, error) {
-	gr.deleteKey(ctx, id, projectId)
-	return gr.GoodRepo.UpdateGood(ctx, name, description, id, projectId)
+	it, err := gr.GoodRepo.UpdateGood(ctx, name, description, id, projectId)
+	if err != nil {
+		return it, err
+	}
+
+	gr.invalidate(ctx, projectId)
+	return it, nil
 }
 
 func (gr *RedisGoodRepo) DeleteGood(ctx context.Context, id, projectId int) (good.Good, error) {
-	gr.deleteKey(ctx, id, projectId)
-	return gr.GoodRepo.DeleteGood(ctx, id, projectId)
+	it, err := gr.GoodRepo.DeleteGood(ctx, id, projectId)
+	if err != nil {
+		return it, err
+	}
+
+	gr.invalidate(ctx, projectId)
+	return it, nil
 }
 
 func (gr *RedisGoodRepo) UpdateGoodPriority(ctx context.Context, projectID, goodID, newPriority int) ([]good.Good, error) {
@@ -104,15 +134,17 @@ func (gr *RedisGoodRepo) UpdateGoodPriority(ctx context.Context, projectID, good
 		return nil, err
 	}
 
-	for _, good := range updatedGoods {
-		gr.deleteKey(ctx, good.ID, projectID)
-	}
-
+	gr.invalidate(ctx, projectID)
 	return updatedGoods, nil
 }
 
-func (gr *RedisGoodRepo) deleteKey(ctx context.Context, id, projectId int) {
-	goodKey := fmt.Sprintf("GetGood-%d-%d", id, projectId)
-	gr.cache.Del(ctx, goodKey)
-	gr.cache.Del(ctx, "GetGoods")
+// invalidate вызывается после записи в базу: новые версии видят все реплики сразу,
+// а чтение, начатое до записи, кладет результат под старую версию, которую уже никто не спросит.
+// Запись уже сделана, поэтому отмена запроса не должна прерывать INCR, а его ошибка не
+// возвращается вызывающему, только логируется: устаревшие страницы живут до TTL.
+func (gr *RedisGoodRepo) invalidate(ctx context.Context, projectId int) {
+	err := gr.cache.bump(context.WithoutCancel(ctx), _goodsNamespace, fmt.Sprintf(_goodsProjectNamespace, projectId))
+	if err != nil {
+		log.Printf("redis.RedisGoodRepo.invalidate: cached goods may be stale for up to %s: %v", _defaultExpiration, err)
+	}
 }
@@ -0,0 +1,261 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/voikin/hezzl-test/internal/domain/good"
+	"github.com/voikin/hezzl-test/internal/repository/local"
+	"github.com/voikin/hezzl-test/internal/utils"
+)
+
+// fakeGoodRepo - база товаров в памяти, общая для всех реплик.
+type fakeGoodRepo struct {
+	mu     sync.Mutex
+	goods  map[int]good.Good
+	nextId int
+}
+
+func newFakeGoodRepo() *fakeGoodRepo {
+	return &fakeGoodRepo{goods: make(map[int]good.Good), nextId: 1}
+}
+
+func (r *fakeGoodRepo) CreateGood(_ context.Context, name string, projectId int) (good.Good, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	g := good.Good{ID: r.nextId, ProjectId: projectId, Name: name, Priority: r.nextId}
+	r.goods[g.ID] = g
+	r.nextId++
+	return g, nil
+}
+
+func (r *fakeGoodRepo) UpdateGood(_ context.Context, name, description string, id, projectId int) (good.Good, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	g, ok := r.goods[id]
+	if !ok || g.ProjectId != projectId {
+		return good.Good{}, utils.ErrGoodNotFound
+	}
+	g.Name, g.Description = name, description
+	r.goods[id] = g
+	return g, nil
+}
+
+func (r *fakeGoodRepo) DeleteGood(_ context.Context, id, projectId int) (good.Good, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	g, ok := r.goods[id]
+	if !ok || g.ProjectId != projectId {
+		return good.Good{}, utils.ErrGoodNotFound
+	}
+	delete(r.goods, id)
+	g.Removed = true
+	return g, nil
+}
+
+func (r *fakeGoodRepo) GetGood(_ context.Context, id, projectId int) (good.Good, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	g, ok := r.goods[id]
+	if !ok || g.ProjectId != projectId {
+		return good.Good{}, utils.ErrGoodNotFound
+	}
+	return g, nil
+}
+
+func (r *fakeGoodRepo) GetGoods(_ context.Context, limit, offset int) ([]good.Good, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	all := make([]good.Good, 0, len(r.goods))
+	for _, g := range r.goods {
+		all = append(all, g)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].ID < all[j].ID })
+
+	page := make([]good.Good, 0, limit)
+	for i := offset; i < len(all) && len(page) < limit; i++ {
+		page = append(page, all[i])
+	}
+	return page, nil
+}
+
+func (r *fakeGoodRepo) UpdateGoodPriority(_ context.Context, projectID, goodID, newPriority int) ([]good.Good, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	g, ok := r.goods[goodID]
+	if !ok || g.ProjectId != projectID {
+		return nil, utils.ErrGoodNotFound
+	}
+	g.Priority = newPriority
+	r.goods[goodID] = g
+	return []good.Good{g}, nil
+}
+
+type noopInvalidator struct{}
+
+func (noopInvalidator) Invalidate(_, _ []string) {}
+
+const testPageSize = 2
+
+// cacheReplicas - база и две реплики API с общим Redis и своими локальными кэшами.
+// Рассылка инвалидаций отключена: свежесть должна обеспечиваться версиями.
+func cacheReplicas(t *testing.T) (*fakeGoodRepo, []*RedisGoodRepo) {
+	t.Helper()
+
+	client := newTestClient(t)
+	db := newFakeGoodRepo()
+
+	replicas := make([]*RedisGoodRepo, 2)
+	for i := range replicas {
+		replicas[i] = NewRedisGoodRepo(db, client, local.NewCache(time.Minute, 1000), noopInvalidator{})
+	}
+	return db, replicas
+}
+
+// assertFresh читает через реплику все страницы и все товары и сравнивает с базой;
+// повторное чтение берет значения из кэша, поэтому сравнение делается дважды.
+func assertFresh(t *testing.T, step string, db *fakeGoodRepo, replica *RedisGoodRepo, ids []int) {
+	t.Helper()
+	ctx := context.Background()
+
+	for pass := 0; pass < 2; pass++ {
+		for offset := 0; offset <= len(ids); offset += testPageSize {
+			want, _ := db.GetGoods(ctx, testPageSize, offset)
+			got, err := replica.GetGoods(ctx, testPageSize, offset)
+			if err != nil {
+				t.Fatalf("%s: GetGoods(%d): %v", step, offset, err)
+			}
+			if len(want) == 0 && len(got) == 0 {
+				continue
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("%s: page at %d = %+v, want %+v", step, offset, got, want)
+			}
+		}
+
+		for _, id := range ids {
+			want, wantErr := db.GetGood(ctx, id, 1)
+			got, err := replica.GetGood(ctx, id, 1)
+			if !errors.Is(err, wantErr) || !reflect.DeepEqual(got, want) {
+				t.Fatalf("%s: good %d = %+v, %v; want %+v, %v", step, id, got, err, want, wantErr)
+			}
+		}
+	}
+}
+
+// TestCacheNoStaleReads: чтение, запись через одну реплику, чтение через обе. После каждого
+// вида изменения обе реплики сразу видят новые страницы списка и новые товары.
+func TestCacheNoStaleReads(t *testing.T) {
+	ctx := context.Background()
+	db, replicas := cacheReplicas(t)
+	writer := replicas[0]
+
+	var ids []int
+	for i := 0; i < 5; i++ {
+		g, err := writer.CreateGood(ctx, "good", 1)
+		if err != nil {
+			t.Fatalf("CreateGood: %v", err)
+		}
+		ids = append(ids, g.ID)
+	}
+
+	writes := []struct {
+		name  string
+		write func() error
+	}{
+		{"create", func() error {
+			g, err := writer.CreateGood(ctx, "new", 1)
+			ids = append(ids, g.ID)
+			return err
+		}},
+		{"update", func() error {
+			_, err := writer.UpdateGood(ctx, "renamed", "desc", ids[1], 1)
+			return err
+		}},
+		{"reprioritize", func() error {
+			_, err := writer.UpdateGoodPriority(ctx, 1, ids[3], 100)
+			return err
+		}},
+		{"delete", func() error {
+			_, err := writer.DeleteGood(ctx, ids[0], 1)
+			return err
+		}},
+	}
+
+	for _, w := range writes {
+		for _, r := range replicas {
+			assertFresh(t, "before "+w.name, db, r, ids)
+		}
+		if err := w.write(); err != nil {
+			t.Fatalf("%s: %v", w.name, err)
+		}
+		for _, r := range replicas {
+			assertFresh(t, "after "+w.name, db, r, ids)
+		}
+	}
+}
+
+// failingPipelines отклоняет первые n транзакций.
+type failingPipelines struct {
+	mu sync.Mutex
+	n  int
+}
+
+func (h *failingPipelines) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (h *failingPipelines) ProcessHook(next redis.ProcessHook) redis.ProcessHook { return next }
+
+func (h *failingPipelines) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		h.mu.Lock()
+		fail := h.n > 0
+		h.n--
+		h.mu.Unlock()
+
+		if fail {
+			return errors.New("connection reset")
+		}
+		return next(ctx, cmds)
+	}
+}
+
+// TestBumpRetries: сбой INCR после записи повторяется, и версия все равно меняется.
+func TestBumpRetries(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+	client.AddHook(&failingPipelines{n: _bumpAttempts - 1})
+	cache := &layeredCache{redis: client, local: local.NewCache(time.Minute, 10), bus: noopInvalidator{}}
+
+	if err := cache.bump(ctx, _goodsNamespace); err != nil {
+		t.Fatalf("bump: %v", err)
+	}
+	if v, err := cache.version(ctx, _goodsNamespace); err != nil || v != 1 {
+		t.Fatalf("version = %d, %v; want 1", v, err)
+	}
+}
+
+// TestBumpReportsError: если Redis так и не ответил, bump возвращает ошибку, а не молчит.
+func TestBumpReportsError(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	cache := &layeredCache{redis: client, local: local.NewCache(time.Minute, 10), bus: noopInvalidator{}}
+
+	mr.SetError("LOADING Redis is loading the dataset in memory")
+	if err := cache.bump(context.Background(), _goodsNamespace); err == nil {
+		t.Fatal("bump succeeded while Redis is failing")
+	}
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/redis/go-redis/v9"
 	"github.com/voikin/hezzl-test/internal/domain/project"
+	"github.com/voikin/hezzl-test/internal/repository/local"
 )
 
 // продублировал для избежания цикличного импорта из repository
@@ -20,23 +21,29 @@ type ProjectRepo interface {
 
 type RedisProjectRepo struct {
 	ProjectRepo
-	redis *redis.Client
+	redis *layeredCache
 }
 
-func NewProjectRepo(repo ProjectRepo, client *redis.Client) *RedisProjectRepo {
+func NewProjectRepo(repo ProjectRepo, client *redis.Client, localCache *local.Cache, bus Invalidator) *RedisProjectRepo {
 	return &RedisProjectRepo{
 		ProjectRepo: repo,
-		redis:       client,
+		redis:       &layeredCache{redis: client, local: localCache, bus: bus},
 	}
 }
 
 func (pr *RedisProjectRepo) CreateProject(ctx context.Context, name string) (project.Project, error) {
-	return pr.ProjectRepo.CreateProject(ctx, name)
+	proj, err := pr.ProjectRepo.CreateProject(ctx, name)
+	if err != nil {
+		return proj, err
+	}
+
+	pr.redis.invalidate(ctx, []string{"GetProjects"}, nil)
+	return proj, nil
 }
 
 func (pr *RedisProjectRepo) GetProjects(ctx context.Context) ([]project.Project, error) {
 	redisKey := "GetProjects"
-	data, err := pr.redis.Get(ctx, redisKey).Bytes()
+	data, err := pr.redis.get(ctx, redisKey)
 
 	if err != nil {
 		projectList, err := pr.ProjectRepo.GetProjects(ctx)
@@ -49,7 +56,7 @@ func (pr *RedisProjectRepo) GetProjects(ctx context.Context) ([]project.Project,
 			return projectList, err
 		}
 
-		pr.redis.SetNX(ctx, redisKey, data, _defaultExpiration)
+		pr.redis.set(ctx, redisKey, data)
 		return projectList, nil
 	}
 
@@ -64,7 +71,7 @@ func (pr *RedisProjectRepo) GetProjects(ctx context.Context) ([]project.Project,
 
 func (pr *RedisProjectRepo) GetProject(ctx context.Context, id int) (project.Project, error) {
 	redisKey := fmt.Sprintf("GetProject-%d", id)
-	data, err := pr.redis.Get(ctx, redisKey).Bytes()
+	data, err := pr.redis.get(ctx, redisKey)
 
 	if err != nil {
 		proj, err := pr.ProjectRepo.GetProject(ctx, id)
@@ -78,7 +85,7 @@ func (pr *RedisProjectRepo) GetProject(ctx context.Context, id int) (project.Pro
 			return project.Project{}, err
 		}
 
-		pr.redis.SetNX(ctx, redisKey, data, _defaultExpiration)
+		pr.redis.set(ctx, redisKey, data)
 		return proj, nil
 	}
 
@@ -92,17 +99,26 @@ func (pr *RedisProjectRepo) GetProject(ctx context.Context, id int) (project.Pro
 }
 
 func (pr *RedisProjectRepo) UpdateProject(ctx context.Context, name string, id int) (project.Project, error) {
+	proj, err := pr.ProjectRepo.UpdateProject(ctx, name, id)
+	if err != nil {
+		return proj, err
+	}
+
 	pr.deleteKey(ctx, id)
-	return pr.ProjectRepo.UpdateProject(ctx, name, id)
+	return proj, nil
 }
 
 func (pr *RedisProjectRepo) DeleteProject(ctx context.Context, id int) (project.Project, error) {
+	proj, err := pr.ProjectRepo.DeleteProject(ctx, id)
+	if err != nil {
+		return proj, err
+	}
+
 	pr.deleteKey(ctx, id)
-	return pr.ProjectRepo.DeleteProject(ctx, id)
+	return proj, nil
 }
 
 func (pr *RedisProjectRepo) deleteKey(ctx context.Context, id int) {
 	redisKey := fmt.Sprintf("GetProject-%d", id)
-	pr.redis.Del(ctx, redisKey)
-	pr.redis.Del(ctx, "GetProjects")
+	pr.redis.invalidate(ctx, []string{redisKey, "GetProjects"}, nil)
 }
@@ -0,0 +1,566 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/voikin/hezzl-test/config"
+	"github.com/voikin/hezzl-test/internal/domain/deadletter"
+	"github.com/voikin/hezzl-test/internal/domain/event"
+	"github.com/voikin/hezzl-test/internal/repository/bus"
+)
+
+const (
+	_fieldSubject     = "subject"
+	_fieldMsgId       = "msg_id"
+	_fieldContentType = "content_type"
+	_fieldData        = "data"
+	msgIdHeader       = "Nats-Msg-Id"
+
+	_dedupWindow   = 2 * time.Minute
+	_publishWait   = 5 * time.Second
+	_followBlock   = 5 * time.Second
+	_followBatch   = 100
+	_followBackoff = time.Second
+	// за один проход обрезки смотрим не больше стольких лишних записей
+	_trimBatch = 10000
+
+	// младшие биты Seq отдаются под порядковый номер внутри миллисекунды id записи
+	_seqBits = 20
+	_seqMask = 1<<_seqBits - 1
+)
+
+// StreamBus - транспорт событий поверх Redis Streams: XADD при публикации,
+// consumer group с XREADGROUP/XACK для durable-консьюмеров и XAUTOCLAIM
+// для сообщений, которые взял и не подтвердил упавший консьюмер.
+// Событие, которое не удалось добавить и после повторов, уходит в спул на диске.
+type StreamBus struct {
+	client       *redis.Client
+	key          string
+	maxLen       int64
+	consumer     string
+	deadLetters  *DeadLetterRepo
+	maxRetries   int
+	retryBackoff time.Duration
+	spool        *bus.Spool
+
+	// spooled - в спуле могут быть записи; drainMu держит разбор спула, Flush его дожидается
+	spooled atomic.Bool
+	drainMu sync.Mutex
+	stop    chan struct{}
+	once    sync.Once
+}
+
+func NewStreamBus(client *redis.Client, cfg config.RedisStream) (*StreamBus, error) {
+	host, _ := os.Hostname()
+
+	spool, err := bus.NewSpool(cfg.SpoolDir)
+	if err != nil {
+		return nil, fmt.Errorf("redis.NewStreamBus: %w", err)
+	}
+
+	sb := &StreamBus{
+		client:       client,
+		key:          cfg.Key,
+		maxLen:       cfg.MaxLen,
+		consumer:     fmt.Sprintf("%s-%d", host, os.Getpid()),
+		deadLetters:  NewDeadLetterRepo(client, cfg.DeadLetterKey),
+		maxRetries:   cfg.MaxRetries,
+		retryBackoff: cfg.RetryBackoff,
+		spool:        spool,
+		stop:         make(chan struct{}),
+	}
+	// спул мог остаться от прошлого запуска
+	sb.spooled.Store(true)
+	if cfg.MaintainInterval > 0 {
+		go sb.maintain(cfg.MaintainInterval)
+	}
+
+	return sb, nil
+}
+
+// Close останавливает фоновый разбор спула и обрезку стрима.
+func (sb *StreamBus) Close() {
+	sb.once.Do(func() { close(sb.stop) })
+}
+
+// _addScript атомарно проверяет окно дедупликации и добавляет запись: ключ дедупликации
+// не может остаться без записи в стриме, и повтор после сбоя не будет отброшен.
+// KEYS[1] - стрим, KEYS[2] - ключ дедупликации; ARGV: msgId, окно в мс, subject, content type, data.
+var _addScript = redis.NewScript(`
+if ARGV[1] ~= '' and not redis.call('SET', KEYS[2], '1', 'NX', 'PX', ARGV[2]) then
+	return 0
+end
+redis.call('XADD', KEYS[1], '*', '` + _fieldSubject + `', ARGV[3], '` + _fieldMsgId + `', ARGV[1],
+	'` + _fieldContentType + `', ARGV[4], '` + _fieldData + `', ARGV[5])
+return 1
+`)
+
+// Publish добавляет событие в стрим. Повтор с тем же msgId в окне дедупликации отбрасывается,
+// как это делает JetStream по заголовку Nats-Msg-Id. Если Redis недоступен, событие уходит в спул.
+func (sb *StreamBus) Publish(subject, msgId, contentType string, data []byte) {
+	const fName = "redis.StreamBus.Publish"
+	rec := bus.SpoolRecord{Subject: subject, MsgId: msgId, ContentType: contentType, Data: data}
+
+	if err := sb.publishWithRetry(rec); err != nil {
+		log.Printf("%s %s %s: %v, spooling", fName, subject, msgId, err)
+		if err := sb.spool.Append(rec); err != nil {
+			log.Printf("%s %s %s: %v, event lost", fName, subject, msgId, err)
+			return
+		}
+		sb.spooled.Store(true)
+		return
+	}
+
+	if sb.spooled.Load() {
+		sb.drainSpool()
+	}
+}
+
+// Flush дожидается начатого разбора спула.
+func (sb *StreamBus) Flush() {
+	sb.drainMu.Lock()
+	sb.drainMu.Unlock()
+}
+
+func (sb *StreamBus) publishWithRetry(rec bus.SpoolRecord) error {
+	backoff := sb.retryBackoff
+	var err error
+
+	for attempt := 0; attempt <= sb.maxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		if err = sb.publishOnce(rec); err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+func (sb *StreamBus) publishOnce(rec bus.SpoolRecord) error {
+	ctx, cancel := context.WithTimeout(context.Background(), _publishWait)
+	defer cancel()
+
+	return _addScript.Run(ctx, sb.client,
+		[]string{sb.key, sb.key + ":dedup:" + rec.MsgId},
+		rec.MsgId, _dedupWindow.Milliseconds(), rec.Subject, rec.ContentType, rec.Data,
+	).Err()
+}
+
+// drainSpool отправляет накопленные в спуле события в фоне; параллельно идет не больше одного разбора.
+func (sb *StreamBus) drainSpool() {
+	if !sb.drainMu.TryLock() {
+		return
+	}
+	sb.spooled.Store(false)
+
+	go func() {
+		defer sb.drainMu.Unlock()
+
+		if err := sb.spool.Drain(sb.publishWithRetry); err != nil {
+			// неотправленные записи вернулись в спул
+			sb.spooled.Store(true)
+			log.Printf("redis stream spool drain: %v", err)
+		}
+	}()
+}
+
+// maintain периодически разбирает спул и обрезает стрим.
+func (sb *StreamBus) maintain(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-sb.stop:
+			return
+		case <-ticker.C:
+			if sb.spooled.Load() {
+				sb.drainSpool()
+			}
+			if err := sb.trim(context.Background()); err != nil {
+				log.Printf("redis stream trim: %v", err)
+			}
+		}
+	}
+}
+
+// trim удаляет самые старые записи сверх MaxLen, но не дальше первой записи, которую какая-либо
+// consumer group еще не прочитала или не подтвердила. Пока групп нет, записи не удаляются:
+// группа, созданная позже, читает стрим с начала.
+func (sb *StreamBus) trim(ctx context.Context) error {
+	length, err := sb.client.XLen(ctx, sb.key).Result()
+	if err != nil {
+		return fmt.Errorf("XLen: %w", err)
+	}
+	excess := length - sb.maxLen
+	if excess <= 0 {
+		return nil
+	}
+	if excess > _trimBatch {
+		excess = _trimBatch
+	}
+
+	// MINID удаляет записи строго меньше порога: порог - последняя из лишних записей
+	oldest, err := sb.client.XRangeN(ctx, sb.key, "-", "+", excess).Result()
+	if err != nil {
+		return fmt.Errorf("XRange: %w", err)
+	}
+	if len(oldest) == 0 {
+		return nil
+	}
+	minId := oldest[len(oldest)-1].ID
+
+	groups, err := sb.client.XInfoGroups(ctx, sb.key).Result()
+	if err != nil {
+		return fmt.Errorf("XInfoGroups: %w", err)
+	}
+	if len(groups) == 0 {
+		return nil
+	}
+
+	for _, g := range groups {
+		// группа прочитала все до LastDeliveredID включительно
+		msPart, nPart, _ := strings.Cut(g.LastDeliveredID, "-")
+		n, _ := strconv.ParseUint(nPart, 10, 64)
+		floor := fmt.Sprintf("%s-%d", msPart, n+1)
+		if g.Pending > 0 {
+			pending, err := sb.client.XPending(ctx, sb.key, g.Name).Result()
+			if err != nil {
+				return fmt.Errorf("XPending %s: %w", g.Name, err)
+			}
+			floor = pending.Lower
+		}
+		if idToSeq(floor) < idToSeq(minId) {
+			minId = floor
+		}
+	}
+
+	return sb.client.XTrimMinIDApprox(ctx, sb.key, minId, 0).Err()
+}
+
+// Consume создает consumer group с именем cfg.Durable, если ее еще нет.
+// Новая группа читает стрим с начала.
+func (sb *StreamBus) Consume(cfg config.Consumer) (bus.Consumer, error) {
+	const fName = "redis.StreamBus.Consume"
+
+	err := sb.client.XGroupCreateMkStream(context.Background(), sb.key, cfg.Durable, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil, fmt.Errorf("%s XGroupCreateMkStream: %w", fName, err)
+	}
+
+	return &streamConsumer{bus: sb, cfg: cfg, claimStart: "0-0"}, nil
+}
+
+// Follow читает стрим без consumer group, начиная с startSeq (0 - только новые).
+func (sb *StreamBus) Follow(subject string, startSeq uint64, handler func(bus.Message)) (func() error, error) {
+	lastId := "$"
+	if startSeq > 0 {
+		lastId = idBefore(startSeq)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		for ctx.Err() == nil {
+			streams, err := sb.client.XRead(ctx, &redis.XReadArgs{
+				Streams: []string{sb.key, lastId},
+				Count:   _followBatch,
+				Block:   _followBlock,
+			}).Result()
+			if err != nil {
+				if !errors.Is(err, redis.Nil) && ctx.Err() == nil {
+					log.Printf("redis.StreamBus.Follow XRead: %v", err)
+					time.Sleep(_followBackoff)
+				}
+				continue
+			}
+
+			for _, stream := range streams {
+				for _, xmsg := range stream.Messages {
+					lastId = xmsg.ID
+					msg := newStreamMessage(xmsg, nil, 1)
+					if bus.MatchSubject(subject, msg.subject) {
+						handler(msg)
+					}
+				}
+			}
+		}
+	}()
+
+	return func() error {
+		cancel()
+		return nil
+	}, nil
+}
+
+type streamConsumer struct {
+	bus        *StreamBus
+	cfg        config.Consumer
+	claimStart string
+}
+
+// Fetch сначала забирает зависшие у других консьюмеров сообщения (простаивают дольше AckWait),
+// потом дочитывает новые.
+func (c *streamConsumer) Fetch(ctx context.Context, batch int, maxWait time.Duration) ([]bus.Message, error) {
+	const fName = "redis.streamConsumer.Fetch"
+
+	out, err := c.reclaim(ctx, batch)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fName, err)
+	}
+
+	if len(out) < batch {
+		block := maxWait
+		if len(out) != 0 {
+			block = -1
+		}
+
+		streams, err := c.bus.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    c.cfg.Durable,
+			Consumer: c.bus.consumer,
+			Streams:  []string{c.bus.key, ">"},
+			Count:    int64(batch - len(out)),
+			Block:    block,
+		}).Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("%s XReadGroup: %w", fName, err)
+		}
+
+		for _, stream := range streams {
+			for _, xmsg := range stream.Messages {
+				out = c.accept(ctx, out, newStreamMessage(xmsg, c, 1))
+			}
+		}
+	}
+
+	return out, nil
+}
+
+func (c *streamConsumer) reclaim(ctx context.Context, batch int) ([]bus.Message, error) {
+	xmsgs, next, err := c.bus.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream:   c.bus.key,
+		Group:    c.cfg.Durable,
+		Consumer: c.bus.consumer,
+		MinIdle:  c.cfg.AckWait,
+		Start:    c.claimStart,
+		Count:    int64(batch),
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("XAutoClaim: %w", err)
+	}
+	c.claimStart = next
+
+	if len(xmsgs) == 0 {
+		return nil, nil
+	}
+
+	deliveries, err := c.deliveries(ctx, xmsgs)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]bus.Message, 0, len(xmsgs))
+	for _, xmsg := range xmsgs {
+		n, ok := deliveries[xmsg.ID]
+		if !ok {
+			// между XAUTOCLAIM и XPENDING сообщение подтвердил другой читатель
+			continue
+		}
+		msg := newStreamMessage(xmsg, c, n)
+		// как и JetStream, после MaxDeliver попыток сообщение больше не доставляем,
+		// но в отличие от него не теряем: оно уходит в мертвые письма
+		if c.cfg.MaxDeliver > 0 && msg.deliveries > uint64(c.cfg.MaxDeliver) {
+			c.deadLetter(ctx, msg)
+			continue
+		}
+		out = c.accept(ctx, out, msg)
+	}
+
+	return out, nil
+}
+
+// deliveries возвращает счетчики доставок забранных сообщений по id: XAUTOCLAIM их не возвращает.
+// XPENDING спрашивается по каждому id отдельно (одним пайплайном), чтобы другие записи
+// группы в том же диапазоне не вытеснили нужные. Уже подтвержденных сообщений в ответе нет.
+func (c *streamConsumer) deliveries(ctx context.Context, xmsgs []redis.XMessage) (map[string]uint64, error) {
+	pipe := c.bus.client.Pipeline()
+	cmds := make([]*redis.XPendingExtCmd, len(xmsgs))
+	for i, xmsg := range xmsgs {
+		cmds[i] = pipe.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream: c.bus.key,
+			Group:  c.cfg.Durable,
+			Start:  xmsg.ID,
+			End:    xmsg.ID,
+			Count:  1,
+		})
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("XPendingExt: %w", err)
+	}
+
+	deliveries := make(map[string]uint64, len(xmsgs))
+	for _, cmd := range cmds {
+		for _, p := range cmd.Val() {
+			deliveries[p.ID] = uint64(p.RetryCount)
+		}
+	}
+	return deliveries, nil
+}
+
+// deadLetter переносит сообщение, так и не подтвержденное за MaxDeliver доставок, в мертвые письма
+// и только потом подтверждает. Если письмо сохранить не удалось, сообщение остается в pending
+// и вернется сюда при следующем XAUTOCLAIM.
+func (c *streamConsumer) deadLetter(ctx context.Context, msg *streamMessage) {
+	err := c.bus.deadLetters.AddDeadLetter(ctx, deadletter.DeadLetter{
+		Subject:     msg.subject,
+		StreamSeq:   msg.Seq(),
+		Consumer:    c.cfg.Durable,
+		Reason:      deadletter.ReasonMaxDeliver,
+		Error:       fmt.Sprintf("not acknowledged after %d deliveries", c.cfg.MaxDeliver),
+		Deliveries:  uint64(c.cfg.MaxDeliver),
+		FailedAt:    time.Now().UTC(),
+		ContentType: msg.contentType,
+		Data:        msg.data,
+	})
+	if err != nil {
+		log.Printf("redis stream: message %s exceeded %d deliveries: %v", msg.id, c.cfg.MaxDeliver, err)
+		return
+	}
+
+	log.Printf("redis stream: message %s moved to dead letters after %d deliveries", msg.id, c.cfg.MaxDeliver)
+	_ = msg.Ack()
+}
+
+// accept подтверждает сразу сообщения, не подходящие под FilterSubject, и записи,
+// удаленные из стрима по MaxLen, остальные добавляет в out.
+func (c *streamConsumer) accept(ctx context.Context, out []bus.Message, msg *streamMessage) []bus.Message {
+	if msg.subject == "" || !bus.MatchSubject(c.cfg.FilterSubject, msg.subject) {
+		c.bus.client.XAck(ctx, c.bus.key, c.cfg.Durable, msg.id)
+		return out
+	}
+	return append(out, msg)
+}
+
+func (c *streamConsumer) Close() error {
+	return nil
+}
+
+// claim переставляет время простоя сообщения, чтобы XAUTOCLAIM забрал его через after.
+func (c *streamConsumer) claim(id string, after time.Duration) error {
+	idle := c.cfg.AckWait - after
+	if idle < 0 {
+		idle = 0
+	}
+
+	return c.bus.client.Do(context.Background(), "XCLAIM", c.bus.key, c.cfg.Durable, c.bus.consumer,
+		0, id, "IDLE", idle.Milliseconds(), "JUSTID").Err()
+}
+
+type streamMessage struct {
+	consumer    *streamConsumer
+	id          string
+	subject     string
+	msgId       string
+	contentType string
+	data        []byte
+	deliveries  uint64
+}
+
+func newStreamMessage(xmsg redis.XMessage, c *streamConsumer, deliveries uint64) *streamMessage {
+	msg := &streamMessage{consumer: c, id: xmsg.ID, deliveries: deliveries}
+	msg.subject, _ = xmsg.Values[_fieldSubject].(string)
+	msg.msgId, _ = xmsg.Values[_fieldMsgId].(string)
+	msg.contentType, _ = xmsg.Values[_fieldContentType].(string)
+	if data, ok := xmsg.Values[_fieldData].(string); ok {
+		msg.data = []byte(data)
+	}
+	return msg
+}
+
+func (m *streamMessage) Subject() string { return m.subject }
+func (m *streamMessage) Data() []byte    { return m.data }
+func (m *streamMessage) Seq() uint64     { return idToSeq(m.id) }
+
+func (m *streamMessage) Header(key string) string {
+	switch key {
+	case msgIdHeader:
+		return m.msgId
+	case event.ContentTypeHeader:
+		return m.contentType
+	}
+	return ""
+}
+
+func (m *streamMessage) Timestamp() time.Time {
+	return time.UnixMilli(int64(idToSeq(m.id) >> _seqBits))
+}
+
+func (m *streamMessage) NumDelivered() uint64 { return m.deliveries }
+
+func (m *streamMessage) Ack() error {
+	if m.consumer == nil {
+		return nil
+	}
+	return m.consumer.bus.client.XAck(context.Background(), m.consumer.bus.key, m.consumer.cfg.Durable, m.id).Err()
+}
+
+// Nak оставляет сообщение в pending: его заберет XAUTOCLAIM через delay.
+func (m *streamMessage) Nak(delay time.Duration) error {
+	if m.consumer == nil {
+		return nil
+	}
+	return m.consumer.claim(m.id, delay)
+}
+
+func (m *streamMessage) Term() error {
+	return m.Ack()
+}
+
+func (m *streamMessage) InProgress() error {
+	if m.consumer == nil {
+		return nil
+	}
+	return m.consumer.claim(m.id, m.consumer.cfg.AckWait)
+}
+
+// idToSeq упаковывает id записи "<ms>-<n>" в одно число, сохраняя порядок.
+func idToSeq(id string) uint64 {
+	msPart, nPart, _ := strings.Cut(id, "-")
+	ms, _ := strconv.ParseUint(msPart, 10, 64)
+	n, _ := strconv.ParseUint(nPart, 10, 64)
+	return ms<<_seqBits | n&_seqMask
+}
+
+// idBefore возвращает id, непосредственно предшествующий seq: XREAD читает строго после него.
+func idBefore(seq uint64) string {
+	ms, n := seq>>_seqBits, seq&_seqMask
+	if n > 0 {
+		return fmt.Sprintf("%d-%d", ms, n-1)
+	}
+	if ms == 0 {
+		return "0-0"
+	}
+	return fmt.Sprintf("%d-%d", ms-1, uint64(_seqMask))
+}
+
+// seqToId - обратное к idToSeq преобразование.
+func seqToId(seq uint64) string {
+	return fmt.Sprintf("%d-%d", seq>>_seqBits, seq&_seqMask)
+}
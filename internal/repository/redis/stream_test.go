@@ -0,0 +1,245 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/voikin/hezzl-test/config"
+	"github.com/voikin/hezzl-test/internal/domain/deadletter"
+	"github.com/voikin/hezzl-test/internal/domain/event"
+)
+
+func newTestClient(t *testing.T) *redis.Client {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func newTestStreamBus(t *testing.T, client *redis.Client, cfg config.RedisStream) *StreamBus {
+	t.Helper()
+
+	if cfg.SpoolDir == "" {
+		cfg.SpoolDir = t.TempDir()
+	}
+	sb, err := NewStreamBus(client, cfg)
+	if err != nil {
+		t.Fatalf("NewStreamBus: %v", err)
+	}
+	t.Cleanup(sb.Close)
+	return sb
+}
+
+// TestReclaimDeadLettersAfterMaxDeliver: консьюмер берет сообщение и падает, не подтвердив его.
+// После MaxDeliver доставок сообщение должно оказаться в мертвых письмах, а не пропасть.
+func TestReclaimDeadLettersAfterMaxDeliver(t *testing.T) {
+	const maxDeliver = 2
+
+	client := newTestClient(t)
+	cfg := config.RedisStream{Key: "events", MaxLen: 1000, DeadLetterKey: "events:deadletter"}
+	sb := newTestStreamBus(t, client, cfg)
+	ctx := context.Background()
+
+	sb.Publish(event.GoodsSubject(1), "e1", event.ContentTypeJSON, []byte(`{"EventId":"e1"}`))
+
+	consumerCfg := config.Consumer{Durable: "worker", FilterSubject: "events.>", AckWait: 20 * time.Millisecond, MaxDeliver: maxDeliver}
+	consumer, err := sb.Consume(consumerCfg)
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+
+	for delivery := 1; delivery <= maxDeliver; delivery++ {
+		msgs, err := consumer.Fetch(ctx, 10, 100*time.Millisecond)
+		if err != nil {
+			t.Fatalf("Fetch: %v", err)
+		}
+		if len(msgs) != 1 || msgs[0].NumDelivered() != uint64(delivery) {
+			t.Fatalf("delivery %d: got %d messages", delivery, len(msgs))
+		}
+		// не подтверждаем и ждем, пока сообщение простоит дольше AckWait
+		time.Sleep(2 * consumerCfg.AckWait)
+	}
+
+	msgs, err := consumer.Fetch(ctx, 10, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if len(msgs) != 0 {
+		t.Fatalf("message delivered %d times, MaxDeliver is %d", msgs[0].NumDelivered(), maxDeliver)
+	}
+
+	pending, err := client.XPending(ctx, cfg.Key, consumerCfg.Durable).Result()
+	if err != nil {
+		t.Fatalf("XPending: %v", err)
+	}
+	if pending.Count != 0 {
+		t.Fatalf("%d messages are still pending", pending.Count)
+	}
+
+	dls, err := NewDeadLetterRepo(client, cfg.DeadLetterKey).GetDeadLetters(ctx, 0, 10)
+	if err != nil {
+		t.Fatalf("GetDeadLetters: %v", err)
+	}
+	if len(dls) != 1 {
+		t.Fatalf("got %d dead letters, want 1", len(dls))
+	}
+	dl := dls[0]
+	if dl.Reason != deadletter.ReasonMaxDeliver || dl.Consumer != "worker" || dl.Subject != event.GoodsSubject(1) || string(dl.Data) != `{"EventId":"e1"}` {
+		t.Fatalf("dead letter = %+v", dl)
+	}
+}
+
+func testStreamConfig(t *testing.T) config.RedisStream {
+	return config.RedisStream{
+		Key:           "events",
+		MaxLen:        1000,
+		DeadLetterKey: "events:deadletter",
+		MaxRetries:    2,
+		RetryBackoff:  time.Millisecond,
+		SpoolDir:      t.TempDir(),
+	}
+}
+
+// TestPublishSpoolsWhenRedisFails: событие, которое Redis не принял и после повторов,
+// ложится в спул и попадает в стрим после следующей успешной публикации.
+func TestPublishSpoolsWhenRedisFails(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	cfg := testStreamConfig(t)
+	sb := newTestStreamBus(t, client, cfg)
+	ctx := context.Background()
+
+	mr.SetError("LOADING Redis is loading the dataset in memory")
+	sb.Publish(event.GoodsSubject(1), "e1", event.ContentTypeJSON, []byte("1"))
+	mr.SetError("")
+
+	if n, _ := client.XLen(ctx, cfg.Key).Result(); n != 0 {
+		t.Fatalf("stream has %d entries while Redis was failing", n)
+	}
+	if entries, err := os.ReadDir(cfg.SpoolDir); err != nil || len(entries) == 0 {
+		t.Fatalf("event was not spooled: %v", err)
+	}
+
+	sb.Publish(event.GoodsSubject(1), "e2", event.ContentTypeJSON, []byte("2"))
+	// повтор уже записанного события отбрасывается дедупликацией
+	sb.Publish(event.GoodsSubject(1), "e2", event.ContentTypeJSON, []byte("2"))
+	sb.Flush()
+
+	entries, err := client.XRange(ctx, cfg.Key, "-", "+").Result()
+	if err != nil {
+		t.Fatalf("XRange: %v", err)
+	}
+	var ids []string
+	for _, e := range entries {
+		ids = append(ids, e.Values[_fieldMsgId].(string))
+	}
+	if len(ids) != 2 || ids[0] == ids[1] {
+		t.Fatalf("stream has %v, want e1 and e2 once", ids)
+	}
+}
+
+// TestTrimKeepsUnacknowledged: записи сверх MaxLen удаляются только до первой
+// непрочитанной или неподтвержденной записи группы.
+func TestTrimKeepsUnacknowledged(t *testing.T) {
+	client := newTestClient(t)
+	cfg := testStreamConfig(t)
+	cfg.MaxLen = 2
+	sb := newTestStreamBus(t, client, cfg)
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		sb.Publish(event.GoodsSubject(1), fmt.Sprintf("e%d", i), event.ContentTypeJSON, []byte("x"))
+	}
+
+	// групп еще нет: удалять нельзя, группа прочитает стрим с начала
+	if err := sb.trim(ctx); err != nil {
+		t.Fatalf("trim: %v", err)
+	}
+	assertStreamLen(t, client, cfg.Key, 5)
+
+	consumer, err := sb.Consume(config.Consumer{Durable: "worker", FilterSubject: "events.>", AckWait: time.Minute})
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	msgs, err := consumer.Fetch(ctx, 2, 50*time.Millisecond)
+	if err != nil || len(msgs) != 2 {
+		t.Fatalf("Fetch = %d, %v", len(msgs), err)
+	}
+
+	// первые две записи выданы, но не подтверждены
+	if err := sb.trim(ctx); err != nil {
+		t.Fatalf("trim: %v", err)
+	}
+	assertStreamLen(t, client, cfg.Key, 5)
+
+	for _, msg := range msgs {
+		_ = msg.Ack()
+	}
+	// e0 и e1 подтверждены, e2 группа еще не читала: из трех лишних записей удаляются две
+	if err := sb.trim(ctx); err != nil {
+		t.Fatalf("trim: %v", err)
+	}
+	assertStreamLen(t, client, cfg.Key, 3)
+}
+
+func assertStreamLen(t *testing.T, client *redis.Client, key string, want int64) {
+	t.Helper()
+
+	n, err := client.XLen(context.Background(), key).Result()
+	if err != nil || n != want {
+		t.Fatalf("XLen = %d, %v; want %d", n, err, want)
+	}
+}
+
+// TestReclaimCountsEachMessage: счетчик доставок берется для каждого забранного сообщения,
+// даже если между ними в pending лежат другие, еще не простаивающие записи.
+func TestReclaimCountsEachMessage(t *testing.T) {
+	client := newTestClient(t)
+	cfg := testStreamConfig(t)
+	sb := newTestStreamBus(t, client, cfg)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		sb.Publish(event.GoodsSubject(1), fmt.Sprintf("e%d", i), event.ContentTypeJSON, []byte("x"))
+	}
+
+	consumerCfg := config.Consumer{Durable: "worker", FilterSubject: "events.>", AckWait: 20 * time.Millisecond, MaxDeliver: 1}
+	consumer, err := sb.Consume(consumerCfg)
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	msgs, err := consumer.Fetch(ctx, 3, 50*time.Millisecond)
+	if err != nil || len(msgs) != 3 {
+		t.Fatalf("Fetch = %d, %v", len(msgs), err)
+	}
+
+	time.Sleep(2 * consumerCfg.AckWait)
+	// средняя запись продлена и не простаивает: XAUTOCLAIM заберет только первую и третью
+	if err := msgs[1].InProgress(); err != nil {
+		t.Fatalf("InProgress: %v", err)
+	}
+
+	redelivered, err := consumer.Fetch(ctx, 3, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if len(redelivered) != 0 {
+		t.Fatalf("redelivered %d messages past MaxDeliver", len(redelivered))
+	}
+
+	dls, err := sb.deadLetters.GetDeadLetters(ctx, 0, 10)
+	if err != nil {
+		t.Fatalf("GetDeadLetters: %v", err)
+	}
+	if len(dls) != 2 {
+		t.Fatalf("got %d dead letters, want 2", len(dls))
+	}
+}
@@ -3,14 +3,21 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"time"
 
 	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
-	"github.com/nats-io/nats.go"
 	"github.com/redis/go-redis/v9"
+	"github.com/voikin/hezzl-test/config"
+	"github.com/voikin/hezzl-test/internal/domain/analytics"
+	"github.com/voikin/hezzl-test/internal/domain/audit"
+	"github.com/voikin/hezzl-test/internal/domain/deadletter"
 	"github.com/voikin/hezzl-test/internal/domain/event"
 	"github.com/voikin/hezzl-test/internal/domain/good"
 	"github.com/voikin/hezzl-test/internal/domain/project"
+	"github.com/voikin/hezzl-test/internal/domain/webhook"
+	"github.com/voikin/hezzl-test/internal/repository/bus"
 	"github.com/voikin/hezzl-test/internal/repository/clickhouse"
+	"github.com/voikin/hezzl-test/internal/repository/local"
 	natsRepo "github.com/voikin/hezzl-test/internal/repository/nats"
 	"github.com/voikin/hezzl-test/internal/repository/postgres"
 	redisRepo "github.com/voikin/hezzl-test/internal/repository/redis"
@@ -35,27 +42,95 @@ type GoodRepo interface {
 
 type EventRepo interface {
 	CreateEvent(ctx context.Context, event []event.ClickhouseEvent) error
+	GetGoodEvents(ctx context.Context, projectId, id int) ([]event.ClickhouseEvent, error)
+	GetGoodStates(ctx context.Context, projectId int) ([]event.ClickhouseEvent, error)
+}
+
+type AnalyticsRepo interface {
+	GetChanges(ctx context.Context, f analytics.Filter, granularity string) ([]analytics.ChangesBucket, error)
+	GetMostEdited(ctx context.Context, f analytics.Filter, limit int) ([]analytics.GoodEdits, error)
+	GetRemovalRate(ctx context.Context, f analytics.Filter) ([]analytics.RemovalRate, error)
+	GetReprioritizationChurn(ctx context.Context, f analytics.Filter) ([]analytics.ReprioritizationChurn, error)
+	GetActiveProjects(ctx context.Context, f analytics.Filter, limit int) ([]analytics.ActiveProject, error)
+}
+
+type AuditRepo interface {
+	GetAuditEvents(ctx context.Context, f audit.Filter, cursor *audit.Cursor, limit int) ([]event.ClickhouseEvent, error)
+	GetPreviousStates(ctx context.Context, events []event.ClickhouseEvent) (map[string]event.ClickhouseEvent, error)
+}
+
+type WebhookRepo interface {
+	CreateWebhook(ctx context.Context, wh webhook.Webhook) (webhook.Webhook, error)
+	UpdateWebhook(ctx context.Context, wh webhook.Webhook) (webhook.Webhook, error)
+	DeleteWebhook(ctx context.Context, id, projectId int) (webhook.Webhook, error)
+	GetWebhook(ctx context.Context, id, projectId int) (webhook.Webhook, error)
+	GetWebhooks(ctx context.Context, projectId int) ([]webhook.Webhook, error)
+	GetActiveWebhooks(ctx context.Context, projectId int, eventType string) ([]webhook.Webhook, error)
+	EnqueueJobs(ctx context.Context, webhookIds []int, eventId, eventType string, body []byte) error
+	ClaimJobs(ctx context.Context, limit int, lease time.Duration) ([]webhook.Job, error)
+	RescheduleJob(ctx context.Context, id int64, attempt int, delay time.Duration) error
+	DeleteJob(ctx context.Context, id int64) error
+	RecordFailure(ctx context.Context, id, disableAfter int) (bool, error)
+	ResetFailures(ctx context.Context, id int) error
+	CreateDelivery(ctx context.Context, d webhook.Delivery) error
+	GetDeliveries(ctx context.Context, webhookId, limit int) ([]webhook.Delivery, error)
+}
+
+// DeadLetterRepo хранит события, которые консьюмер не смог обработать
+type DeadLetterRepo interface {
+	AddDeadLetter(ctx context.Context, dl deadletter.DeadLetter) error
+	GetDeadLetters(ctx context.Context, afterSeq uint64, limit int) ([]deadletter.DeadLetter, error)
+	GetDeadLetter(ctx context.Context, seq uint64) (deadletter.DeadLetter, error)
+	DeleteDeadLetter(ctx context.Context, seq uint64) error
+	PurgeDeadLetters(ctx context.Context) (uint64, error)
+}
+
+// EventPublisher публикует события в шину (JetStream, Redis Streams или память)
+type EventPublisher interface {
+	Publish(subject, msgId, contentType string, data []byte)
+	Flush()
+}
+
+// EventSubscriber читает события из шины
+type EventSubscriber interface {
+	// Consume подключается к durable-консьюмеру, создавая его при необходимости
+	Consume(cfg config.Consumer) (bus.Consumer, error)
+	// Follow доставляет в handler события subject, начиная с startSeq (0 - только новые)
+	Follow(subject string, startSeq uint64, handler func(bus.Message)) (func() error, error)
 }
 
 type Repository struct {
 	ProjectRepo
 	GoodRepo
 	EventRepo
+	AnalyticsRepo
+	AuditRepo
+	WebhookRepo
+	DeadLetterRepo
+	EventPublisher
+	EventSubscriber
 }
 
-func NewRepositories(pgdb *sql.DB, clickhouseConn driver.Conn, js nats.JetStreamContext, client *redis.Client) *Repository {
+func NewRepositories(cfg *config.Config, pgdb *sql.DB, clickhouseConn driver.Conn, client *redis.Client, localCache *local.Cache, invalidator redisRepo.Invalidator, publisher EventPublisher, subscriber EventSubscriber, deadLetters DeadLetterRepo) *Repository {
 	pgProjectRepo := postgres.NewProjectRepo(pgdb)
 	pgGoodRepo := postgres.NewGoodRepo(pgdb)
 
-	natsPgGoodRepo := natsRepo.NewGoodRepo(pgGoodRepo, js)
-	eventRepo := clickhouse.NewEventRepo(clickhouseConn)
+	natsPgGoodRepo := natsRepo.NewGoodRepo(pgGoodRepo, publisher, cfg.Nats.Publish.ContentType)
+	natsPgProjectRepo := natsRepo.NewProjectRepo(pgProjectRepo, publisher, cfg.Nats.Publish.ContentType)
+	eventRepo := clickhouse.NewEventRepo(clickhouseConn, cfg.Clickhouse)
 
-	redisPgProjectRepo := redisRepo.NewProjectRepo(pgProjectRepo, client)
-	redisNatsPgGoodRepo := redisRepo.NewRedisGoodRepo(natsPgGoodRepo, client)
+	redisNatsPgProjectRepo := redisRepo.NewProjectRepo(natsPgProjectRepo, client, localCache, invalidator)
+	redisNatsPgGoodRepo := redisRepo.NewRedisGoodRepo(natsPgGoodRepo, client, localCache, invalidator)
 
 	return &Repository{
-		ProjectRepo: redisPgProjectRepo,
-		GoodRepo:    redisNatsPgGoodRepo,
-		EventRepo:   eventRepo,
+		ProjectRepo:     redisNatsPgProjectRepo,
+		GoodRepo:        redisNatsPgGoodRepo,
+		EventRepo:       eventRepo,
+		AnalyticsRepo:   clickhouse.NewAnalyticsRepo(clickhouseConn),
+		AuditRepo:       clickhouse.NewAuditRepo(clickhouseConn),
+		WebhookRepo:     postgres.NewWebhookRepo(pgdb),
+		DeadLetterRepo:  deadLetters,
+		EventPublisher:  publisher,
+		EventSubscriber: subscriber,
 	}
 }
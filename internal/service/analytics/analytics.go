@@ -0,0 +1,76 @@
+package analytics
+
+import (
+	"context"
+	"time"
+
+	"github.com/voikin/hezzl-test/internal/domain/analytics"
+	"github.com/voikin/hezzl-test/internal/repository"
+)
+
+const (
+	_defaultWindow = 7 * 24 * time.Hour
+	// на часовой разбивке окно ограничено, чтобы ответ не разрастался
+	_maxHourlyWindow = 31 * 24 * time.Hour
+	_defaultLimit    = 10
+	_maxLimit        = 1000
+)
+
+type AnalyticsService struct {
+	repo repository.AnalyticsRepo
+}
+
+func NewAnalyticsService(repo repository.AnalyticsRepo) *AnalyticsService {
+	return &AnalyticsService{repo: repo}
+}
+
+func (as *AnalyticsService) GetChanges(ctx context.Context, f analytics.Filter, granularity string) ([]analytics.ChangesBucket, error) {
+	if granularity != analytics.GranularityHour {
+		granularity = analytics.GranularityDay
+	}
+
+	f = normalize(f)
+	if granularity == analytics.GranularityHour && f.To.Sub(f.From) > _maxHourlyWindow {
+		f.From = f.To.Add(-_maxHourlyWindow)
+	}
+
+	return as.repo.GetChanges(ctx, f, granularity)
+}
+
+func (as *AnalyticsService) GetMostEdited(ctx context.Context, f analytics.Filter, limit int) ([]analytics.GoodEdits, error) {
+	return as.repo.GetMostEdited(ctx, normalize(f), clampLimit(limit))
+}
+
+func (as *AnalyticsService) GetRemovalRate(ctx context.Context, f analytics.Filter) ([]analytics.RemovalRate, error) {
+	return as.repo.GetRemovalRate(ctx, normalize(f))
+}
+
+func (as *AnalyticsService) GetReprioritizationChurn(ctx context.Context, f analytics.Filter) ([]analytics.ReprioritizationChurn, error) {
+	return as.repo.GetReprioritizationChurn(ctx, normalize(f))
+}
+
+func (as *AnalyticsService) GetActiveProjects(ctx context.Context, f analytics.Filter, limit int) ([]analytics.ActiveProject, error) {
+	return as.repo.GetActiveProjects(ctx, normalize(f), clampLimit(limit))
+}
+
+// normalize подставляет окно по умолчанию: последние 7 дней до конца текущего часа.
+// Границы по целым часам позволяют репозиторию читать почасовые агрегаты.
+func normalize(f analytics.Filter) analytics.Filter {
+	if f.To.IsZero() {
+		f.To = time.Now().UTC().Truncate(time.Hour).Add(time.Hour)
+	}
+	if f.From.IsZero() || !f.From.Before(f.To) {
+		f.From = f.To.Add(-_defaultWindow)
+	}
+	return f
+}
+
+func clampLimit(limit int) int {
+	if limit <= 0 {
+		return _defaultLimit
+	}
+	if limit > _maxLimit {
+		return _maxLimit
+	}
+	return limit
+}
@@ -0,0 +1,98 @@
+package audit
+
+import (
+	"context"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/voikin/hezzl-test/internal/domain/audit"
+	"github.com/voikin/hezzl-test/internal/repository"
+	"github.com/voikin/hezzl-test/internal/utils"
+)
+
+const (
+	_defaultLimit = 50
+	_maxLimit     = 500
+)
+
+type AuditService struct {
+	repo repository.AuditRepo
+}
+
+func NewAuditService(repo repository.AuditRepo) *AuditService {
+	return &AuditService{repo: repo}
+}
+
+// GetAudit возвращает страницу журнала от новых событий к старым.
+// Пустой cursor - первая страница, следующую отдает Page.NextCursor.
+func (as *AuditService) GetAudit(ctx context.Context, f audit.Filter, cursor string, limit int) (audit.Page, error) {
+	if limit <= 0 {
+		limit = _defaultLimit
+	}
+	if limit > _maxLimit {
+		limit = _maxLimit
+	}
+
+	var after *audit.Cursor
+	if cursor != "" {
+		c, err := decodeCursor(cursor)
+		if err != nil {
+			return audit.Page{}, err
+		}
+		after = &c
+	}
+
+	// берем на одно событие больше, чтобы понять, есть ли следующая страница
+	events, err := as.repo.GetAuditEvents(ctx, f, after, limit+1)
+	if err != nil {
+		return audit.Page{}, err
+	}
+
+	page := audit.Page{Entries: make([]audit.Entry, 0, len(events))}
+	if len(events) > limit {
+		events = events[:limit]
+		last := events[len(events)-1]
+		page.NextCursor = encodeCursor(audit.Cursor{EventTime: last.EventTime, EventId: last.EventId})
+	}
+
+	previous, err := as.repo.GetPreviousStates(ctx, events)
+	if err != nil {
+		return audit.Page{}, err
+	}
+
+	for _, ev := range events {
+		entry := audit.Entry{Event: ev}
+		if prev, ok := previous[ev.EventId]; ok {
+			entry.Previous = &prev
+		}
+		page.Entries = append(page.Entries, entry)
+	}
+
+	return page, nil
+}
+
+// курсор - base64 от "<unix-наносекунды>:<EventId>"
+func encodeCursor(c audit.Cursor) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(c.EventTime.UnixNano(), 10) + ":" + c.EventId))
+}
+
+func decodeCursor(s string) (audit.Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return audit.Cursor{}, utils.ErrInvalidCursor
+	}
+
+	nsec, eventId, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return audit.Cursor{}, utils.ErrInvalidCursor
+	}
+
+	unixNano, err := strconv.ParseInt(nsec, 10, 64)
+	if err != nil {
+		return audit.Cursor{}, utils.ErrInvalidCursor
+	}
+
+	return audit.Cursor{EventTime: time.Unix(0, unixNano).UTC(), EventId: eventId}, nil
+}
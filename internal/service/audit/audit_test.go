@@ -0,0 +1,27 @@
+package audit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/voikin/hezzl-test/internal/domain/audit"
+)
+
+// TestCursorKeepsNanoseconds: курсор различает события одной секунды.
+func TestCursorKeepsNanoseconds(t *testing.T) {
+	c := audit.Cursor{EventTime: time.Date(2024, 3, 1, 12, 30, 45, 123456789, time.UTC), EventId: "b7d2"}
+
+	got, err := decodeCursor(encodeCursor(c))
+	if err != nil {
+		t.Fatalf("decodeCursor: %v", err)
+	}
+	if !got.EventTime.Equal(c.EventTime) || got.EventId != c.EventId {
+		t.Fatalf("cursor = %+v, want %+v", got, c)
+	}
+
+	for _, bad := range []string{"!", "bm8tY29sb24", "eDpiN2Qy"} {
+		if _, err := decodeCursor(bad); err == nil {
+			t.Errorf("decodeCursor(%q) succeeded", bad)
+		}
+	}
+}
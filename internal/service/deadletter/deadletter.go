@@ -0,0 +1,77 @@
+package deadletter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/voikin/hezzl-test/internal/domain/deadletter"
+	"github.com/voikin/hezzl-test/internal/repository"
+	"github.com/voikin/hezzl-test/internal/utils"
+)
+
+const (
+	_defaultLimit = 50
+	_maxLimit     = 1000
+)
+
+// Redriver заново обрабатывает мертвое письмо так же, как его консьюмер обработал бы сообщение,
+// и возвращается только после того, как результат сохранен.
+type Redriver interface {
+	Redrive(ctx context.Context, dl deadletter.DeadLetter) error
+}
+
+type DeadLetterService struct {
+	repo repository.DeadLetterRepo
+	// redrivers по имени durable-консьюмера
+	redrivers map[string]Redriver
+}
+
+func NewDeadLetterService(repo repository.DeadLetterRepo, redrivers map[string]Redriver) *DeadLetterService {
+	return &DeadLetterService{repo: repo, redrivers: redrivers}
+}
+
+func (ds *DeadLetterService) GetDeadLetters(ctx context.Context, afterSeq uint64, limit int) ([]deadletter.DeadLetter, error) {
+	if limit <= 0 {
+		limit = _defaultLimit
+	}
+	if limit > _maxLimit {
+		limit = _maxLimit
+	}
+
+	return ds.repo.GetDeadLetters(ctx, afterSeq, limit)
+}
+
+func (ds *DeadLetterService) GetDeadLetter(ctx context.Context, seq uint64) (deadletter.DeadLetter, error) {
+	return ds.repo.GetDeadLetter(ctx, seq)
+}
+
+// RedriveDeadLetter передает письмо консьюмеру, который не смог его обработать, и удаляет письмо,
+// только когда тот сохранил результат. В шину письмо не публикуется: остальные консьюмеры
+// событие уже обработали и получили бы его повторно.
+func (ds *DeadLetterService) RedriveDeadLetter(ctx context.Context, seq uint64) (deadletter.DeadLetter, error) {
+	dl, err := ds.repo.GetDeadLetter(ctx, seq)
+	if err != nil {
+		return deadletter.DeadLetter{}, err
+	}
+
+	redriver, ok := ds.redrivers[dl.Consumer]
+	if !ok {
+		return deadletter.DeadLetter{}, fmt.Errorf("deadletter.RedriveDeadLetter consumer %q: %w", dl.Consumer, utils.ErrDeadLetterNotRedrivable)
+	}
+
+	err = redriver.Redrive(ctx, dl)
+	if err != nil {
+		return deadletter.DeadLetter{}, fmt.Errorf("deadletter.RedriveDeadLetter Redrive: %w", err)
+	}
+
+	err = ds.repo.DeleteDeadLetter(ctx, seq)
+	if err != nil {
+		return deadletter.DeadLetter{}, err
+	}
+
+	return dl, nil
+}
+
+func (ds *DeadLetterService) PurgeDeadLetters(ctx context.Context) (uint64, error) {
+	return ds.repo.PurgeDeadLetters(ctx)
+}
@@ -0,0 +1,99 @@
+package diff
+
+import (
+	"context"
+	"time"
+
+	"github.com/voikin/hezzl-test/internal/domain/diff"
+	"github.com/voikin/hezzl-test/internal/domain/event"
+	"github.com/voikin/hezzl-test/internal/repository"
+	"github.com/voikin/hezzl-test/internal/utils"
+)
+
+type DiffService struct {
+	goods  repository.GoodRepo
+	events repository.EventRepo
+}
+
+func NewDiffService(goods repository.GoodRepo, events repository.EventRepo) *DiffService {
+	return &DiffService{goods: goods, events: events}
+}
+
+// GetGoodDiff сравнивает состояние товара на момент from с состоянием на момент to.
+// Состояние на момент - последнее событие товара не позже него; нулевой to - текущая строка Postgres.
+func (ds *DiffService) GetGoodDiff(ctx context.Context, projectId, id int, from, to time.Time) (diff.GoodDiff, error) {
+	history, err := ds.events.GetGoodEvents(ctx, projectId, id)
+	if err != nil {
+		return diff.GoodDiff{}, err
+	}
+
+	fromVersion, ok := versionAt(history, from)
+	if !ok {
+		return diff.GoodDiff{}, utils.ErrGoodVersionNotFound
+	}
+
+	var toVersion diff.Version
+	if to.IsZero() {
+		current, err := ds.goods.GetGood(ctx, id, projectId)
+		if err != nil {
+			return diff.GoodDiff{}, err
+		}
+		toVersion = diff.Version{
+			Source:      diff.SourceCurrent,
+			Name:        current.Name,
+			Description: current.Description,
+			Priority:    current.Priority,
+			Removed:     current.Removed,
+		}
+	} else if toVersion, ok = versionAt(history, to); !ok {
+		return diff.GoodDiff{}, utils.ErrGoodVersionNotFound
+	}
+
+	d := diff.GoodDiff{
+		ProjectId: projectId,
+		Id:        id,
+		From:      fromVersion,
+		To:        toVersion,
+		Fields: []diff.Field{
+			field("name", fromVersion.Name, toVersion.Name),
+			field("description", fromVersion.Description, toVersion.Description),
+			field("priority", fromVersion.Priority, toVersion.Priority),
+			field("removed", fromVersion.Removed, toVersion.Removed),
+		},
+	}
+	d.DescriptionDiff = unifiedDiff("description@"+label(fromVersion), "description@"+label(toVersion),
+		fromVersion.Description, toVersion.Description)
+
+	return d, nil
+}
+
+// versionAt ищет последнее событие не позже at; history упорядочена по времени.
+func versionAt(history []event.ClickhouseEvent, at time.Time) (diff.Version, bool) {
+	for i := len(history) - 1; i >= 0; i-- {
+		ev := history[i]
+		if ev.EventTime.After(at) {
+			continue
+		}
+		return diff.Version{
+			Source:      diff.SourceEvent,
+			EventId:     ev.EventId,
+			EventTime:   ev.EventTime,
+			Name:        ev.Name,
+			Description: ev.Description,
+			Priority:    ev.Priority,
+			Removed:     ev.Removed,
+		}, true
+	}
+	return diff.Version{}, false
+}
+
+func field[T comparable](name string, from, to T) diff.Field {
+	return diff.Field{Field: name, From: from, To: to, Changed: from != to}
+}
+
+func label(v diff.Version) string {
+	if v.Source == diff.SourceCurrent {
+		return "now"
+	}
+	return v.EventTime.UTC().Format(time.RFC3339)
+}
@@ -2,66 +2,281 @@ package eventSaver
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"sync"
 	"time"
 
-	"github.com/nats-io/nats.go"
+	"github.com/voikin/hezzl-test/config"
+	"github.com/voikin/hezzl-test/internal/domain/deadletter"
 	"github.com/voikin/hezzl-test/internal/domain/event"
 	"github.com/voikin/hezzl-test/internal/repository"
+	"github.com/voikin/hezzl-test/internal/repository/bus"
+	"github.com/voikin/hezzl-test/internal/utils"
 )
 
 type EventSaver struct {
-	repo repository.EventRepo
-	js   nats.JetStreamContext
+	repo        repository.EventRepo
+	deadLetters repository.DeadLetterRepo
+	subscriber  repository.EventSubscriber
+	consumerCfg config.Consumer
+	cfg         config.EventSaver
+	wg          sync.WaitGroup
+	stats       stats
 }
 
-func (es *EventSaver) CheckBatch(ctx context.Context) {
-	sub, _ := es.js.PullSubscribe("events.goods",
-		"worker",
-		nats.PullMaxWaiting(128),
-		nats.BindStream("EVENTS"),
-	)
-	defer sub.Unsubscribe()
+// CheckBatch непрерывно читает события из source и пишет их в ClickHouse пачками:
+// пачка уходит, как только набрала BatchSize событий или первое событие в ней ждет дольше Linger.
+func (es *EventSaver) CheckBatch(ctx context.Context, next source) {
+	batch := make([]event.ClickhouseEvent, 0, es.cfg.BatchSize)
+	msgs := make([]bus.Message, 0, es.cfg.BatchSize)
+	var flushAt time.Time
 
-	for {
-		if _, ok := ctx.Deadline(); ok {
-			break
+	for ctx.Err() == nil {
+		wait := es.cfg.FetchTimeout
+		if len(batch) != 0 {
+			if untilFlush := time.Until(flushAt); untilFlush < wait {
+				wait = untilFlush
+			}
 		}
 
-		time.Sleep(time.Second * 10)
+		if wait > 0 {
+			// просим не больше, чем осталось места в пачке, остальное подождет в стриме
+			items, err := next(ctx, es.cfg.BatchSize-len(batch), wait)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("eventSaver Fetch: %v", err)
+					time.Sleep(wait)
+				}
+				continue
+			}
 
-		msgs, err := sub.FetchBatch(100, nats.Context(ctx))
-		if err != nil {
-			continue
+			for _, it := range items {
+				if len(batch) == 0 {
+					flushAt = time.Now().Add(es.cfg.Linger)
+				}
+				es.stats.received.Add(1)
+				batch = append(batch, it.ev)
+				msgs = append(msgs, it.msg)
+			}
+		}
+
+		if len(batch) >= es.cfg.BatchSize || (len(batch) != 0 && !time.Now().Before(flushAt)) {
+			es.flush(ctx, batch, msgs)
+			batch, msgs = batch[:0], msgs[:0]
 		}
+	}
 
-		batch := make([]event.ClickhouseEvent, 0, 100)
+	// пачка еще не подтверждена: если успеем записать, ее не придется доставлять заново
+	if len(batch) != 0 {
+		es.flush(context.Background(), batch, msgs)
+	}
+}
 
-		for msg := range msgs.Messages() {
-			ev := event.ClickhouseEvent{}
-			_ = json.Unmarshal(msg.Data, &ev)
+// flush подтверждает сообщения только после того, как ClickHouse принял пачку.
+// Пока ClickHouse недоступен, пачка повторяется на месте и не расходует попытки доставки.
+// Пачку, которую ClickHouse отклонил, flush делит пополам, пока не найдет испорченное событие:
+// остальные записываются, а оно уходит на повторную доставку, исчерпав MaxDeliver - в мертвые письма.
+func (es *EventSaver) flush(ctx context.Context, batch []event.ClickhouseEvent, msgs []bus.Message) {
+	inserted, err := es.saveRetrying(ctx, batch, msgs)
+	if err == nil {
+		for _, msg := range msgs {
 			_ = msg.Ack()
-			batch = append(batch, ev)
 		}
+		es.stats.batches.Add(1)
+		es.stats.inserted.Add(uint64(inserted))
+		es.stats.duplicates.Add(uint64(len(batch) - inserted))
+		es.stats.lastFlush.Store(time.Now().Unix())
+		return
+	}
 
-		if len(batch) != 0 {
-			err = es.repo.CreateEvent(ctx, batch)
+	if len(batch) > 1 && !errors.Is(err, utils.ErrStorageUnavailable) {
+		log.Printf("eventSaver: batch of %d events rejected, splitting: %v", len(batch), err)
+		mid := len(batch) / 2
+		es.flush(ctx, batch[:mid], msgs[:mid])
+		es.flush(ctx, batch[mid:], msgs[mid:])
+		return
+	}
+
+	log.Printf("eventSaver: failed to save %d events: %v", len(batch), err)
+	es.stats.failed.Add(uint64(len(batch)))
+
+	for _, msg := range msgs {
+		delivered := msg.NumDelivered()
+		if es.consumerCfg.MaxDeliver > 0 && delivered >= uint64(es.consumerCfg.MaxDeliver) &&
+			!errors.Is(err, utils.ErrStorageUnavailable) &&
+			es.deadLetter(ctx, msg, deadletter.ReasonMaxDeliver, err) {
+			continue
+		}
+		_ = msg.Nak(es.retryDelay(delivered))
+	}
+}
+
+// saveRetrying повторяет save, пока ClickHouse недоступен, с растущей задержкой и продлевает
+// AckWait сообщений, чтобы шина не доставила их заново. Прочие ошибки возвращаются сразу,
+// недоступность - только при отмене ctx.
+func (es *EventSaver) saveRetrying(ctx context.Context, batch []event.ClickhouseEvent, msgs []bus.Message) (int, error) {
+	delay := es.cfg.RetryBackoff
+	for {
+		inserted, err := es.save(ctx, batch)
+		if err == nil || !errors.Is(err, utils.ErrStorageUnavailable) {
+			return inserted, err
+		}
+
+		log.Printf("eventSaver: storage is unavailable, retrying %d events in %s: %v", len(batch), delay, err)
+		if !es.holdMessages(ctx, msgs, delay) {
+			return 0, err
 		}
 
-		fmt.Println(batch, err)
+		delay *= 2
+		if delay > es.cfg.MaxRetryBackoff {
+			delay = es.cfg.MaxRetryBackoff
+		}
 	}
 }
 
+// holdMessages ждет delay, не давая истечь AckWait сообщений. Возвращает false, если ctx отменили раньше.
+// Продлевает сразу: короткие задержки повторов заканчиваются раньше первого тика.
+func (es *EventSaver) holdMessages(ctx context.Context, msgs []bus.Message, delay time.Duration) bool {
+	for _, msg := range msgs {
+		_ = msg.InProgress()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
 
-func (es *EventSaver) Start(ctx context.Context) {
-	go es.CheckBatch(ctx)
+	tick := es.consumerCfg.AckWait / 2
+	if tick <= 0 || tick > delay {
+		tick = delay
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+			return true
+		case <-ticker.C:
+			for _, msg := range msgs {
+				_ = msg.InProgress()
+			}
+		}
+	}
 }
 
-func NewEventSaver(repo repository.EventRepo, js nats.JetStreamContext) *EventSaver {
+// save пишет пачку в ClickHouse, отбрасывая повторы события внутри пачки. Повторную доставку
+// уже записанного события схлопывает ReplacingMergeTree по EventId, чтения идут с FINAL.
+// Возвращает число записанных событий.
+func (es *EventSaver) save(ctx context.Context, batch []event.ClickhouseEvent) (int, error) {
+	unique := make([]event.ClickhouseEvent, 0, len(batch))
+	seen := make(map[string]struct{}, len(batch))
+
+	for _, ev := range batch {
+		if ev.EventId == "" {
+			ev.EventId = event.ContentId(ev)
+		}
+		if _, ok := seen[ev.EventId]; ok {
+			continue
+		}
+		seen[ev.EventId] = struct{}{}
+		unique = append(unique, ev)
+	}
+
+	if err := es.repo.CreateEvent(ctx, unique); err != nil {
+		return 0, err
+	}
+	return len(unique), nil
+}
+
+func (es *EventSaver) retryDelay(delivered uint64) time.Duration {
+	delay := es.cfg.RetryBackoff
+	for i := uint64(1); i < delivered && delay < es.cfg.MaxRetryBackoff; i++ {
+		delay *= 2
+	}
+	if delay > es.cfg.MaxRetryBackoff {
+		delay = es.cfg.MaxRetryBackoff
+	}
+	return delay
+}
+
+// decode разбирает событие. Чужие subject сразу подтверждаются, битые сообщения уходят в мертвые письма.
+func (es *EventSaver) decode(ctx context.Context, msg bus.Message) (event.ClickhouseEvent, bool) {
+	if !event.IsGoodsSubject(msg.Subject()) {
+		_ = msg.Ack()
+		return event.ClickhouseEvent{}, false
+	}
+
+	ev, err := event.Unmarshal(msg.Data(), msg.Header(event.ContentTypeHeader))
+	if err != nil {
+		if !es.deadLetter(ctx, msg, deadletter.ReasonUndecodable, err) {
+			_ = msg.Nak(es.retryDelay(msg.NumDelivered()))
+		}
+		return event.ClickhouseEvent{}, false
+	}
+
+	return ev, true
+}
+
+// deadLetter переносит сообщение в мертвые письма и снимает его с доставки.
+// Если сохранить письмо не удалось, сообщение остается в стриме и возвращается false.
+func (es *EventSaver) deadLetter(ctx context.Context, msg bus.Message, reason string, cause error) bool {
+	err := es.deadLetters.AddDeadLetter(ctx, deadletter.DeadLetter{
+		Subject:     msg.Subject(),
+		StreamSeq:   msg.Seq(),
+		Consumer:    es.consumerCfg.Durable,
+		Reason:      reason,
+		Error:       cause.Error(),
+		Deliveries:  msg.NumDelivered(),
+		FailedAt:    time.Now().UTC(),
+		ContentType: msg.Header(event.ContentTypeHeader),
+		Data:        msg.Data(),
+	})
+	if err != nil {
+		log.Printf("eventSaver AddDeadLetter: %v", err)
+		return false
+	}
+
+	log.Printf("eventSaver: message %d moved to dead letters: %s: %v", msg.Seq(), reason, cause)
+	es.stats.deadLettered.Add(1)
+	_ = msg.Term()
+	return true
+}
+
+// Redrive записывает событие мертвого письма прямо в ClickHouse, минуя шину.
+func (es *EventSaver) Redrive(ctx context.Context, dl deadletter.DeadLetter) error {
+	if !event.IsGoodsSubject(dl.Subject) {
+		return nil
+	}
+
+	ev, err := event.Unmarshal(dl.Data, dl.ContentType)
+	if err != nil {
+		return fmt.Errorf("eventSaver.Redrive Unmarshal: %w", err)
+	}
+
+	_, err = es.save(ctx, []event.ClickhouseEvent{ev})
+	if err != nil {
+		return fmt.Errorf("eventSaver.Redrive: %w", err)
+	}
+	return nil
+}
+
+func NewEventSaver(repo repository.EventRepo, deadLetters repository.DeadLetterRepo, subscriber repository.EventSubscriber, consumerCfg config.Consumer, cfg config.EventSaver) *EventSaver {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 1
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
+
 	es := &EventSaver{
-		repo: repo,
-		js:   js,
+		repo:        repo,
+		deadLetters: deadLetters,
+		subscriber:  subscriber,
+		consumerCfg: consumerCfg,
+		cfg:         cfg,
 	}
 	return es
 }
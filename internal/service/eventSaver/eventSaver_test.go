@@ -0,0 +1,304 @@
+package eventSaver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/voikin/hezzl-test/config"
+	"github.com/voikin/hezzl-test/internal/domain/deadletter"
+	"github.com/voikin/hezzl-test/internal/domain/event"
+	"github.com/voikin/hezzl-test/internal/repository/memory"
+	deadLetterService "github.com/voikin/hezzl-test/internal/service/deadletter"
+	"github.com/voikin/hezzl-test/internal/utils"
+)
+
+// fakeEventRepo - EventRepo в памяти. failures первых вызовов CreateEvent возвращают err,
+// следующие panics вызовов паникуют, пачка с событием poison отклоняется всегда.
+// order хранит записанные события в порядке записи.
+type fakeEventRepo struct {
+	mu       sync.Mutex
+	events   map[string]event.ClickhouseEvent
+	order    []event.ClickhouseEvent
+	calls    int
+	failures int
+	err      error
+	panics   int
+	poison   string
+}
+
+func newFakeEventRepo() *fakeEventRepo {
+	return &fakeEventRepo{events: make(map[string]event.ClickhouseEvent)}
+}
+
+func (r *fakeEventRepo) CreateEvent(ctx context.Context, events []event.ClickhouseEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.calls++
+	if r.calls <= r.failures {
+		return r.err
+	}
+	if r.calls <= r.failures+r.panics {
+		panic("clickhouse driver bug")
+	}
+	for _, ev := range events {
+		if ev.EventId == r.poison {
+			return fmt.Errorf("code: 27, cannot parse input: event %s", ev.EventId)
+		}
+	}
+
+	for _, ev := range events {
+		r.events[ev.EventId] = ev
+	}
+	r.order = append(r.order, events...)
+	return nil
+}
+
+func (r *fakeEventRepo) GetGoodEvents(ctx context.Context, projectId, id int) ([]event.ClickhouseEvent, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (r *fakeEventRepo) GetGoodStates(ctx context.Context, projectId int) ([]event.ClickhouseEvent, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (r *fakeEventRepo) saved() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.events)
+}
+
+type testBus struct {
+	bus         *memory.EventBus
+	deadLetters *memory.DeadLetterRepo
+	repo        *fakeEventRepo
+	consumer    config.Consumer
+	saver       *EventSaver
+}
+
+func newTestBus(maxDeliver int) *testBus {
+	tb := &testBus{
+		bus:         memory.NewEventBus(config.Stream{}),
+		deadLetters: memory.NewDeadLetterRepo(),
+		repo:        newFakeEventRepo(),
+		consumer:    config.Consumer{Durable: "worker", FilterSubject: "events.>", AckWait: 50 * time.Millisecond, MaxDeliver: maxDeliver},
+	}
+	tb.saver = NewEventSaver(tb.repo, tb.deadLetters, tb.bus, tb.consumer, config.EventSaver{
+		BatchSize:       10,
+		Linger:          10 * time.Millisecond,
+		FetchTimeout:    20 * time.Millisecond,
+		RetryBackoff:    10 * time.Millisecond,
+		MaxRetryBackoff: 40 * time.Millisecond,
+		Workers:         1,
+		RestartBackoff:  10 * time.Millisecond,
+	})
+	return tb
+}
+
+func (tb *testBus) publishGood(t *testing.T, eventId string, projectId int) {
+	t.Helper()
+
+	data, err := event.Marshal(event.ClickhouseEvent{
+		EventId:   eventId,
+		Type:      event.TypeGoodCreated,
+		Id:        1,
+		ProjectId: projectId,
+		Name:      "good",
+		EventTime: time.Now().UTC().Truncate(time.Second),
+	}, event.ContentTypeJSON)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	tb.bus.Publish(event.GoodsSubject(projectId), eventId, event.ContentTypeJSON, data)
+}
+
+// run запускает EventSaver, ждет cond и останавливает его.
+func (tb *testBus) run(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	tb.saver.Start(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			cancel()
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	if err := tb.saver.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+}
+
+// assertDrained проверяет, что у консьюмера не осталось неподтвержденных сообщений:
+// неподтвержденное вернулось бы после AckWait.
+func (tb *testBus) assertDrained(t *testing.T) {
+	t.Helper()
+
+	consumer, err := tb.bus.Consume(tb.consumer)
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	msgs, err := consumer.Fetch(context.Background(), 10, 3*tb.consumer.AckWait)
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if len(msgs) != 0 {
+		t.Fatalf("%d messages are still pending, first is %s #%d", len(msgs), msgs[0].Subject(), msgs[0].Seq())
+	}
+}
+
+func (tb *testBus) deadLettered(t *testing.T) []deadletter.DeadLetter {
+	t.Helper()
+
+	dls, err := tb.deadLetters.GetDeadLetters(context.Background(), 0, 100)
+	if err != nil {
+		t.Fatalf("GetDeadLetters: %v", err)
+	}
+	return dls
+}
+
+func TestEventSaverAcksSavedEvents(t *testing.T) {
+	tb := newTestBus(5)
+
+	for i := 1; i <= 3; i++ {
+		tb.publishGood(t, fmt.Sprintf("e%d", i), i)
+	}
+	// события проектов eventSaver не пишет, но подтверждает
+	tb.bus.Publish(event.SubjectProjects, "p1", event.ContentTypeJSON, []byte(`{}`))
+	// битое сообщение уходит в мертвые письма
+	tb.bus.Publish(event.GoodsSubject(1), "", event.ContentTypeJSON, nil)
+
+	tb.run(t, "events to be saved", func() bool { return tb.repo.saved() == 3 })
+
+	tb.assertDrained(t)
+	if dls := tb.deadLettered(t); len(dls) != 1 || dls[0].Reason != deadletter.ReasonUndecodable {
+		t.Fatalf("dead letters = %+v, want one undecodable", dls)
+	}
+}
+
+func TestEventSaverRetriesFailedBatch(t *testing.T) {
+	tb := newTestBus(5)
+	tb.repo.failures = 2
+	tb.repo.err = errors.New("code: 241, memory limit exceeded")
+
+	tb.publishGood(t, "e1", 1)
+	tb.publishGood(t, "e2", 2)
+
+	tb.run(t, "the batch to be saved after retries", func() bool { return tb.repo.saved() == 2 })
+
+	tb.assertDrained(t)
+	if dls := tb.deadLettered(t); len(dls) != 0 {
+		t.Fatalf("dead letters = %+v, want none", dls)
+	}
+}
+
+func TestEventSaverDeadLettersAfterMaxDeliver(t *testing.T) {
+	const maxDeliver = 3
+
+	tb := newTestBus(maxDeliver)
+	tb.repo.failures = 1 << 30
+	tb.repo.err = errors.New("code: 27, cannot parse input")
+
+	tb.publishGood(t, "e1", 1)
+
+	tb.run(t, "the event to be dead-lettered", func() bool { return len(tb.deadLettered(t)) == 1 })
+
+	tb.assertDrained(t)
+	dl := tb.deadLettered(t)[0]
+	if dl.Reason != deadletter.ReasonMaxDeliver || dl.Deliveries != maxDeliver || dl.Consumer != "worker" || dl.Subject != event.GoodsSubject(1) {
+		t.Fatalf("dead letter = %+v", dl)
+	}
+	if tb.repo.saved() != 0 {
+		t.Fatal("the event was saved")
+	}
+}
+
+// TestEventSaverRedrivesDeadLetter: повтор мертвого письма пишет событие прямо в ClickHouse,
+// в шину ничего не публикуется, письмо удаляется только после записи.
+func TestEventSaverRedrivesDeadLetter(t *testing.T) {
+	tb := newTestBus(1)
+	tb.repo.failures = 1 << 30
+	tb.repo.err = errors.New("code: 27, cannot parse input")
+
+	tb.publishGood(t, "e1", 1)
+	tb.run(t, "the event to be dead-lettered", func() bool { return len(tb.deadLettered(t)) == 1 })
+	dl := tb.deadLettered(t)[0]
+
+	redriver := deadLetterService.NewDeadLetterService(tb.deadLetters, map[string]deadLetterService.Redriver{"worker": tb.saver})
+	if _, err := redriver.RedriveDeadLetter(context.Background(), dl.Seq); err == nil {
+		t.Fatal("redrive succeeded while ClickHouse rejects the event")
+	}
+	if len(tb.deadLettered(t)) != 1 {
+		t.Fatal("dead letter was deleted after a failed redrive")
+	}
+
+	tb.repo.failures = 0
+	if _, err := redriver.RedriveDeadLetter(context.Background(), dl.Seq); err != nil {
+		t.Fatalf("RedriveDeadLetter: %v", err)
+	}
+	if tb.repo.saved() != 1 {
+		t.Fatalf("saved %d events, want 1", tb.repo.saved())
+	}
+	if len(tb.deadLettered(t)) != 0 {
+		t.Fatal("dead letter was not deleted")
+	}
+	tb.assertDrained(t)
+
+	dl.Consumer = "webhooks"
+	if err := tb.deadLetters.AddDeadLetter(context.Background(), dl); err != nil {
+		t.Fatalf("AddDeadLetter: %v", err)
+	}
+	if _, err := redriver.RedriveDeadLetter(context.Background(), dl.Seq+1); !errors.Is(err, utils.ErrDeadLetterNotRedrivable) {
+		t.Fatalf("redrive for an unknown consumer: %v", err)
+	}
+}
+
+// TestEventSaverWaitsOutUnavailableStorage: пока ClickHouse недоступен, пачка повторяется на месте,
+// и число неудачных попыток не приближает ее к мертвым письмам.
+func TestEventSaverWaitsOutUnavailableStorage(t *testing.T) {
+	tb := newTestBus(2)
+	tb.repo.failures = 5
+	tb.repo.err = fmt.Errorf("%w: dial tcp 127.0.0.1:9000: connect: connection refused", utils.ErrStorageUnavailable)
+
+	tb.publishGood(t, "e1", 1)
+
+	tb.run(t, "the event to be saved", func() bool { return tb.repo.saved() == 1 })
+
+	tb.assertDrained(t)
+	if dls := tb.deadLettered(t); len(dls) != 0 {
+		t.Fatalf("dead letters = %+v, want none", dls)
+	}
+	if tb.repo.calls != tb.repo.failures+1 {
+		t.Fatalf("CreateEvent called %d times, want %d", tb.repo.calls, tb.repo.failures+1)
+	}
+}
+
+// TestEventSaverSplitsRejectedBatch: ClickHouse отклоняет пачку из-за одного события,
+// остальные записываются, а оно одно уходит в мертвые письма.
+func TestEventSaverSplitsRejectedBatch(t *testing.T) {
+	tb := newTestBus(2)
+	tb.repo.poison = "e3"
+
+	for i := 1; i <= 5; i++ {
+		tb.publishGood(t, fmt.Sprintf("e%d", i), i)
+	}
+
+	tb.run(t, "the poison event to be dead-lettered", func() bool {
+		return tb.repo.saved() == 4 && len(tb.deadLettered(t)) == 1
+	})
+
+	tb.assertDrained(t)
+	dl := tb.deadLettered(t)[0]
+	if dl.Reason != deadletter.ReasonMaxDeliver || dl.Subject != event.GoodsSubject(3) {
+		t.Fatalf("dead letter = %+v, want the e3 event", dl)
+	}
+}
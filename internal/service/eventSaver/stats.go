@@ -0,0 +1,34 @@
+package eventSaver
+
+import "sync/atomic"
+
+type stats struct {
+	received     atomic.Uint64
+	inserted     atomic.Uint64
+	duplicates   atomic.Uint64
+	failed       atomic.Uint64
+	deadLettered atomic.Uint64
+	batches      atomic.Uint64
+	lastFlush    atomic.Int64
+}
+
+// Metric - одно значение для /metrics
+type Metric struct {
+	Name  string
+	Help  string
+	Type  string // counter | gauge
+	Value float64
+}
+
+// Metrics возвращает счетчики конвейера в порядке, пригодном для вывода.
+func (es *EventSaver) Metrics() []Metric {
+	return []Metric{
+		{"eventsaver_events_received_total", "Events read from the bus.", "counter", float64(es.stats.received.Load())},
+		{"eventsaver_events_inserted_total", "Events written to ClickHouse.", "counter", float64(es.stats.inserted.Load())},
+		{"eventsaver_events_duplicate_total", "Events repeated within a batch and skipped.", "counter", float64(es.stats.duplicates.Load())},
+		{"eventsaver_events_failed_total", "Events from batches that failed to save and were redelivered.", "counter", float64(es.stats.failed.Load())},
+		{"eventsaver_events_dead_lettered_total", "Events moved to dead letters.", "counter", float64(es.stats.deadLettered.Load())},
+		{"eventsaver_batches_total", "Batches written to ClickHouse.", "counter", float64(es.stats.batches.Load())},
+		{"eventsaver_last_flush_timestamp_seconds", "Unix time of the last successful batch.", "gauge", float64(es.stats.lastFlush.Load())},
+	}
+}
@@ -0,0 +1,217 @@
+package eventSaver
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/voikin/hezzl-test/internal/domain/event"
+	"github.com/voikin/hezzl-test/internal/repository/bus"
+)
+
+// item - разобранное событие вместе с сообщением, которое нужно подтвердить после записи
+type item struct {
+	ev  event.ClickhouseEvent
+	msg bus.Message
+}
+
+// source отдает до max событий, ожидая первое не дольше wait.
+// Пустой результат без ошибки означает, что событий не было.
+type source func(ctx context.Context, max int, wait time.Duration) ([]item, error)
+
+// Start запускает Workers воркеров под присмотром супервизора.
+// Без партиционирования каждый воркер сам читает общий durable-консьюмер,
+// с партиционированием читает один роутер и раскладывает события по воркерам по ProjectId.
+func (es *EventSaver) Start(ctx context.Context) {
+	if es.cfg.PartitionByProject && es.cfg.Workers > 1 {
+		// без буфера: событие, которое воркер еще не забрал, остается у роутера, и тот продлевает его AckWait
+		partitions := make([]chan item, es.cfg.Workers)
+		for i := range partitions {
+			partitions[i] = make(chan item)
+		}
+
+		es.wg.Add(1 + len(partitions))
+		go es.supervise(ctx, "router", func(ctx context.Context) error {
+			return es.route(ctx, partitions)
+		})
+
+		for i, partition := range partitions {
+			next := fromPartition(partition)
+			go es.supervise(ctx, fmt.Sprintf("worker %d", i), func(ctx context.Context) error {
+				es.CheckBatch(ctx, next)
+				return nil
+			})
+		}
+		return
+	}
+
+	es.wg.Add(es.cfg.Workers)
+	for i := 0; i < es.cfg.Workers; i++ {
+		go es.supervise(ctx, fmt.Sprintf("worker %d", i), func(ctx context.Context) error {
+			consumer, err := es.subscriber.Consume(es.consumerCfg)
+			if err != nil {
+				return err
+			}
+			defer consumer.Close()
+
+			es.CheckBatch(ctx, es.fromConsumer(consumer))
+			return nil
+		})
+	}
+}
+
+// Wait ждет, пока воркеры после отмены ctx из Start запишут текущие пачки, но не дольше ctx.
+func (es *EventSaver) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		es.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("eventSaver.Wait: %w", ctx.Err())
+	}
+}
+
+// supervise перезапускает run, пока не отменен ctx: и после ошибки, и после паники.
+// Неподтвержденные сообщения упавшего воркера JetStream доставит повторно по AckWait.
+func (es *EventSaver) supervise(ctx context.Context, name string, run func(ctx context.Context) error) {
+	defer es.wg.Done()
+
+	for {
+		err := safeRun(ctx, run)
+		if ctx.Err() != nil {
+			return
+		}
+
+		log.Printf("eventSaver %s stopped: %v, restarting in %s", name, err, es.cfg.RestartBackoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(es.cfg.RestartBackoff):
+		}
+	}
+}
+
+func safeRun(ctx context.Context, run func(ctx context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	err = run(ctx)
+	if err == nil && ctx.Err() == nil {
+		err = fmt.Errorf("exited unexpectedly")
+	}
+	return err
+}
+
+// route читает консьюмер и раскладывает события по партициям. Пока воркер партиции
+// не забрал событие, роутер ждет, так что медленный воркер притормаживает чтение.
+func (es *EventSaver) route(ctx context.Context, partitions []chan item) error {
+	consumer, err := es.subscriber.Consume(es.consumerCfg)
+	if err != nil {
+		return err
+	}
+	defer consumer.Close()
+
+	next := es.fromConsumer(consumer)
+
+	for ctx.Err() == nil {
+		items, err := next(ctx, es.cfg.BatchSize, es.cfg.FetchTimeout)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("eventSaver router Fetch: %v", err)
+				time.Sleep(es.cfg.FetchTimeout)
+			}
+			continue
+		}
+
+		for i, it := range items {
+			if !es.send(ctx, partitions[partitionOf(it.ev.ProjectId, len(partitions))], it, items[i:]) {
+				return nil
+			}
+		}
+	}
+
+	return nil
+}
+
+// send передает событие воркеру партиции. Пока воркер занят, send продлевает AckWait
+// всех еще не разложенных событий пачки waiting, чтобы шина не доставила их заново.
+// Возвращает false, если ctx отменили раньше.
+func (es *EventSaver) send(ctx context.Context, partition chan<- item, it item, waiting []item) bool {
+	var tick <-chan time.Time
+	if es.consumerCfg.AckWait > 0 {
+		ticker := time.NewTicker(es.consumerCfg.AckWait / 2)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case partition <- it:
+			return true
+		case <-ctx.Done():
+			return false
+		case <-tick:
+			for _, w := range waiting {
+				_ = w.msg.InProgress()
+			}
+		}
+	}
+}
+
+func partitionOf(projectId, n int) int {
+	return int(uint(projectId) % uint(n))
+}
+
+func (es *EventSaver) fromConsumer(consumer bus.Consumer) source {
+	return func(ctx context.Context, max int, wait time.Duration) ([]item, error) {
+		msgs, err := consumer.Fetch(ctx, max, wait)
+		if err != nil {
+			return nil, err
+		}
+
+		items := make([]item, 0, len(msgs))
+		for _, msg := range msgs {
+			if ev, ok := es.decode(ctx, msg); ok {
+				items = append(items, item{ev: ev, msg: msg})
+			}
+		}
+		return items, nil
+	}
+}
+
+func fromPartition(partition <-chan item) source {
+	return func(ctx context.Context, max int, wait time.Duration) ([]item, error) {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+
+		var items []item
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+			return nil, nil
+		case it := <-partition:
+			items = append(items, it)
+		}
+
+		for len(items) < max {
+			select {
+			case it := <-partition:
+				items = append(items, it)
+			default:
+				return items, nil
+			}
+		}
+		return items, nil
+	}
+}
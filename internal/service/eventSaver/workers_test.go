@@ -0,0 +1,203 @@
+package eventSaver
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/voikin/hezzl-test/config"
+	"github.com/voikin/hezzl-test/internal/domain/event"
+	"github.com/voikin/hezzl-test/internal/repository/memory"
+	natsRepo "github.com/voikin/hezzl-test/internal/repository/nats"
+	"github.com/voikin/hezzl-test/internal/repository/nats/natstest"
+	"github.com/voikin/hezzl-test/internal/utils"
+)
+
+// natsSaver - EventSaver поверх встроенного NATS с теми же стримами, что и в проде.
+type natsSaver struct {
+	js    nats.JetStreamContext
+	repo  *fakeEventRepo
+	saver *EventSaver
+}
+
+func newNatsSaver(t *testing.T, js nats.JetStreamContext, cfg config.EventSaver) *natsSaver {
+	t.Helper()
+
+	consumer := config.Consumer{Durable: "worker", FilterSubject: "events.>", AckWait: 300 * time.Millisecond, MaxDeliver: 5}
+	subscriber, err := natsRepo.NewSubscriber(js,
+		config.Stream{Name: "EVENTS_V2", Subjects: []string{"events.goods", "events.projects"}, Retention: "interest", MaxBytes: -1, Replicas: 1, Storage: "file"},
+		config.FeedStream{Name: "EVENTS_FEED", Subjects: []string{"events.goods.>"}, MaxAge: time.Hour, MaxBytes: -1},
+		consumer)
+	if err != nil {
+		t.Fatalf("NewSubscriber: %v", err)
+	}
+
+	cfg.Linger = 10 * time.Millisecond
+	cfg.FetchTimeout = 50 * time.Millisecond
+	cfg.RetryBackoff = 10 * time.Millisecond
+	cfg.MaxRetryBackoff = 50 * time.Millisecond
+	cfg.RestartBackoff = 10 * time.Millisecond
+
+	ns := &natsSaver{js: js, repo: newFakeEventRepo()}
+	ns.saver = NewEventSaver(ns.repo, memory.NewDeadLetterRepo(), subscriber, consumer, cfg)
+	return ns
+}
+
+func (ns *natsSaver) publish(t *testing.T, eventId string, projectId, priority int) {
+	t.Helper()
+
+	data, err := event.Marshal(event.ClickhouseEvent{
+		EventId:   eventId,
+		Type:      event.TypeGoodUpdated,
+		Id:        1,
+		ProjectId: projectId,
+		Name:      "good",
+		Priority:  priority,
+		EventTime: time.Now().UTC().Truncate(time.Second),
+	}, event.ContentTypeJSON)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	msg := nats.NewMsg(event.GoodsSubject(projectId))
+	msg.Header.Set(nats.MsgIdHdr, eventId)
+	msg.Header.Set(event.ContentTypeHeader, event.ContentTypeJSON)
+	msg.Data = data
+	if _, err := ns.js.PublishMsg(msg); err != nil {
+		t.Fatalf("PublishMsg %s: %v", eventId, err)
+	}
+}
+
+func (ns *natsSaver) start(t *testing.T) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ns.saver.Start(ctx)
+	t.Cleanup(func() {
+		cancel()
+		if err := ns.saver.Wait(context.Background()); err != nil {
+			t.Errorf("Wait: %v", err)
+		}
+	})
+}
+
+func waitSaved(t *testing.T, repo *fakeEventRepo, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for repo.saved() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("saved %d events, want %d", repo.saved(), n)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// TestPartitionedWorkersKeepProjectOrder: с PartitionByProject события одного проекта
+// пишет один воркер, поэтому они попадают в ClickHouse в порядке публикации.
+func TestPartitionedWorkersKeepProjectOrder(t *testing.T) {
+	const (
+		projects = 6
+		perProj  = 40
+	)
+
+	_, _, js := natstest.RunJetStream(t)
+	ns := newNatsSaver(t, js, config.EventSaver{BatchSize: 7, Workers: 4, PartitionByProject: true})
+
+	for i := 0; i < perProj; i++ {
+		for p := 1; p <= projects; p++ {
+			ns.publish(t, fmt.Sprintf("p%d-%d", p, i), p, i)
+		}
+	}
+
+	ns.start(t)
+	waitSaved(t, ns.repo, projects*perProj)
+
+	ns.repo.mu.Lock()
+	defer ns.repo.mu.Unlock()
+
+	last := make(map[int]int)
+	for _, ev := range ns.repo.order {
+		if prev, ok := last[ev.ProjectId]; ok && ev.Priority != prev+1 {
+			t.Fatalf("project %d: event %d written after %d", ev.ProjectId, ev.Priority, prev)
+		}
+		last[ev.ProjectId] = ev.Priority
+	}
+}
+
+// TestPartitionedWorkersHoldQueuedEvents: пока воркер партиции пережидает недоступный ClickHouse,
+// события, ожидающие его в роутере, не истекают по AckWait и не доставляются повторно.
+func TestPartitionedWorkersHoldQueuedEvents(t *testing.T) {
+	const events = 20
+
+	_, _, js := natstest.RunJetStream(t)
+	ns := newNatsSaver(t, js, config.EventSaver{BatchSize: 5, Workers: 2, PartitionByProject: true})
+	// около 1.4 с повторов - несколько AckWait
+	ns.repo.failures = 30
+	ns.repo.err = fmt.Errorf("%w: dial tcp: connection refused", utils.ErrStorageUnavailable)
+
+	for i := 0; i < events; i++ {
+		ns.publish(t, fmt.Sprintf("e%d", i), 1, i)
+	}
+
+	ns.start(t)
+	waitSaved(t, ns.repo, events)
+	// повторная доставка, если она случилась, успеет дойти до записи
+	time.Sleep(time.Second)
+
+	ns.repo.mu.Lock()
+	defer ns.repo.mu.Unlock()
+
+	if len(ns.repo.order) != events {
+		t.Fatalf("wrote %d events, want %d: queued events were redelivered", len(ns.repo.order), events)
+	}
+}
+
+// TestSupervisorRestartsPanickedWorker: паника при записи роняет только воркер, супервизор
+// поднимает его снова, а неподтвержденную пачку JetStream доставляет повторно.
+func TestSupervisorRestartsPanickedWorker(t *testing.T) {
+	for _, partitioned := range []bool{false, true} {
+		t.Run(fmt.Sprintf("partitioned=%t", partitioned), func(t *testing.T) {
+			_, _, js := natstest.RunJetStream(t)
+			ns := newNatsSaver(t, js, config.EventSaver{BatchSize: 10, Workers: 2, PartitionByProject: partitioned})
+			ns.repo.panics = 2
+
+			for i := 0; i < 10; i++ {
+				ns.publish(t, fmt.Sprintf("e%d", i), i%3+1, i)
+			}
+
+			ns.start(t)
+			waitSaved(t, ns.repo, 10)
+		})
+	}
+}
+
+// TestWorkersSurviveNatsRestart: после перезапуска NATS воркеры продолжают читать
+// тот же durable-консьюмер без перезапуска процесса.
+func TestWorkersSurviveNatsRestart(t *testing.T) {
+	srv, nc, js := natstest.RunJetStream(t)
+	ns := newNatsSaver(t, js, config.EventSaver{BatchSize: 10, Workers: 2})
+
+	for i := 0; i < 5; i++ {
+		ns.publish(t, fmt.Sprintf("before-%d", i), 1, i)
+	}
+
+	ns.start(t)
+	waitSaved(t, ns.repo, 5)
+
+	natstest.Restart(t, srv)
+	deadline := time.Now().Add(5 * time.Second)
+	for !nc.IsConnected() {
+		if time.Now().After(deadline) {
+			t.Fatal("client did not reconnect")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	for i := 0; i < 5; i++ {
+		ns.publish(t, fmt.Sprintf("after-%d", i), 1, i)
+	}
+	waitSaved(t, ns.repo, 10)
+}
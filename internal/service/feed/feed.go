@@ -0,0 +1,118 @@
+package feed
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/voikin/hezzl-test/config"
+	"github.com/voikin/hezzl-test/internal/domain/event"
+	"github.com/voikin/hezzl-test/internal/repository"
+	"github.com/voikin/hezzl-test/internal/repository/bus"
+	"github.com/voikin/hezzl-test/internal/utils"
+)
+
+// Feed отдает изменения товаров одного проекта в реальном времени.
+// Каждый подписчик получает свою эфемерную подписку на subject своего проекта,
+// так что чужие события фильтрует шина, а не сервис.
+type Feed struct {
+	subscriber repository.EventSubscriber
+	cfg        config.Feed
+	slots      chan struct{}
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+func NewFeed(subscriber repository.EventSubscriber, cfg config.Feed) *Feed {
+	return &Feed{
+		subscriber: subscriber,
+		cfg:        cfg,
+		slots:      make(chan struct{}, cfg.MaxStreams),
+		done:       make(chan struct{}),
+	}
+}
+
+// Subscribe возвращает канал событий проекта, начиная с сообщения после lastSeq
+// (или только новые, если lastSeq == 0). Канал закрывается при отмене ctx, при Close
+// или если подписчик не успевает читать события.
+func (f *Feed) Subscribe(ctx context.Context, projectId int, lastSeq uint64) (<-chan event.StreamEvent, error) {
+	select {
+	case f.slots <- struct{}{}:
+	default:
+		return nil, utils.ErrTooManyStreams
+	}
+
+	out := make(chan event.StreamEvent, f.cfg.BufferSize)
+	var (
+		mu     sync.Mutex
+		closed bool
+	)
+	closeOut := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if !closed {
+			closed = true
+			close(out)
+		}
+	}
+
+	handler := func(msg bus.Message) {
+		ce, err := event.Unmarshal(msg.Data(), msg.Header(event.ContentTypeHeader))
+		if err != nil {
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if closed {
+			return
+		}
+
+		select {
+		case out <- event.StreamEvent{Seq: msg.Seq(), Event: ce}:
+		default:
+			log.Printf("feed: project %d subscriber is too slow, closing stream", projectId)
+			closed = true
+			close(out)
+		}
+	}
+
+	var startSeq uint64
+	if lastSeq > 0 {
+		startSeq = lastSeq + 1
+	}
+
+	unsubscribe, err := f.subscriber.Follow(event.GoodsSubject(projectId), startSeq, handler)
+	if err != nil {
+		<-f.slots
+		return nil, fmt.Errorf("feed.Subscribe: %w", err)
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-f.done:
+		}
+		_ = unsubscribe()
+		closeOut()
+		<-f.slots
+	}()
+
+	return out, nil
+}
+
+// Close закрывает все открытые потоки. http.Server.Shutdown не прерывает
+// долгие SSE/WebSocket-запросы, поэтому без этого он ждал бы их до дедлайна.
+func (f *Feed) Close() {
+	f.closeOnce.Do(func() { close(f.done) })
+}
+
+func (f *Feed) Heartbeat() time.Duration {
+	return f.cfg.Heartbeat
+}
+
+func (f *Feed) AllowedOrigins() []string {
+	return f.cfg.AllowedOrigins
+}
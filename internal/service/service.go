@@ -2,15 +2,27 @@ package service
 
 import (
 	"context"
+	"time"
 
-	"github.com/nats-io/nats.go"
+	"github.com/voikin/hezzl-test/config"
+	"github.com/voikin/hezzl-test/internal/domain/analytics"
+	"github.com/voikin/hezzl-test/internal/domain/audit"
+	"github.com/voikin/hezzl-test/internal/domain/deadletter"
+	"github.com/voikin/hezzl-test/internal/domain/diff"
 	"github.com/voikin/hezzl-test/internal/domain/event"
 	"github.com/voikin/hezzl-test/internal/domain/good"
 	"github.com/voikin/hezzl-test/internal/domain/project"
+	"github.com/voikin/hezzl-test/internal/domain/webhook"
 	"github.com/voikin/hezzl-test/internal/repository"
+	analyticsService "github.com/voikin/hezzl-test/internal/service/analytics"
+	auditService "github.com/voikin/hezzl-test/internal/service/audit"
+	deadLetterService "github.com/voikin/hezzl-test/internal/service/deadletter"
+	diffService "github.com/voikin/hezzl-test/internal/service/diff"
 	"github.com/voikin/hezzl-test/internal/service/eventSaver"
+	"github.com/voikin/hezzl-test/internal/service/feed"
 	goodService "github.com/voikin/hezzl-test/internal/service/good"
 	projectService "github.com/voikin/hezzl-test/internal/service/project"
+	webhookService "github.com/voikin/hezzl-test/internal/service/webhook"
 )
 
 type ProjectService interface {
@@ -30,8 +42,53 @@ type GoodService interface {
 	UpdateGoodPriority(ctx context.Context, projectID, goodID, newPriority int) ([]good.GoodPriority, error)
 }
 
+type WebhookService interface {
+	CreateWebhook(ctx context.Context, projectId int, url string, eventTypes []string, secret string) (webhook.Webhook, error)
+	UpdateWebhook(ctx context.Context, id, projectId int, url string, eventTypes []string, enabled bool) (webhook.Webhook, error)
+	DeleteWebhook(ctx context.Context, id, projectId int) (webhook.Webhook, error)
+	GetWebhook(ctx context.Context, id, projectId int) (webhook.Webhook, error)
+	GetWebhooks(ctx context.Context, projectId int) ([]webhook.Webhook, error)
+	GetDeliveries(ctx context.Context, id, projectId, limit int) ([]webhook.Delivery, error)
+}
+
+type AnalyticsService interface {
+	GetChanges(ctx context.Context, f analytics.Filter, granularity string) ([]analytics.ChangesBucket, error)
+	GetMostEdited(ctx context.Context, f analytics.Filter, limit int) ([]analytics.GoodEdits, error)
+	GetRemovalRate(ctx context.Context, f analytics.Filter) ([]analytics.RemovalRate, error)
+	GetReprioritizationChurn(ctx context.Context, f analytics.Filter) ([]analytics.ReprioritizationChurn, error)
+	GetActiveProjects(ctx context.Context, f analytics.Filter, limit int) ([]analytics.ActiveProject, error)
+}
+
+type AuditService interface {
+	GetAudit(ctx context.Context, f audit.Filter, cursor string, limit int) (audit.Page, error)
+}
+
+type DiffService interface {
+	GetGoodDiff(ctx context.Context, projectId, id int, from, to time.Time) (diff.GoodDiff, error)
+}
+
+type DeadLetterService interface {
+	GetDeadLetters(ctx context.Context, afterSeq uint64, limit int) ([]deadletter.DeadLetter, error)
+	GetDeadLetter(ctx context.Context, seq uint64) (deadletter.DeadLetter, error)
+	RedriveDeadLetter(ctx context.Context, seq uint64) (deadletter.DeadLetter, error)
+	PurgeDeadLetters(ctx context.Context) (uint64, error)
+}
+
+type WebhookDispatcher interface {
+	Start(ctx context.Context)
+	Wait(ctx context.Context) error
+}
+
+type Feed interface {
+	Subscribe(ctx context.Context, projectId int, lastSeq uint64) (<-chan event.StreamEvent, error)
+	Heartbeat() time.Duration
+	AllowedOrigins() []string
+	Close()
+}
+
 type EventSaver interface {
 	Start(ctx context.Context)
+	Wait(ctx context.Context) error
 }
 
 type Event interface {
@@ -41,13 +98,34 @@ type Event interface {
 type Service struct {
 	ProjectService
 	GoodService
+	WebhookService
+	AnalyticsService
+	AuditService
+	DiffService
+	DeadLetterService
+	WebhookDispatcher
+	Feed
 	EventSaver
 }
 
-func NewServices(repo *repository.Repository, js nats.JetStreamContext) *Service {
+func NewServices(repo *repository.Repository, cfg *config.Config) *Service {
+	dispatcher := webhookService.NewDispatcher(repo.WebhookRepo, repo.EventSubscriber, cfg.Webhook)
+	saver := eventSaver.NewEventSaver(repo.EventRepo, repo.DeadLetterRepo, repo.EventSubscriber, cfg.Nats.Consumer, cfg.EventSaver)
+	redrivers := map[string]deadLetterService.Redriver{
+		cfg.Nats.Consumer.Durable:    saver,
+		cfg.Webhook.Consumer.Durable: dispatcher,
+	}
+
 	return &Service{
-		ProjectService: projectService.NewProjectService(repo.ProjectRepo),
-		GoodService:    goodService.NewGoodService(repo.GoodRepo),
-		EventSaver:     eventSaver.NewEventSaver(repo.EventRepo, js),
+		ProjectService:    projectService.NewProjectService(repo.ProjectRepo),
+		GoodService:       goodService.NewGoodService(repo.GoodRepo),
+		WebhookService:    webhookService.NewWebhookService(repo.WebhookRepo),
+		AnalyticsService:  analyticsService.NewAnalyticsService(repo.AnalyticsRepo),
+		AuditService:      auditService.NewAuditService(repo.AuditRepo),
+		DiffService:       diffService.NewDiffService(repo.GoodRepo, repo.EventRepo),
+		DeadLetterService: deadLetterService.NewDeadLetterService(repo.DeadLetterRepo, redrivers),
+		WebhookDispatcher: dispatcher,
+		Feed:              feed.NewFeed(repo.EventSubscriber, cfg.Feed),
+		EventSaver:        saver,
 	}
 }
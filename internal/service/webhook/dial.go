@@ -0,0 +1,71 @@
+package webhook
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+
+	"github.com/voikin/hezzl-test/config"
+)
+
+// диапазоны, которые netip не считает ни приватными, ни специальными
+var _nonPublic = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),     // "эта сеть", 0.x.x.x уходит на локальный хост
+	netip.MustParsePrefix("100.64.0.0/10"), // CGNAT, RFC 6598
+}
+
+// newClient возвращает HTTP-клиент, который не ходит во внутреннюю сеть. Адрес проверяется
+// при каждом соединении уже после резолва, поэтому не помогают ни DNS-ребиндинг,
+// ни редирект на внутренний адрес.
+func newClient(cfg config.Webhook) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: cfg.Timeout,
+	}
+	if !cfg.AllowPrivate {
+		dialer.Control = rejectNonPublic
+	}
+
+	return &http.Client{
+		Timeout: cfg.Timeout,
+		Transport: &http.Transport{
+			// прокси из окружения выполнил бы соединение за нас и обошел проверку
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: cfg.Timeout,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+}
+
+func rejectNonPublic(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("webhook: unexpected address %q: %w", address, err)
+	}
+
+	if !isPublic(addrPort.Addr()) {
+		return fmt.Errorf("webhook: address %s is not public", addrPort.Addr())
+	}
+
+	return nil
+}
+
+func isPublic(addr netip.Addr) bool {
+	addr = addr.Unmap()
+
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+
+	for _, prefix := range _nonPublic {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+
+	return true
+}
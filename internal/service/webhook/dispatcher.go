@@ -0,0 +1,364 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/voikin/hezzl-test/config"
+	"github.com/voikin/hezzl-test/internal/domain/deadletter"
+	"github.com/voikin/hezzl-test/internal/domain/event"
+	"github.com/voikin/hezzl-test/internal/domain/webhook"
+	"github.com/voikin/hezzl-test/internal/repository"
+	"github.com/voikin/hezzl-test/internal/repository/bus"
+)
+
+const (
+	_fetchBatch = 10
+	_fetchWait  = 5 * time.Second
+	// запас к таймауту запроса, на который доставка уходит из очереди во время попытки
+	_leaseMargin = 30 * time.Second
+)
+
+// errUnencodable - событие не удалось сериализовать в тело запроса, повтор не поможет
+var errUnencodable = errors.New("payload is not encodable")
+
+// Dispatcher читает события отдельным durable-консьюмером и ставит их доставку в очередь
+// в PostgreSQL, после чего сразу подтверждает сообщение. Доставки и ретраи выполняет
+// отдельный цикл, так что медленный подписчик не держит сообщения шины.
+type Dispatcher struct {
+	repo       repository.WebhookRepo
+	subscriber repository.EventSubscriber
+	cfg        config.Webhook
+	client     *http.Client
+	// wake будит цикл доставок после постановки новых задач
+	wake chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewDispatcher(repo repository.WebhookRepo, subscriber repository.EventSubscriber, cfg config.Webhook) *Dispatcher {
+	return &Dispatcher{
+		repo:       repo,
+		subscriber: subscriber,
+		cfg:        cfg,
+		client:     newClient(cfg),
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+func (d *Dispatcher) Start(ctx context.Context) {
+	d.wg.Add(2)
+	go func() {
+		defer d.wg.Done()
+		d.run(ctx)
+	}()
+	go func() {
+		defer d.wg.Done()
+		d.work(ctx)
+	}()
+}
+
+// Wait ждет, пока после отмены ctx из Start закончатся текущие доставки, но не дольше ctx.
+func (d *Dispatcher) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("webhook.Dispatcher.Wait: %w", ctx.Err())
+	}
+}
+
+func (d *Dispatcher) run(ctx context.Context) {
+	consumer, err := d.subscriber.Consume(d.cfg.Consumer)
+	if err != nil {
+		log.Printf("webhook dispatcher Consume: %v", err)
+		return
+	}
+	defer consumer.Close()
+
+	for ctx.Err() == nil {
+		msgs, err := consumer.Fetch(ctx, _fetchBatch, _fetchWait)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("webhook dispatcher Fetch: %v", err)
+				time.Sleep(time.Second)
+			}
+			continue
+		}
+
+		for _, msg := range msgs {
+			d.handle(ctx, msg)
+		}
+	}
+}
+
+// handle ставит в очередь доставку события всем подходящим вебхукам и подтверждает сообщение.
+func (d *Dispatcher) handle(ctx context.Context, msg bus.Message) {
+	payload, err := decode(msg.Subject(), msg.Header(event.ContentTypeHeader), msg.Data())
+	if err != nil {
+		log.Printf("webhook dispatcher: %v", err)
+		_ = msg.Term()
+		return
+	}
+
+	err = d.enqueue(ctx, payload)
+	if errors.Is(err, errUnencodable) {
+		log.Printf("webhook dispatcher: %v", err)
+		_ = msg.Term()
+		return
+	} else if err != nil {
+		log.Printf("webhook dispatcher: %v", err)
+		_ = msg.Nak(d.cfg.RetryBackoff)
+		return
+	}
+
+	_ = msg.Ack()
+}
+
+// Redrive ставит в очередь доставку события мертвого письма, минуя шину.
+func (d *Dispatcher) Redrive(ctx context.Context, dl deadletter.DeadLetter) error {
+	payload, err := decode(dl.Subject, dl.ContentType, dl.Data)
+	if err != nil {
+		return fmt.Errorf("webhook.Dispatcher.Redrive: %w", err)
+	}
+
+	err = d.enqueue(ctx, payload)
+	if err != nil {
+		return fmt.Errorf("webhook.Dispatcher.Redrive: %w", err)
+	}
+	return nil
+}
+
+// enqueue ставит в очередь доставку события всем подходящим вебхукам и будит цикл доставок.
+func (d *Dispatcher) enqueue(ctx context.Context, payload webhook.Payload) error {
+	webhooks, err := d.repo.GetActiveWebhooks(ctx, payload.ProjectId, payload.Type)
+	if err != nil {
+		return fmt.Errorf("GetActiveWebhooks: %w", err)
+	}
+	if len(webhooks) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errUnencodable, err)
+	}
+
+	ids := make([]int, len(webhooks))
+	for i, wh := range webhooks {
+		ids[i] = wh.ID
+	}
+
+	err = d.repo.EnqueueJobs(ctx, ids, payload.EventId, payload.Type, body)
+	if err != nil {
+		return fmt.Errorf("EnqueueJobs: %w", err)
+	}
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// work забирает из очереди доставки, время которых подошло, и выполняет их параллельно.
+func (d *Dispatcher) work(ctx context.Context) {
+	ticker := time.NewTicker(d.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for ctx.Err() == nil {
+		n, err := d.deliverDue(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("webhook dispatcher: %v", err)
+		}
+
+		// пачка была полной - очередь, скорее всего, не пуста
+		if err == nil && n == d.cfg.Workers {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// deliverDue выполняет одну пачку доставок и возвращает ее размер.
+func (d *Dispatcher) deliverDue(ctx context.Context) (int, error) {
+	jobs, err := d.repo.ClaimJobs(ctx, d.cfg.Workers, d.cfg.Timeout+_leaseMargin)
+	if err != nil {
+		return 0, err
+	}
+
+	var wg sync.WaitGroup
+	for _, job := range jobs {
+		wg.Add(1)
+		go func(job webhook.Job) {
+			defer wg.Done()
+			d.deliver(ctx, job)
+		}(job)
+	}
+	wg.Wait()
+
+	return len(jobs), nil
+}
+
+// deliver делает одну попытку доставки и по ее результату удаляет задачу или откладывает
+// следующую попытку с экспоненциальной задержкой. Каждая попытка пишется в лог доставок.
+func (d *Dispatcher) deliver(ctx context.Context, job webhook.Job) {
+	wh := job.Webhook
+	delivery := d.post(ctx, job)
+	if ctx.Err() != nil {
+		// остановка: попытку не засчитываем, задача вернется в очередь после lease
+		return
+	}
+	delivery.Attempt = job.Attempt + 1
+
+	if err := d.repo.CreateDelivery(ctx, delivery); err != nil {
+		log.Printf("webhook dispatcher CreateDelivery: %v", err)
+	}
+
+	if delivery.Success {
+		if err := d.repo.DeleteJob(ctx, job.ID); err != nil {
+			log.Printf("webhook dispatcher DeleteJob: %v", err)
+		}
+		if wh.Failures != 0 {
+			if err := d.repo.ResetFailures(ctx, wh.ID); err != nil {
+				log.Printf("webhook dispatcher ResetFailures: %v", err)
+			}
+		}
+		return
+	}
+
+	if delivery.Attempt < d.cfg.MaxAttempts {
+		if err := d.repo.RescheduleJob(ctx, job.ID, delivery.Attempt, d.backoff(delivery.Attempt)); err != nil {
+			log.Printf("webhook dispatcher RescheduleJob: %v", err)
+		}
+		return
+	}
+
+	if err := d.repo.DeleteJob(ctx, job.ID); err != nil {
+		log.Printf("webhook dispatcher DeleteJob: %v", err)
+	}
+
+	disabled, err := d.repo.RecordFailure(ctx, wh.ID, d.cfg.DisableAfter)
+	if err != nil {
+		log.Printf("webhook dispatcher RecordFailure: %v", err)
+		return
+	}
+	if disabled {
+		log.Printf("webhook %d disabled after %d failed deliveries", wh.ID, d.cfg.DisableAfter)
+	}
+}
+
+// backoff возвращает задержку перед попыткой attempt+1: RetryBackoff * 2^(attempt-1), не больше MaxRetryBackoff.
+func (d *Dispatcher) backoff(attempt int) time.Duration {
+	backoff := d.cfg.RetryBackoff
+	for i := 1; i < attempt && backoff < d.cfg.MaxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > d.cfg.MaxRetryBackoff {
+		backoff = d.cfg.MaxRetryBackoff
+	}
+	return backoff
+}
+
+func (d *Dispatcher) post(ctx context.Context, job webhook.Job) webhook.Delivery {
+	wh := job.Webhook
+	delivery := webhook.Delivery{
+		WebhookId: wh.ID,
+		EventId:   job.EventId,
+		EventType: job.EventType,
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.URL, bytes.NewReader(job.Body))
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhook.EventIdHeader, job.EventId)
+	req.Header.Set(webhook.EventTypeHeader, job.EventType)
+	req.Header.Set(webhook.TimestampHeader, timestamp)
+	req.Header.Set(webhook.SignatureHeader, Sign(wh.Secret, timestamp, job.Body))
+
+	start := time.Now()
+	resp, err := d.client.Do(req)
+	delivery.DurationMs = time.Since(start).Milliseconds()
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	delivery.StatusCode = resp.StatusCode
+	delivery.Success = resp.StatusCode >= 200 && resp.StatusCode < 300
+	if !delivery.Success {
+		delivery.Error = resp.Status
+	}
+
+	return delivery
+}
+
+// Sign считает подпись тела запроса: sha256=hex(HMAC-SHA256(secret, timestamp + "." + body)).
+// Получатель проверяет ее тем же способом, используя заголовок X-Webhook-Timestamp.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func decode(subject, contentType string, data []byte) (webhook.Payload, error) {
+	switch {
+	case event.IsGoodsSubject(subject):
+		ce, err := event.Unmarshal(data, contentType)
+		if err != nil {
+			return webhook.Payload{}, err
+		}
+		return webhook.Payload{
+			EventId:    ce.EventId,
+			Type:       ce.Type,
+			ProjectId:  ce.ProjectId,
+			OccurredAt: ce.EventTime,
+			Data:       ce,
+		}, nil
+	case subject == event.SubjectProjects:
+		pe, err := event.UnmarshalProjectEvent(data, contentType)
+		if err != nil {
+			return webhook.Payload{}, err
+		}
+		return webhook.Payload{
+			EventId:    pe.EventId,
+			Type:       pe.Type,
+			ProjectId:  pe.Id,
+			OccurredAt: pe.EventTime,
+			Data:       pe,
+		}, nil
+	default:
+		return webhook.Payload{}, fmt.Errorf("unexpected subject %q", subject)
+	}
+}
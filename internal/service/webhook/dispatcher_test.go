@@ -0,0 +1,460 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/voikin/hezzl-test/config"
+	"github.com/voikin/hezzl-test/internal/domain/deadletter"
+	"github.com/voikin/hezzl-test/internal/domain/event"
+	"github.com/voikin/hezzl-test/internal/domain/webhook"
+	"github.com/voikin/hezzl-test/internal/repository/memory"
+	"github.com/voikin/hezzl-test/internal/utils"
+)
+
+// fakeRepo - WebhookRepo в памяти. Время доставок не учитывает: ClaimJobs отдает все задачи
+// включенных вебхуков по порядку id, а задержки только записывает.
+type fakeRepo struct {
+	mu         sync.Mutex
+	webhooks   map[int]*webhook.Webhook
+	jobs       map[int64]*webhook.Job
+	nextJob    int64
+	deliveries []webhook.Delivery
+	delays     []time.Duration
+	enqueueErr error
+}
+
+func newFakeRepo(webhooks ...webhook.Webhook) *fakeRepo {
+	r := &fakeRepo{
+		webhooks: make(map[int]*webhook.Webhook),
+		jobs:     make(map[int64]*webhook.Job),
+	}
+	for i := range webhooks {
+		wh := webhooks[i]
+		r.webhooks[wh.ID] = &wh
+	}
+	return r
+}
+
+func (r *fakeRepo) CreateWebhook(ctx context.Context, wh webhook.Webhook) (webhook.Webhook, error) {
+	return webhook.Webhook{}, errors.New("not implemented")
+}
+
+func (r *fakeRepo) UpdateWebhook(ctx context.Context, wh webhook.Webhook) (webhook.Webhook, error) {
+	return webhook.Webhook{}, errors.New("not implemented")
+}
+
+func (r *fakeRepo) DeleteWebhook(ctx context.Context, id, projectId int) (webhook.Webhook, error) {
+	return webhook.Webhook{}, errors.New("not implemented")
+}
+
+func (r *fakeRepo) GetWebhook(ctx context.Context, id, projectId int) (webhook.Webhook, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	wh, ok := r.webhooks[id]
+	if !ok || wh.ProjectId != projectId {
+		return webhook.Webhook{}, utils.ErrWebhookNotFound
+	}
+	return *wh, nil
+}
+
+func (r *fakeRepo) GetWebhooks(ctx context.Context, projectId int) ([]webhook.Webhook, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (r *fakeRepo) GetActiveWebhooks(ctx context.Context, projectId int, eventType string) ([]webhook.Webhook, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var webhooks []webhook.Webhook
+	for _, wh := range r.webhooks {
+		if wh.ProjectId == projectId && wh.Enabled && wh.Matches(eventType) {
+			webhooks = append(webhooks, *wh)
+		}
+	}
+	return webhooks, nil
+}
+
+func (r *fakeRepo) EnqueueJobs(ctx context.Context, webhookIds []int, eventId, eventType string, body []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.enqueueErr != nil {
+		return r.enqueueErr
+	}
+
+	for _, id := range webhookIds {
+		r.nextJob++
+		r.jobs[r.nextJob] = &webhook.Job{
+			ID:        r.nextJob,
+			Webhook:   webhook.Webhook{ID: id},
+			EventId:   eventId,
+			EventType: eventType,
+			Body:      body,
+		}
+	}
+	return nil
+}
+
+func (r *fakeRepo) ClaimJobs(ctx context.Context, limit int, lease time.Duration) ([]webhook.Job, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ids := make([]int64, 0, len(r.jobs))
+	for id, job := range r.jobs {
+		if r.webhooks[job.Webhook.ID].Enabled {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	jobs := make([]webhook.Job, 0, len(ids))
+	for _, id := range ids {
+		job := *r.jobs[id]
+		job.Webhook = *r.webhooks[job.Webhook.ID]
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+func (r *fakeRepo) RescheduleJob(ctx context.Context, id int64, attempt int, delay time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.jobs[id].Attempt = attempt
+	r.delays = append(r.delays, delay)
+	return nil
+}
+
+func (r *fakeRepo) DeleteJob(ctx context.Context, id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.jobs, id)
+	return nil
+}
+
+func (r *fakeRepo) RecordFailure(ctx context.Context, id, disableAfter int) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	wh := r.webhooks[id]
+	wh.Failures++
+	wh.Enabled = wh.Enabled && wh.Failures < disableAfter
+	if !wh.Enabled {
+		for jobId, job := range r.jobs {
+			if job.Webhook.ID == id {
+				delete(r.jobs, jobId)
+			}
+		}
+	}
+	return !wh.Enabled, nil
+}
+
+func (r *fakeRepo) ResetFailures(ctx context.Context, id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.webhooks[id].Failures = 0
+	return nil
+}
+
+func (r *fakeRepo) CreateDelivery(ctx context.Context, d webhook.Delivery) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.deliveries = append(r.deliveries, d)
+	return nil
+}
+
+func (r *fakeRepo) GetDeliveries(ctx context.Context, webhookId, limit int) ([]webhook.Delivery, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (r *fakeRepo) pending() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.jobs)
+}
+
+// fakeMessage запоминает, как его подтвердили.
+type fakeMessage struct {
+	subject string
+	data    []byte
+	acked   bool
+	naked   bool
+	termed  bool
+}
+
+func (m *fakeMessage) Subject() string      { return m.subject }
+func (m *fakeMessage) Data() []byte         { return m.data }
+func (m *fakeMessage) Header(string) string { return event.ContentTypeJSON }
+func (m *fakeMessage) Seq() uint64          { return 1 }
+func (m *fakeMessage) Timestamp() time.Time { return time.Now() }
+func (m *fakeMessage) NumDelivered() uint64 { return 1 }
+func (m *fakeMessage) Ack() error           { m.acked = true; return nil }
+func (m *fakeMessage) Nak(time.Duration) error {
+	m.naked = true
+	return nil
+}
+func (m *fakeMessage) Term() error       { m.termed = true; return nil }
+func (m *fakeMessage) InProgress() error { return nil }
+
+func testConfig() config.Webhook {
+	return config.Webhook{
+		Consumer:        config.Consumer{Durable: "webhooks", AckWait: time.Minute, MaxDeliver: 3},
+		Timeout:         time.Second,
+		MaxAttempts:     5,
+		RetryBackoff:    time.Second,
+		MaxRetryBackoff: time.Minute,
+		DisableAfter:    10,
+		PollInterval:    10 * time.Millisecond,
+		Workers:         16,
+		// httptest слушает loopback
+		AllowPrivate: true,
+	}
+}
+
+func goodEvent(t *testing.T, eventId string) []byte {
+	t.Helper()
+
+	data, err := event.Marshal(event.ClickhouseEvent{
+		EventId:   eventId,
+		Type:      event.TypeGoodCreated,
+		Id:        1,
+		ProjectId: 1,
+		Name:      "good",
+		EventTime: time.Now(),
+	}, event.ContentTypeJSON)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	return data
+}
+
+// drain выполняет доставки, пока очередь не опустеет.
+func drain(t *testing.T, d *Dispatcher, repo *fakeRepo) {
+	t.Helper()
+
+	for i := 0; repo.pending() != 0; i++ {
+		if i == 100 {
+			t.Fatalf("queue is not drained: %d jobs left", repo.pending())
+		}
+		if _, err := d.deliverDue(context.Background()); err != nil {
+			t.Fatalf("deliverDue: %v", err)
+		}
+	}
+}
+
+func TestHandleAcksBeforeDelivery(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+	}))
+	defer srv.Close()
+
+	repo := newFakeRepo(webhook.Webhook{ID: 1, ProjectId: 1, URL: srv.URL, Enabled: true})
+	d := NewDispatcher(repo, nil, testConfig())
+
+	msg := &fakeMessage{subject: event.SubjectGoods, data: goodEvent(t, "e1")}
+	d.handle(context.Background(), msg)
+
+	if !msg.acked {
+		t.Fatal("message is not acked after the delivery was queued")
+	}
+	if repo.pending() != 1 {
+		t.Fatalf("queued jobs = %d, want 1", repo.pending())
+	}
+	if n := requests.Load(); n != 0 {
+		t.Fatalf("handle made %d requests, want none", n)
+	}
+
+	repo.enqueueErr = errors.New("connection refused")
+	msg = &fakeMessage{subject: event.SubjectGoods, data: goodEvent(t, "e2")}
+	d.handle(context.Background(), msg)
+	if msg.acked || !msg.naked {
+		t.Fatalf("failed enqueue: acked = %t, naked = %t, want nak", msg.acked, msg.naked)
+	}
+
+	msg = &fakeMessage{subject: "events.unknown", data: []byte("{}")}
+	d.handle(context.Background(), msg)
+	if !msg.termed {
+		t.Fatal("message with an unknown subject is not terminated")
+	}
+}
+
+// TestRedriveEnqueuesDelivery: повтор мертвого письма ставит доставку в очередь напрямую
+// и возвращает ошибку, если поставить не удалось.
+func TestRedriveEnqueuesDelivery(t *testing.T) {
+	repo := newFakeRepo(webhook.Webhook{ID: 1, ProjectId: 1, URL: "http://example.com", Enabled: true})
+	d := NewDispatcher(repo, nil, testConfig())
+	dl := deadletter.DeadLetter{Subject: event.SubjectGoods, Consumer: "webhooks", ContentType: event.ContentTypeJSON, Data: goodEvent(t, "e1")}
+
+	repo.enqueueErr = errors.New("connection refused")
+	if err := d.Redrive(context.Background(), dl); err == nil {
+		t.Fatal("Redrive succeeded while the queue is unavailable")
+	}
+
+	repo.enqueueErr = nil
+	if err := d.Redrive(context.Background(), dl); err != nil {
+		t.Fatalf("Redrive: %v", err)
+	}
+	if repo.pending() != 1 {
+		t.Fatalf("queued jobs = %d, want 1", repo.pending())
+	}
+}
+
+func TestDeliverSignsRequest(t *testing.T) {
+	const secret = "s3cr3t"
+
+	var checked atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp := r.Header.Get(webhook.TimestampHeader)
+
+		if got, want := r.Header.Get(webhook.SignatureHeader), Sign(secret, timestamp, body); got != want {
+			t.Errorf("signature = %q, want %q", got, want)
+		}
+		if got := r.Header.Get(webhook.EventIdHeader); got != "e1" {
+			t.Errorf("event id header = %q, want e1", got)
+		}
+		if got := r.Header.Get(webhook.EventTypeHeader); got != event.TypeGoodCreated {
+			t.Errorf("event type header = %q, want %s", got, event.TypeGoodCreated)
+		}
+		checked.Store(true)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	repo := newFakeRepo(webhook.Webhook{ID: 1, ProjectId: 1, URL: srv.URL, Secret: secret, Enabled: true})
+	d := NewDispatcher(repo, nil, testConfig())
+
+	d.handle(context.Background(), &fakeMessage{subject: event.SubjectGoods, data: goodEvent(t, "e1")})
+	drain(t, d, repo)
+
+	if !checked.Load() {
+		t.Fatal("the webhook was not called")
+	}
+	if len(repo.deliveries) != 1 || !repo.deliveries[0].Success || repo.deliveries[0].StatusCode != http.StatusNoContent {
+		t.Fatalf("deliveries = %+v, want one successful", repo.deliveries)
+	}
+}
+
+func TestDeliverRetriesOn5xx(t *testing.T) {
+	statuses := []int{http.StatusInternalServerError, http.StatusServiceUnavailable, http.StatusOK}
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(statuses[requests.Add(1)-1])
+	}))
+	defer srv.Close()
+
+	repo := newFakeRepo(webhook.Webhook{ID: 1, ProjectId: 1, URL: srv.URL, Enabled: true, Failures: 2})
+	d := NewDispatcher(repo, nil, testConfig())
+
+	d.handle(context.Background(), &fakeMessage{subject: event.SubjectGoods, data: goodEvent(t, "e1")})
+	drain(t, d, repo)
+
+	if n := requests.Load(); n != 3 {
+		t.Fatalf("requests = %d, want 3", n)
+	}
+	for i, d := range repo.deliveries {
+		if d.Attempt != i+1 || d.StatusCode != statuses[i] || d.Success != (i == 2) {
+			t.Errorf("delivery %d = %+v", i, d)
+		}
+	}
+	if want := []time.Duration{time.Second, 2 * time.Second}; len(repo.delays) != 2 || repo.delays[0] != want[0] || repo.delays[1] != want[1] {
+		t.Errorf("retry delays = %v, want %v", repo.delays, want)
+	}
+	if f := repo.webhooks[1].Failures; f != 0 {
+		t.Errorf("failures after a successful delivery = %d, want 0", f)
+	}
+}
+
+func TestDeliverDisablesAfterFailures(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	cfg := testConfig()
+	cfg.MaxAttempts = 2
+	cfg.DisableAfter = 3
+	cfg.Workers = 1
+
+	repo := newFakeRepo(webhook.Webhook{ID: 1, ProjectId: 1, URL: srv.URL, Enabled: true})
+	d := NewDispatcher(repo, nil, cfg)
+
+	for _, id := range []string{"e1", "e2", "e3", "e4"} {
+		d.handle(context.Background(), &fakeMessage{subject: event.SubjectGoods, data: goodEvent(t, id)})
+	}
+	drain(t, d, repo)
+
+	wh := repo.webhooks[1]
+	if wh.Enabled {
+		t.Fatal("webhook is still enabled")
+	}
+	if wh.Failures != cfg.DisableAfter {
+		t.Errorf("failures = %d, want %d", wh.Failures, cfg.DisableAfter)
+	}
+	// четвертое событие не отправляется: его задача удалена вместе с выключением
+	if n := requests.Load(); n != int32(cfg.DisableAfter*cfg.MaxAttempts) {
+		t.Errorf("requests = %d, want %d", n, cfg.DisableAfter*cfg.MaxAttempts)
+	}
+}
+
+func TestDispatcherDeliversFromBus(t *testing.T) {
+	delivered := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered <- r.Header.Get(webhook.EventIdHeader)
+	}))
+	defer srv.Close()
+
+	eventBus := memory.NewEventBus(config.Stream{})
+	repo := newFakeRepo(webhook.Webhook{ID: 1, ProjectId: 1, URL: srv.URL, Enabled: true})
+	d := NewDispatcher(repo, eventBus, testConfig())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d.Start(ctx)
+	defer func() {
+		cancel()
+		if err := d.Wait(context.Background()); err != nil {
+			t.Errorf("Wait: %v", err)
+		}
+	}()
+
+	eventBus.Publish(event.SubjectGoods, "e1", event.ContentTypeJSON, goodEvent(t, "e1"))
+
+	select {
+	case id := <-delivered:
+		if id != "e1" {
+			t.Fatalf("delivered event %q, want e1", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the event was not delivered")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := NewDispatcher(nil, nil, config.Webhook{RetryBackoff: time.Second, MaxRetryBackoff: 10 * time.Second})
+
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for i, w := range want {
+		if got := d.backoff(i + 1); got != w {
+			t.Errorf("backoff(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+}
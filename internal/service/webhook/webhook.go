@@ -0,0 +1,133 @@
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/voikin/hezzl-test/internal/domain/event"
+	"github.com/voikin/hezzl-test/internal/domain/webhook"
+	"github.com/voikin/hezzl-test/internal/repository"
+)
+
+const _defaultDeliveriesLimit = 50
+
+type WebhookService struct {
+	repo repository.WebhookRepo
+}
+
+func NewWebhookService(repo repository.WebhookRepo) *WebhookService {
+	return &WebhookService{repo: repo}
+}
+
+func (ws *WebhookService) CreateWebhook(ctx context.Context, projectId int, rawURL string, eventTypes []string, secret string) (webhook.Webhook, error) {
+	err := validate(rawURL, eventTypes)
+	if err != nil {
+		return webhook.Webhook{}, err
+	}
+
+	if secret == "" {
+		secret, err = newSecret()
+		if err != nil {
+			return webhook.Webhook{}, err
+		}
+	}
+
+	return ws.repo.CreateWebhook(ctx, webhook.Webhook{
+		ProjectId:  projectId,
+		URL:        rawURL,
+		EventTypes: eventTypes,
+		Secret:     secret,
+	})
+}
+
+func (ws *WebhookService) UpdateWebhook(ctx context.Context, id, projectId int, rawURL string, eventTypes []string, enabled bool) (webhook.Webhook, error) {
+	err := validate(rawURL, eventTypes)
+	if err != nil {
+		return webhook.Webhook{}, err
+	}
+
+	wh, err := ws.repo.UpdateWebhook(ctx, webhook.Webhook{
+		ID:         id,
+		ProjectId:  projectId,
+		URL:        rawURL,
+		EventTypes: eventTypes,
+		Enabled:    enabled,
+	})
+	wh.Secret = ""
+	return wh, err
+}
+
+func (ws *WebhookService) DeleteWebhook(ctx context.Context, id, projectId int) (webhook.Webhook, error) {
+	wh, err := ws.repo.DeleteWebhook(ctx, id, projectId)
+	wh.Secret = ""
+	return wh, err
+}
+
+func (ws *WebhookService) GetWebhook(ctx context.Context, id, projectId int) (webhook.Webhook, error) {
+	wh, err := ws.repo.GetWebhook(ctx, id, projectId)
+	wh.Secret = ""
+	return wh, err
+}
+
+func (ws *WebhookService) GetWebhooks(ctx context.Context, projectId int) ([]webhook.Webhook, error) {
+	webhooks, err := ws.repo.GetWebhooks(ctx, projectId)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+
+	return webhooks, nil
+}
+
+func (ws *WebhookService) GetDeliveries(ctx context.Context, id, projectId, limit int) ([]webhook.Delivery, error) {
+	_, err := ws.repo.GetWebhook(ctx, id, projectId)
+	if err != nil {
+		return nil, err
+	}
+
+	if limit <= 0 {
+		limit = _defaultDeliveriesLimit
+	}
+
+	return ws.repo.GetDeliveries(ctx, id, limit)
+}
+
+func validate(rawURL string, eventTypes []string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("the url must be an absolute http(s) url")
+	}
+
+	for _, t := range eventTypes {
+		if !isKnownType(t) {
+			return fmt.Errorf("unknown event type %q", t)
+		}
+	}
+
+	return nil
+}
+
+func isKnownType(eventType string) bool {
+	for _, t := range event.Types {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("webhook.newSecret: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
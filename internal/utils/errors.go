@@ -5,3 +5,17 @@ import "errors"
 var ErrProjectNotFound = errors.New("error.project.notFound")
 
 var ErrGoodNotFound = errors.New("error.good.notFound")
+
+var ErrWebhookNotFound = errors.New("error.webhook.notFound")
+
+var ErrTooManyStreams = errors.New("error.feed.tooManyStreams")
+
+var ErrDeadLetterNotFound = errors.New("error.deadLetter.notFound")
+
+var ErrInvalidCursor = errors.New("error.audit.invalidCursor")
+
+var ErrGoodVersionNotFound = errors.New("error.good.versionNotFound")
+
+var ErrStorageUnavailable = errors.New("error.storage.unavailable")
+
+var ErrDeadLetterNotRedrivable = errors.New("error.deadLetter.notRedrivable")
@@ -0,0 +1,3 @@
+ALTER TABLE logs.goods
+    ADD COLUMN IF NOT EXISTS EventId String DEFAULT '',
+    ADD COLUMN IF NOT EXISTS Type LowCardinality(String) DEFAULT '';
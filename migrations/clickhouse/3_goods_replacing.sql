@@ -0,0 +1,19 @@
+-- ReplacingMergeTree схлопывает строки с одинаковым ключом сортировки, а EventId в конце ключа
+-- делает ключ уникальным для события: повторная доставка или replay не дают дублей после слияния.
+-- Читать точно - через FINAL или argMax.
+CREATE TABLE
+    IF NOT EXISTS logs.goods_v2 (
+        EventId String,
+        Type LowCardinality(String),
+        Id Int32,
+        ProjectId Int32,
+        Name String,
+        Description String,
+        Priority Int32,
+        Removed Bool,
+        EventTime DateTime
+    ) ENGINE = ReplacingMergeTree()
+PARTITION BY
+    toYYYYMM(EventTime)
+ORDER BY
+    (ProjectId, Id, EventTime, EventId);
@@ -0,0 +1,27 @@
+-- Разовый перенос старых строк в goods_v2 и подмена таблиц.
+-- Строкам без EventId выдается id по содержимому по той же схеме, что и event.ContentId в Go
+-- (время - с точностью до секунды, как оно хранится в DateTime), поэтому дубли старой таблицы схлопнутся.
+INSERT INTO logs.goods_v2 (EventId, Type, Id, ProjectId, Name, Description, Priority, Removed, EventTime)
+SELECT
+    if(
+        EventId != '',
+        EventId,
+        concat('sha256:', lower(hex(substring(SHA256(concat(
+            toString(Id), '|', toString(ProjectId), '|', Name, '|', Description, '|',
+            toString(Priority), '|', if(Removed, 'true', 'false'), '|',
+            toString(toUInt64(toUnixTimestamp(EventTime)) * 1000000000)
+        )), 1, 16))))
+    ),
+    Type,
+    Id,
+    ProjectId,
+    Name,
+    Description,
+    Priority,
+    Removed,
+    EventTime
+FROM logs.goods;
+
+RENAME TABLE logs.goods TO logs.goods_old, logs.goods_v2 TO logs.goods;
+
+-- logs.goods_old остается для сверки, удалить вручную: DROP TABLE logs.goods_old
@@ -0,0 +1,7 @@
+-- EventTime хранит секунды, и две правки товара в одну секунду упорядочивались только по
+-- случайному EventId. EventTimeNs - время публикации с наносекундами, по нему (и затем по EventId)
+-- сортируются история, последнее состояние и журнал аудита. EventTime остается в ключе
+-- сортировки и партиционирования, фильтры по времени идут по нему.
+-- У строк, записанных до миграции, EventTimeNs равен EventTime: их порядок внутри секунды не восстановить.
+ALTER TABLE logs.goods
+    ADD COLUMN IF NOT EXISTS EventTimeNs DateTime64(9) DEFAULT EventTime;
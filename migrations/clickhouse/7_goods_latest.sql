@@ -0,0 +1,47 @@
+-- Последнее событие каждого товара. Срок хранения из clickhouse.retention на эту таблицу
+-- не действует: после удаления старых строк logs.goods товар не пропадает из состояний,
+-- истории и diff. ReplacingMergeTree по версии EventTimeNs оставляет самое новое событие,
+-- поэтому повторная вставка тех же строк ничего не портит.
+CREATE TABLE
+    IF NOT EXISTS logs.goods_latest (
+        EventId String,
+        Type LowCardinality(String),
+        Id Int32,
+        ProjectId Int32,
+        Name String,
+        Description String,
+        Priority Int32,
+        Removed Bool,
+        EventTime DateTime,
+        EventTimeNs DateTime64(9)
+    ) ENGINE = ReplacingMergeTree(EventTimeNs)
+ORDER BY
+    (ProjectId, Id);
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS logs.goods_latest_mv TO logs.goods_latest AS
+SELECT
+    EventId,
+    Type,
+    Id,
+    ProjectId,
+    Name,
+    Description,
+    Priority,
+    Removed,
+    EventTime,
+    EventTimeNs
+FROM logs.goods;
+
+INSERT INTO logs.goods_latest
+SELECT
+    EventId,
+    Type,
+    Id,
+    ProjectId,
+    Name,
+    Description,
+    Priority,
+    Removed,
+    EventTime,
+    EventTimeNs
+FROM logs.goods;
@@ -0,0 +1,10 @@
+// Package migrations встраивает скрипты схем в бинарники, которым они нужны.
+package migrations
+
+import "embed"
+
+// Clickhouse - скрипты migrations/clickhouse. При первом старте контейнера их выполняет
+// сам ClickHouse, на существующей базе недостающие применяет cmd/migrate.
+//
+//go:embed clickhouse/*.sql
+var Clickhouse embed.FS
@@ -0,0 +1,42 @@
+-- +goose Up
+-- +goose StatementBegin
+
+CREATE TABLE
+    IF NOT EXISTS webhooks (
+        id SERIAL PRIMARY KEY,
+        project_id INTEGER NOT NULL REFERENCES projects (id) ON DELETE CASCADE,
+        url VARCHAR(2048) NOT NULL,
+        event_types TEXT[] NOT NULL DEFAULT '{}',
+        secret VARCHAR(255) NOT NULL,
+        enabled BOOLEAN NOT NULL DEFAULT true,
+        failures INTEGER NOT NULL DEFAULT 0,
+        created_at TIMESTAMP NOT NULL default now ()
+    );
+
+CREATE index ON webhooks USING btree (project_id);
+
+CREATE TABLE
+    IF NOT EXISTS webhook_deliveries (
+        id SERIAL PRIMARY KEY,
+        webhook_id INTEGER NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+        event_id VARCHAR(64) NOT NULL,
+        event_type VARCHAR(64) NOT NULL,
+        attempt INTEGER NOT NULL,
+        status_code INTEGER NOT NULL DEFAULT 0,
+        error TEXT NOT NULL DEFAULT '',
+        success BOOLEAN NOT NULL,
+        duration_ms BIGINT NOT NULL DEFAULT 0,
+        created_at TIMESTAMP NOT NULL default now ()
+    );
+
+CREATE index ON webhook_deliveries USING btree (webhook_id, created_at);
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE IF EXISTS webhook_deliveries;
+
+DROP TABLE IF EXISTS webhooks;
+
+-- +goose StatementEnd
@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+
+CREATE TABLE
+    IF NOT EXISTS webhook_jobs (
+        id BIGSERIAL PRIMARY KEY,
+        webhook_id INTEGER NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+        event_id VARCHAR(64) NOT NULL,
+        event_type VARCHAR(64) NOT NULL,
+        body TEXT NOT NULL,
+        attempt INTEGER NOT NULL DEFAULT 0,
+        next_attempt_at TIMESTAMP NOT NULL default now (),
+        created_at TIMESTAMP NOT NULL default now (),
+        UNIQUE (webhook_id, event_id)
+    );
+
+CREATE index ON webhook_jobs USING btree (next_attempt_at);
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE IF EXISTS webhook_jobs;
+
+-- +goose StatementEnd